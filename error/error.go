@@ -33,7 +33,9 @@
 package error
 
 import (
+	stderrors "errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/pingcap/errors"
@@ -110,6 +112,57 @@ func (d *PDError) Error() string {
 	return d.Err.String()
 }
 
+// AsPDError returns the *PDError in err, which may be wrapped, e.g. by errors.Trace, errors.AddStack or
+// fmt.Errorf with %w.
+func AsPDError(err error) (*PDError, bool) {
+	var pdErr *PDError
+	if stderrors.As(err, &pdErr) {
+		return pdErr, true
+	}
+	pdErr, ok := errors.Cause(err).(*PDError)
+	return pdErr, ok
+}
+
+// PDErrorHeader returns the error in the response header of PD wrapped by the *PDError in err, or nil if there
+// isn't one.
+func PDErrorHeader(err error) *pdpb.Error {
+	if pdErr, ok := AsPDError(err); ok {
+		return pdErr.Err
+	}
+	return nil
+}
+
+// IsPDRegionNotFound returns true if err is PD's response that the region doesn't exist, e.g. it's merged. The PD
+// client returns the error in the response header as a plain error, so its message is checked as well.
+func IsPDRegionNotFound(err error) bool {
+	if header := PDErrorHeader(err); header != nil {
+		return header.GetType() == pdpb.ErrorType_REGION_NOT_FOUND
+	}
+	return err != nil && strings.Contains(err.Error(), pdpb.ErrorType_REGION_NOT_FOUND.String())
+}
+
+// pdLeaderChangeMsgs are the messages of the errors PD returns when the request is served by a PD which is not
+// the leader, e.g. during a leader change. PD of this version has no error type for them.
+var pdLeaderChangeMsgs = []string{"not leader", "mismatch leader id", "no leader"}
+
+// IsPDLeaderChange returns true if err is caused by the PD leader change, in which case the request can be
+// retried once the PD client reconnects to the new leader.
+func IsPDLeaderChange(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	if header := PDErrorHeader(err); header != nil {
+		msg = header.GetMessage()
+	}
+	for _, leaderChangeMsg := range pdLeaderChangeMsgs {
+		if strings.Contains(msg, leaderChangeMsg) {
+			return true
+		}
+	}
+	return false
+}
+
 // ErrKeyExist wraps *pdpb.AlreadyExist to implement the error interface.
 type ErrKeyExist struct {
 	*kvrpcpb.AlreadyExist
@@ -202,6 +255,156 @@ func (e *ErrPDServerTimeout) Error() string {
 	return e.msg
 }
 
+// IsPDServerTimeout returns true if err is an ErrPDServerTimeout, which may be wrapped, e.g. by errors.Trace,
+// errors.AddStack or fmt.Errorf with %w.
+func IsPDServerTimeout(err error) bool {
+	var timeoutErr *ErrPDServerTimeout
+	if stderrors.As(err, &timeoutErr) {
+		return true
+	}
+	_, ok := errors.Cause(err).(*ErrPDServerTimeout)
+	return ok
+}
+
+// ErrSplitRegionBatches aggregates the errors of all failed batches of a split regions request.
+type ErrSplitRegionBatches struct {
+	Errors []error
+}
+
+func (e *ErrSplitRegionBatches) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d split region batches failed: [%s]", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// ErrInsufficientHealthyStores is the error that the regions aren't scattered since there aren't enough
+// healthy stores to scatter them to.
+type ErrInsufficientHealthyStores struct {
+	Healthy  int
+	Required int
+}
+
+func (e *ErrInsufficientHealthyStores) Error() string {
+	return fmt.Sprintf("skip scattering regions, only %d healthy stores, at least %d are required", e.Healthy, e.Required)
+}
+
+// ErrCheckScatterTimeout is the error that whether the region is being scattered isn't known in time, e.g. PD
+// keeps failing or is slow to respond.
+type ErrCheckScatterTimeout struct {
+	RegionID uint64
+	Timeout  time.Duration
+	// Err is the last error of querying PD.
+	Err error
+}
+
+func (e *ErrCheckScatterTimeout) Error() string {
+	return fmt.Sprintf("check scatter of region %d timeout after %v: %v", e.RegionID, e.Timeout, e.Err)
+}
+
+// ErrScatterWaitTimeout is the error that the region is still being scattered when the wait for it runs out of
+// time, PD keeps scattering it in the background.
+type ErrScatterWaitTimeout struct {
+	RegionID uint64
+	// Backoff is the max wait time(in ms).
+	Backoff int
+}
+
+func (e *ErrScatterWaitTimeout) Error() string {
+	return fmt.Sprintf("wait scatter region timeout, region %d, backoff %dms", e.RegionID, e.Backoff)
+}
+
+// ErrGCLockStuck is the error that GC keeps failing to resolve the locks of a transaction, and the status of the
+// transaction can't be settled by its primary lock either.
+type ErrGCLockStuck struct {
+	TxnID   uint64
+	Primary []byte
+	// Retries is the number of rounds failing to resolve the locks.
+	Retries int
+	// Err is the error of checking the primary lock or resolving the locks.
+	Err error
+}
+
+func (e *ErrGCLockStuck) Error() string {
+	return fmt.Sprintf("gc resolve locks of txn %d stuck after %d retries, primary key %q: %v", e.TxnID, e.Retries, e.Primary, e.Err)
+}
+
+// ErrOperation attaches the operation and the key range or regions it works on to the error returned by an entry
+// point, e.g. SplitRegions or GC, so the callers can tell what fails without parsing the message. It's transparent
+// to errors.Cause, so the error it wraps is still checked as before. Use OperationOf to retrieve it.
+type ErrOperation struct {
+	// Op is the name of the operation, e.g. "SplitRegions".
+	Op string
+	// StartKey and EndKey bound the keys the operation works on, e.g. the range of GC or the smallest and largest
+	// split keys, both are nil if it doesn't work on keys. An empty EndKey means unbounded.
+	StartKey []byte
+	EndKey   []byte
+	// RegionIDs are the regions the operation works on, e.g. the regions to scatter.
+	RegionIDs []uint64
+	Err       error
+}
+
+func (e *ErrOperation) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Op)
+	if e.StartKey != nil || e.EndKey != nil {
+		fmt.Fprintf(&b, " on keys [%q, %q]", e.StartKey, e.EndKey)
+	}
+	if len(e.RegionIDs) > 0 {
+		fmt.Fprintf(&b, " on regions %v", e.RegionIDs)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	return b.String()
+}
+
+// Cause returns the wrapped error for errors.Cause.
+func (e *ErrOperation) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the wrapped error for the errors package of the standard library.
+func (e *ErrOperation) Unwrap() error {
+	return e.Err
+}
+
+// WithOperation wraps err with the operation working on the keys in [startKey, endKey], it returns nil if err is
+// nil.
+func WithOperation(err error, op string, startKey, endKey []byte) error {
+	if err == nil {
+		return nil
+	}
+	return &ErrOperation{Op: op, StartKey: startKey, EndKey: endKey, Err: err}
+}
+
+// WithRegionOperation wraps err with the operation working on the regions, it returns nil if err is nil.
+func WithRegionOperation(err error, op string, regionIDs ...uint64) error {
+	if err == nil {
+		return nil
+	}
+	return &ErrOperation{Op: op, RegionIDs: regionIDs, Err: err}
+}
+
+// OperationOf returns the *ErrOperation in err, which may be wrapped, e.g. by errors.Trace.
+func OperationOf(err error) (*ErrOperation, bool) {
+	var opErr *ErrOperation
+	if stderrors.As(err, &opErr) {
+		return opErr, true
+	}
+	// errors.Cause skips the *ErrOperation, walk the chain by hand.
+	for err != nil {
+		if opErr, ok := err.(*ErrOperation); ok {
+			return opErr, true
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = causer.Cause()
+	}
+	return nil, false
+}
+
 // ErrGCTooEarly is the error that GC life time is shorter than transaction duration
 type ErrGCTooEarly struct {
 	TxnStartTS  time.Time
@@ -0,0 +1,106 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPDErrorHelpers(t *testing.T) {
+	timeoutErr := NewErrPDServerTimeout("pd timeout")
+	pdErr := &PDError{Err: &pdpb.Error{Type: pdpb.ErrorType_REGION_NOT_FOUND}}
+	wrap := []func(error) error{
+		func(err error) error { return err },
+		errors.Trace,
+		errors.AddStack,
+		func(err error) error { return fmt.Errorf("scatter: %w", err) },
+	}
+	for _, w := range wrap {
+		assert.True(t, IsPDServerTimeout(w(timeoutErr)))
+		assert.False(t, IsPDServerTimeout(w(pdErr)))
+
+		e, ok := AsPDError(w(pdErr))
+		assert.True(t, ok)
+		assert.Equal(t, pdpb.ErrorType_REGION_NOT_FOUND, e.Err.GetType())
+		_, ok = AsPDError(w(timeoutErr))
+		assert.False(t, ok)
+	}
+	assert.False(t, IsPDServerTimeout(nil))
+	_, ok := AsPDError(errors.New("other"))
+	assert.False(t, ok)
+}
+
+func TestPDErrorKinds(t *testing.T) {
+	notFound := &PDError{Err: &pdpb.Error{Type: pdpb.ErrorType_REGION_NOT_FOUND, Message: "region 1 not found"}}
+	leaderChange := &PDError{Err: &pdpb.Error{Type: pdpb.ErrorType_UNKNOWN, Message: "not leader"}}
+	for _, w := range []func(error) error{
+		func(err error) error { return err },
+		errors.Trace,
+		func(err error) error { return fmt.Errorf("wait scatter: %w", err) },
+	} {
+		assert.Equal(t, notFound.Err, PDErrorHeader(w(notFound)))
+		assert.True(t, IsPDRegionNotFound(w(notFound)))
+		assert.False(t, IsPDLeaderChange(w(notFound)))
+
+		assert.Equal(t, leaderChange.Err, PDErrorHeader(w(leaderChange)))
+		assert.True(t, IsPDLeaderChange(w(leaderChange)))
+		assert.False(t, IsPDRegionNotFound(w(leaderChange)))
+	}
+
+	// The PD client returns some errors as plain errors.
+	assert.Nil(t, PDErrorHeader(errors.New("rpc error: code = Unknown desc = mismatch leader id")))
+	assert.True(t, IsPDLeaderChange(errors.New("rpc error: code = Unknown desc = mismatch leader id")))
+	assert.True(t, IsPDRegionNotFound(errors.New("scatter region failed: REGION_NOT_FOUND")))
+	assert.False(t, IsPDLeaderChange(NewErrPDServerTimeout("")))
+	assert.False(t, IsPDLeaderChange(nil))
+	assert.False(t, IsPDRegionNotFound(nil))
+}
+
+func TestErrOperation(t *testing.T) {
+	assert.Nil(t, WithOperation(nil, "GC", nil, nil))
+	assert.Nil(t, WithRegionOperation(nil, "ScatterRegions", 1))
+
+	cause := NewErrPDServerTimeout("pd timeout")
+	err := WithOperation(errors.Trace(cause), "SplitRegions", []byte("a"), []byte("z"))
+	assert.Equal(t, `SplitRegions on keys ["a", "z"]: pd timeout`, err.Error())
+	assert.True(t, IsPDServerTimeout(err))
+	assert.Equal(t, cause, errors.Cause(err))
+	for _, w := range []func(error) error{
+		func(err error) error { return err },
+		errors.Trace,
+		func(err error) error { return fmt.Errorf("pre-split: %w", err) },
+	} {
+		opErr, ok := OperationOf(w(err))
+		assert.True(t, ok)
+		assert.Equal(t, "SplitRegions", opErr.Op)
+		assert.Equal(t, []byte("a"), opErr.StartKey)
+		assert.Equal(t, []byte("z"), opErr.EndKey)
+	}
+
+	err = WithRegionOperation(cause, "WaitScatterRegionFinish", 2)
+	assert.Equal(t, "WaitScatterRegionFinish on regions [2]: pd timeout", err.Error())
+	opErr, ok := OperationOf(err)
+	assert.True(t, ok)
+	assert.Equal(t, []uint64{2}, opErr.RegionIDs)
+
+	_, ok = OperationOf(cause)
+	assert.False(t, ok)
+	_, ok = OperationOf(nil)
+	assert.False(t, ok)
+}
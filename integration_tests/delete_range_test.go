@@ -163,3 +163,31 @@ func (s *testDeleteRangeSuite) TestDeleteRange() {
 	s.mustDeleteRange([]byte("c5"), []byte("d5"), testData, 2)
 	s.mustDeleteRange([]byte("a"), []byte("z"), testData, 4)
 }
+
+func (s *testDeleteRangeSuite) TestDeleteRangeStat() {
+	txn, err := s.store.Begin()
+	s.Nil(err)
+	testData := map[string]string{}
+	for _, key := range []string{"a0", "b0", "c0", "d0"} {
+		testData[key] = "v"
+		s.Nil(txn.Set([]byte(key), []byte("v")))
+	}
+	s.Nil(txn.Commit(context.Background()))
+
+	stat, err := s.store.DeleteRange(context.Background(), []byte("b"), []byte("d"), 2)
+	s.Nil(err)
+	s.Equal(2, stat.CompletedRegions)
+	deleteRangeFromMap(testData, []byte("b"), []byte("d"))
+	s.checkData(testData)
+
+	// Nothing is deleted if ctx is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stat, err = s.store.DeleteRange(ctx, []byte("a"), []byte("z"), 2)
+	s.NotNil(err)
+	s.Equal(0, stat.CompletedRegions)
+	s.checkData(testData)
+
+	_, err = s.store.DeleteRange(context.Background(), []byte("a"), nil, 2)
+	s.NotNil(err)
+}
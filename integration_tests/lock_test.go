@@ -543,6 +543,206 @@ func (s *testLockSuite) TestBatchResolveLocks() {
 	s.True(bytes.Equal(v, []byte("v4")))
 }
 
+func (s *testLockSuite) TestBatchResolveLocksInRegion() {
+	_, err := s.store.SplitRegions(context.Background(), [][]byte{[]byte("k3")}, false, nil)
+	s.Nil(err)
+
+	txn, err := s.store.Begin()
+	s.Nil(err)
+	txn.Set([]byte("k1"), []byte("v1"))
+	txn.Set([]byte("k5"), []byte("v5"))
+	s.prewriteTxnWithTTL(txn, 20000)
+	locks := []*tikv.Lock{s.mustGetLock([]byte("k1")), s.mustGetLock([]byte("k5"))}
+
+	lr := s.store.NewLockResolver()
+	bo := tikv.NewGcResolveLockMaxBackoffer(context.Background())
+	loc, err := s.store.GetRegionCache().LocateKey(bo, []byte("k1"))
+	s.Nil(err)
+	s.False(loc.Contains([]byte("k5")))
+	// Only the lock out of the region remains.
+	remain, err := lr.BatchResolveLocksInRegion(bo, locks, loc)
+	s.Nil(err)
+	s.Len(remain, 1)
+	s.Equal([]byte("k5"), remain[0].Key)
+
+	loc, err = s.store.GetRegionCache().LocateKey(bo, []byte("k5"))
+	s.Nil(err)
+	remain, err = lr.BatchResolveLocksInRegion(bo, remain, loc)
+	s.Nil(err)
+	s.Len(remain, 0)
+
+	txn, err = s.store.Begin()
+	s.Nil(err)
+	for _, key := range []string{"k1", "k5"} {
+		_, err = txn.Get(context.Background(), []byte(key))
+		s.Equal(err, tikverr.ErrNotExist)
+	}
+}
+
+func (s *testLockSuite) TestResolveLock() {
+	txn, err := s.store.Begin()
+	s.Nil(err)
+	txn.Set([]byte("k1"), []byte("v1"))
+	txn.Set([]byte("k2"), []byte("v2"))
+	s.prewriteTxnWithTTL(txn, 20000)
+	startTS := txn.StartTS()
+
+	// Locks of other transactions are not touched.
+	err = s.store.ResolveLock(context.Background(), []byte("k1"), startTS-1)
+	s.Nil(err)
+	s.Equal(startTS, s.mustGetLock([]byte("k1")).TxnID)
+
+	err = s.store.ResolveLock(context.Background(), []byte("k1"), startTS)
+	s.Nil(err)
+	// The lock is already resolved.
+	err = s.store.ResolveLock(context.Background(), []byte("k1"), startTS)
+	s.Nil(err)
+
+	txn, err = s.store.Begin()
+	s.Nil(err)
+	_, err = txn.Get(context.Background(), []byte("k1"))
+	s.Equal(err, tikverr.ErrNotExist)
+}
+
+func (s *testLockSuite) TestGCResolvePessimisticLocks() {
+	txn, err := s.store.Begin()
+	s.Nil(err)
+	txn.SetPessimistic(true)
+	lockCtx := &kv.LockCtx{ForUpdateTS: txn.StartTS(), WaitStartTime: time.Now()}
+	s.Nil(txn.LockKeys(context.Background(), lockCtx, []byte("k1")))
+
+	safePoint, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Nil(err)
+	_, err = s.store.GC(context.Background(), safePoint, tikv.WithGCSkipPessimisticLocks())
+	s.Nil(err)
+	locks, err := s.store.ScanLocks(context.Background(), []byte("k1"), safePoint)
+	s.Nil(err)
+	s.Len(locks, 1)
+	s.Equal(kvrpcpb.Op_PessimisticLock, locks[0].LockType)
+
+	// The lock is kept alive by the transaction, so it's not expired.
+	_, err = s.store.GC(context.Background(), safePoint, tikv.WithGCExpiredPessimisticLocksOnly())
+	s.Nil(err)
+	locks, err = s.store.ScanLocks(context.Background(), []byte("k1"), safePoint)
+	s.Nil(err)
+	s.Len(locks, 1)
+
+	_, err = s.store.GC(context.Background(), safePoint)
+	s.Nil(err)
+	locks, err = s.store.ScanLocks(context.Background(), []byte("k1"), safePoint)
+	s.Nil(err)
+	s.Len(locks, 0)
+	s.Nil(txn.Rollback())
+}
+
+func (s *testLockSuite) TestGCDryRun() {
+	for _, k := range []string{"k1", "k2", "k3"} {
+		s.lockKey([]byte(k), []byte("v"), []byte(k), []byte("v"), false)
+	}
+	safePoint, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Nil(err)
+	oldSafePoint, err := s.store.GetGCSafePoint(context.Background())
+	s.Nil(err)
+
+	lockCount, regionCount, err := s.store.GCDryRun(context.Background(), safePoint)
+	s.Nil(err)
+	s.GreaterOrEqual(lockCount, uint64(3))
+	s.Greater(regionCount, uint64(0))
+
+	// Nothing is changed by the dry run.
+	locks, err := s.store.ScanLocks(context.Background(), []byte("k1"), safePoint)
+	s.Nil(err)
+	s.GreaterOrEqual(len(locks), 3)
+	newSafePoint, err := s.store.GetGCSafePoint(context.Background())
+	s.Nil(err)
+	s.Equal(oldSafePoint, newSafePoint)
+
+	_, err = s.store.GC(context.Background(), safePoint)
+	s.Nil(err)
+	lockCount, _, err = s.store.GCDryRun(context.Background(), safePoint)
+	s.Nil(err)
+	s.Equal(uint64(0), lockCount)
+}
+
+func (s *testLockSuite) TestGCStats() {
+	for _, k := range []string{"k1", "k2", "k3"} {
+		s.lockKey([]byte(k), []byte("v"), []byte(k), []byte("v"), false)
+	}
+	safePoint, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Nil(err)
+
+	var stats tikv.GCStats
+	_, err = s.store.GC(context.Background(), safePoint, tikv.WithGCStats(&stats))
+	s.Nil(err)
+	s.Greater(stats.CompletedRegions, 0)
+	locks := 0
+	for storeID, n := range stats.LocksPerStore {
+		s.NotEqual(uint64(0), storeID)
+		locks += n
+	}
+	s.GreaterOrEqual(locks, 3)
+}
+
+func (s *testLockSuite) TestResolveLocksForTxn() {
+	otherTS, _ := s.lockKey([]byte("t1"), []byte("v"), []byte("t1"), []byte("v"), false)
+	startTS, _ := s.lockKey([]byte("t2"), []byte("v"), []byte("t2"), []byte("v"), false)
+	s.lockKey([]byte("t3"), []byte("v"), []byte("t3"), []byte("v"), false)
+	safePoint, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Nil(err)
+	oldSafePoint, err := s.store.GetGCSafePoint(context.Background())
+	s.Nil(err)
+
+	_, err = s.store.ResolveLocksForTxn(context.Background(), startTS, []byte("t"), []byte("u"))
+	s.Nil(err)
+
+	locks, err := s.store.ScanLocks(context.Background(), []byte("t"), safePoint)
+	s.Nil(err)
+	txns := make(map[uint64]bool)
+	for _, l := range locks {
+		txns[l.TxnID] = true
+	}
+	s.Len(txns, 2)
+	s.False(txns[startTS])
+	s.True(txns[otherTS])
+
+	// The safepoint isn't moved.
+	newSafePoint, err := s.store.GetGCSafePoint(context.Background())
+	s.Nil(err)
+	s.Equal(oldSafePoint, newSafePoint)
+
+	_, err = s.store.ResolveLocksForTxn(context.Background(), 0, nil, nil)
+	s.NotNil(err)
+}
+
+func (s *testLockSuite) TestVerifyNoPendingTxnBefore() {
+	// Clean up the locks left by others.
+	safePoint, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Nil(err)
+	_, err = s.store.GC(context.Background(), safePoint)
+	s.Nil(err)
+
+	startTS, _ := s.lockKey([]byte("p1"), []byte("v"), []byte("p1"), []byte("v"), false)
+	s.lockKey([]byte("p2"), []byte("v"), []byte("p2"), []byte("v"), false)
+
+	ok, oldest, err := s.store.VerifyNoPendingTxnBefore(context.Background(), startTS)
+	s.Nil(err)
+	s.True(ok)
+	s.Equal(uint64(0), oldest)
+
+	ok, oldest, err = s.store.VerifyNoPendingTxnBefore(context.Background(), startTS+1)
+	s.Nil(err)
+	s.False(ok)
+	s.Equal(startTS, oldest)
+
+	safePoint, err = s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Nil(err)
+	_, err = s.store.GC(context.Background(), safePoint)
+	s.Nil(err)
+	ok, _, err = s.store.VerifyNoPendingTxnBefore(context.Background(), safePoint)
+	s.Nil(err)
+	s.True(ok)
+}
+
 func (s *testLockSuite) TestNewLockZeroTTL() {
 	l := tikv.NewLock(&kvrpcpb.LockInfo{})
 	s.Equal(l.TTL, uint64(0))
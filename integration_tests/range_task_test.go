@@ -42,9 +42,13 @@ import (
 	"github.com/pingcap/tidb/store/mockstore/mockcopr"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/logutil"
 	"github.com/tikv/client-go/v2/mockstore/cluster"
 	"github.com/tikv/client-go/v2/mockstore/mocktikv"
 	"github.com/tikv/client-go/v2/tikv"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestRangeTask(t *testing.T) {
@@ -262,3 +266,52 @@ func (s *testRangeTaskSuite) TestRangeTaskError() {
 		s.testRangeTaskErrorImpl(concurrency)
 	}
 }
+
+func (s *testRangeTaskSuite) TestRangeTaskInvertedRange() {
+	ranges := make(chan *kv.KeyRange, 100)
+	handler := func(ctx context.Context, r kv.KeyRange) (tikv.RangeTaskStat, error) {
+		ranges <- &r
+		return tikv.RangeTaskStat{CompletedRegions: 1}, nil
+	}
+	runner := tikv.NewRangeTaskRunner("test-inverted-runner", s.store, 1, handler)
+
+	// An inverted range is rejected.
+	err := runner.RunOnRange(context.Background(), []byte("d"), []byte("b"))
+	s.NotNil(err)
+	s.Empty(collect(ranges))
+
+	// An empty range is ignored, and an empty end key means unbounded.
+	s.Nil(runner.RunOnRange(context.Background(), []byte("b"), []byte("b")))
+	s.Empty(collect(ranges))
+	s.Nil(runner.RunOnRange(context.Background(), []byte("z"), nil))
+	s.checkRanges(collect(ranges), []kv.KeyRange{makeRange("z", "")})
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskRunID() {
+	handler := func(ctx context.Context, r kv.KeyRange) (tikv.RangeTaskStat, error) {
+		logutil.Logger(ctx).Info("range task handled")
+		return tikv.RangeTaskStat{CompletedRegions: 1}, nil
+	}
+	runner := tikv.NewRangeTaskRunner("test-run-id-runner", s.store, 2, handler)
+	s.Equal("test-run-id-runner", runner.Name())
+	s.Empty(runner.RunID())
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := context.WithValue(context.Background(), logutil.CtxLogKey, zap.New(core))
+	s.Nil(runner.RunOnRange(ctx, []byte("a"), []byte("z")))
+	runID := runner.RunID()
+	s.NotEmpty(runID)
+	// All logs of the run, including the logs of the handler, carry the run ID.
+	s.NotZero(logs.FilterMessage("range task handled").Len())
+	s.Equal(logs.Len(), logs.FilterField(zap.String("runID", runID)).Len())
+	s.Equal(logs.Len(), logs.FilterField(zap.String("name", "test-run-id-runner")).Len())
+
+	stat := runner.Stat()
+	s.Equal("test-run-id-runner", stat.RunnerName)
+	s.Equal(runID, stat.RunID)
+	s.Equal(runner.CompletedRegions(), stat.CompletedRegions)
+
+	// Each run has its own ID.
+	s.Nil(runner.RunOnRange(ctx, []byte("a"), []byte("z")))
+	s.NotEqual(runID, runner.RunID())
+}
@@ -38,6 +38,7 @@ import (
 	"testing"
 
 	"github.com/pingcap/errors"
+	"github.com/pingcap/failpoint"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/pingcap/tidb/store/mockstore/mockcopr"
@@ -139,6 +140,23 @@ func (s *testSplitSuite) TestStaleEpoch() {
 	s.Nil(err)
 }
 
+func (s *testSplitSuite) TestSplitRegionPanicStack() {
+	s.Nil(failpoint.Enable("tikvclient/mockSplitRegionPanic", "return(true)"))
+	defer func() {
+		s.Nil(failpoint.Disable("tikvclient/mockSplitRegionPanic"))
+	}()
+
+	_, err := s.store.SplitRegions(context.Background(), [][]byte{[]byte("b")}, false, nil)
+	s.NotNil(err)
+	s.Contains(err.Error(), "mock split region panic")
+	s.NotContains(err.Error(), "batchSendSingleRegion")
+
+	_, err = s.store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("b")}, false, nil, tikv.WithSplitPanicStack())
+	s.NotNil(err)
+	s.Contains(err.Error(), "mock split region panic")
+	s.Contains(err.Error(), "batchSendSingleRegion")
+}
+
 var errStopped = errors.New("stopped")
 
 type mockPDClient struct {
@@ -964,7 +964,10 @@ func (c *RegionCache) LocateRegionByID(bo *retry.Backoffer, regionID uint64) (*K
 // GroupKeysByRegion separates keys into groups by their belonging Regions.
 // Specially it also returns the first key's region which may be used as the
 // 'PrimaryLockKey' and should be committed ahead of others.
-// filter is used to filter some unwanted keys.
+// filter is used to filter some unwanted keys, nil means keeping all keys. It's called with the key and the
+// start key of its region, and the key is dropped if it returns true. The keys should be sorted, since the
+// filter is only called for the first key of each run of keys located in the same region, which is the only
+// key that can equal the region start key, see tikv.FilterKeysAtRegionStart.
 func (c *RegionCache) GroupKeysByRegion(bo *retry.Backoffer, keys [][]byte, filter func(key, regionStartKey []byte) bool) (map[RegionVerID][][]byte, RegionVerID, error) {
 	groups := make(map[RegionVerID][][]byte)
 	var first RegionVerID
@@ -542,6 +542,7 @@ func (s *RegionRequestSender) SendReqCtx(
 			// TODO: Change the returned error to something like "region missing in cache",
 			// and handle this error like EpochNotMatch, which means to re-split the request and retry.
 			logutil.Logger(bo.GetCtx()).Debug("throwing pseudo region error due to region not found in cache", zap.Stringer("region", &regionID))
+			metrics.TiKVSendReqRetryCounter.WithLabelValues(req.Type.String(), "not_in_cache").Inc()
 			resp, err = tikvrpc.GenRegionErrorResp(req, &errorpb.Error{EpochNotMatch: &errorpb.EpochNotMatch{}})
 			return resp, nil, err
 		}
@@ -565,6 +566,7 @@ func (s *RegionRequestSender) SendReqCtx(
 			}
 		}
 		if retry {
+			metrics.TiKVSendReqRetryCounter.WithLabelValues(req.Type.String(), "send_fail").Inc()
 			tryTimes++
 			continue
 		}
@@ -580,6 +582,7 @@ func (s *RegionRequestSender) SendReqCtx(
 				return nil, nil, errors.Trace(err)
 			}
 			if retry {
+				metrics.TiKVSendReqRetryCounter.WithLabelValues(req.Type.String(), "region_err").Inc()
 				tryTimes++
 				continue
 			}
@@ -85,6 +85,10 @@ var (
 	TiKVTxnCommitBackoffSeconds            prometheus.Histogram
 	TiKVTxnCommitBackoffCount              prometheus.Histogram
 	TiKVSmallReadDuration                  prometheus.Histogram
+	TiKVScatterSkippedRegionCounter        prometheus.Counter
+	TiKVWaitScatterRegionCounter           *prometheus.CounterVec
+	TiKVGCRegionTooManyLocksCounter        prometheus.Counter
+	TiKVSendReqRetryCounter                *prometheus.CounterVec
 )
 
 // Label constants.
@@ -103,6 +107,7 @@ const (
 	LblAddress         = "address"
 	LblFromStore       = "from_store"
 	LblToStore         = "to_store"
+	LblReason          = "reason"
 )
 
 func initMetrics(namespace, subsystem string) {
@@ -499,6 +504,38 @@ func initMetrics(namespace, subsystem string) {
 			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 28), // 0.5ms ~ 74h
 		})
 
+	TiKVScatterSkippedRegionCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scatter_skipped_region_total",
+			Help:      "Counter of new regions not scattered after splitting, which are the last region of each split batch.",
+		})
+
+	TiKVWaitScatterRegionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "wait_scatter_region_total",
+			Help:      "Counter of waiting for regions to be scattered, by the outcome.",
+		}, []string{LblResult})
+
+	TiKVGCRegionTooManyLocksCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "gc_region_too_many_locks_total",
+			Help:      "Counter of regions whose locks exceed the scan lock limit in many consecutive scans during GC.",
+		})
+
+	TiKVSendReqRetryCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "send_req_retry_total",
+			Help:      "Counter of the retries and fallbacks of the region request sender, by the request type and the reason.",
+		}, []string{LblType, LblReason})
+
 	initShortcuts()
 }
 
@@ -560,6 +597,10 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TiKVTxnCommitBackoffSeconds)
 	prometheus.MustRegister(TiKVTxnCommitBackoffCount)
 	prometheus.MustRegister(TiKVSmallReadDuration)
+	prometheus.MustRegister(TiKVScatterSkippedRegionCounter)
+	prometheus.MustRegister(TiKVWaitScatterRegionCounter)
+	prometheus.MustRegister(TiKVGCRegionTooManyLocksCounter)
+	prometheus.MustRegister(TiKVSendReqRetryCounter)
 }
 
 // readCounter reads the value of a prometheus.Counter.
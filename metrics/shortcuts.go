@@ -117,6 +117,11 @@ var (
 	OnePCTxnCounterOk       prometheus.Counter
 	OnePCTxnCounterError    prometheus.Counter
 	OnePCTxnCounterFallback prometheus.Counter
+
+	WaitScatterRegionCounterFinished prometheus.Counter
+	WaitScatterRegionCounterError    prometheus.Counter
+	WaitScatterRegionCounterTimeout  prometheus.Counter
+	WaitScatterRegionCounterCanceled prometheus.Counter
 )
 
 func initShortcuts() {
@@ -201,4 +206,9 @@ func initShortcuts() {
 	OnePCTxnCounterOk = TiKVOnePCTxnCounter.WithLabelValues("ok")
 	OnePCTxnCounterError = TiKVOnePCTxnCounter.WithLabelValues("err")
 	OnePCTxnCounterFallback = TiKVOnePCTxnCounter.WithLabelValues("fallback")
+
+	WaitScatterRegionCounterFinished = TiKVWaitScatterRegionCounter.WithLabelValues("finished")
+	WaitScatterRegionCounterError = TiKVWaitScatterRegionCounter.WithLabelValues("err")
+	WaitScatterRegionCounterTimeout = TiKVWaitScatterRegionCounter.WithLabelValues("timeout")
+	WaitScatterRegionCounterCanceled = TiKVWaitScatterRegionCounter.WithLabelValues("canceled")
 }
@@ -367,12 +367,22 @@ func (h kvHandler) handleKvBatchRollback(req *kvrpcpb.BatchRollbackRequest) *kvr
 func (h kvHandler) handleKvScanLock(req *kvrpcpb.ScanLockRequest) *kvrpcpb.ScanLockResponse {
 	startKey := MvccKey(h.startKey).Raw()
 	endKey := MvccKey(h.endKey).Raw()
+	// Scan from the start key and stop at the end key of the request if they're in the region, like TiKV.
+	if bytes.Compare(req.GetStartKey(), startKey) > 0 {
+		startKey = req.GetStartKey()
+	}
+	if len(req.GetEndKey()) > 0 && (len(endKey) == 0 || bytes.Compare(req.GetEndKey(), endKey) < 0) {
+		endKey = req.GetEndKey()
+	}
 	locks, err := h.mvccStore.ScanLock(startKey, endKey, req.GetMaxVersion())
 	if err != nil {
 		return &kvrpcpb.ScanLockResponse{
 			Error: convertToKeyError(err),
 		}
 	}
+	if req.GetLimit() > 0 && len(locks) > int(req.GetLimit()) {
+		locks = locks[:req.GetLimit()]
+	}
 	return &kvrpcpb.ScanLockResponse{
 		Locks: locks,
 	}
@@ -381,7 +391,17 @@ func (h kvHandler) handleKvScanLock(req *kvrpcpb.ScanLockRequest) *kvrpcpb.ScanL
 func (h kvHandler) handleKvResolveLock(req *kvrpcpb.ResolveLockRequest) *kvrpcpb.ResolveLockResponse {
 	startKey := MvccKey(h.startKey).Raw()
 	endKey := MvccKey(h.endKey).Raw()
-	err := h.mvccStore.ResolveLock(startKey, endKey, req.GetStartVersion(), req.GetCommitVersion())
+	var err error
+	if len(req.TxnInfos) > 0 {
+		// The locks of several transactions are resolved at once, e.g. by GC.
+		txnInfos := make(map[uint64]uint64, len(req.TxnInfos))
+		for _, ti := range req.TxnInfos {
+			txnInfos[ti.GetTxn()] = ti.GetStatus()
+		}
+		err = h.mvccStore.BatchResolveLock(startKey, endKey, txnInfos)
+	} else {
+		err = h.mvccStore.ResolveLock(startKey, endKey, req.GetStartVersion(), req.GetCommitVersion())
+	}
 	if err != nil {
 		return &kvrpcpb.ResolveLockResponse{
 			Error: convertToKeyError(err),
@@ -414,6 +434,15 @@ func (h kvHandler) handleKvDeleteRange(req *kvrpcpb.DeleteRangeRequest) *kvrpcpb
 	return &resp
 }
 
+func (h kvHandler) handleKvUnsafeDestroyRange(req *kvrpcpb.UnsafeDestroyRangeRequest) *kvrpcpb.UnsafeDestroyRangeResponse {
+	var resp kvrpcpb.UnsafeDestroyRangeResponse
+	err := h.mvccStore.DeleteRange(req.GetStartKey(), req.GetEndKey())
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return &resp
+}
+
 func (h kvHandler) handleKvRawGet(req *kvrpcpb.RawGetRequest) *kvrpcpb.RawGetResponse {
 	rawKV, ok := h.mvccStore.(RawKV)
 	if !ok {
@@ -878,7 +907,7 @@ func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.R
 		}
 		resp.Resp = kvHandler{session}.handleKvRawScan(r)
 	case tikvrpc.CmdUnsafeDestroyRange:
-		panic("unimplemented")
+		resp.Resp = kvHandler{session}.handleKvUnsafeDestroyRange(req.UnsafeDestroyRange())
 	case tikvrpc.CmdRegisterLockObserver:
 		return nil, errors.New("unimplemented")
 	case tikvrpc.CmdCheckLockObserver:
@@ -0,0 +1,218 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testkit provides a fake store of the split and GC API, so the code built on them can be unit tested
+// without a TiKV cluster or failpoints.
+package testkit
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/tikv"
+)
+
+// MaxRegionMissRetries is the number of the region misses a SplitRegions call retries before it gives up.
+const MaxRegionMissRetries = 3
+
+// SplitCall records a call of SplitRegions.
+type SplitCall struct {
+	Keys    [][]byte
+	Scatter bool
+	TableID *int64
+	// RegionMisses is the number of the region misses hit by the call.
+	RegionMisses int
+}
+
+// GCCall records a call of GC.
+type GCCall struct {
+	SafePoint uint64
+}
+
+// SplitFunc is used to program the response of SplitRegions.
+type SplitFunc func(keys [][]byte, scatter bool) (regionIDs []uint64, err error)
+
+// Store is a fake tikv.SplittableStore and tikv.GCStore. It records the calls, and its responses can be
+// programmed. By default, every split key creates a new region, the new regions are scattered immediately,
+// and GC always succeeds. It's safe for concurrent use.
+type Store struct {
+	mu struct {
+		sync.Mutex
+		splitCalls   []SplitCall
+		gcCalls      []GCCall
+		splitFunc    SplitFunc
+		regionMisses int
+		nextRegionID uint64
+		scattering   map[uint64]bool
+		scatterErrs  map[uint64]error
+		gcErr        error
+		safePoint    uint64
+	}
+}
+
+var (
+	_ tikv.SplittableStore = (*Store)(nil)
+	_ tikv.GCStore         = (*Store)(nil)
+)
+
+// NewStore creates a Store.
+func NewStore() *Store {
+	s := &Store{}
+	s.mu.nextRegionID = 1
+	s.mu.scattering = make(map[uint64]bool)
+	s.mu.scatterErrs = make(map[uint64]error)
+	return s
+}
+
+// SetSplitFunc sets the function to generate the responses of SplitRegions, nil resets to the default.
+func (s *Store) SetSplitFunc(fn SplitFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.splitFunc = fn
+}
+
+// InjectRegionMisses makes the next n split attempts hit region misses. Like KVStore, SplitRegions retries
+// on region misses, the retries are recorded in SplitCall.RegionMisses. A call that still misses after
+// MaxRegionMissRetries retries fails with tikverr.ErrRegionUnavailable, the remaining misses are left to
+// the next calls.
+func (s *Store) InjectRegionMisses(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.regionMisses = n
+}
+
+// SetScattering sets whether the region is still being scattered, it's reported by CheckRegionInScattering.
+func (s *Store) SetScattering(regionID uint64, scattering bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.scattering[regionID] = scattering
+}
+
+// SetScatterError sets the error returned by WaitScatterRegionFinish for the region, nil clears it.
+func (s *Store) SetScatterError(regionID uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.mu.scatterErrs, regionID)
+		return
+	}
+	s.mu.scatterErrs[regionID] = err
+}
+
+// SetGCError sets the error returned by GC, nil clears it.
+func (s *Store) SetGCError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.gcErr = err
+}
+
+// SplitCalls returns the recorded calls of SplitRegions.
+func (s *Store) SplitCalls() []SplitCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SplitCall(nil), s.mu.splitCalls...)
+}
+
+// GCCalls returns the recorded calls of GC.
+func (s *Store) GCCalls() []GCCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]GCCall(nil), s.mu.gcCalls...)
+}
+
+// SplitRegions implements tikv.SplittableStore interface.
+func (s *Store) SplitRegions(ctx context.Context, splitKeys [][]byte, scatter bool, tableID *int64) (regionIDs []uint64, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	keys := make([][]byte, len(splitKeys))
+	for i, key := range splitKeys {
+		keys[i] = append([]byte(nil), key...)
+	}
+	s.mu.Lock()
+	misses := s.mu.regionMisses
+	if misses > MaxRegionMissRetries {
+		misses = MaxRegionMissRetries + 1
+	}
+	s.mu.regionMisses -= misses
+	s.mu.splitCalls = append(s.mu.splitCalls, SplitCall{
+		Keys:         keys,
+		Scatter:      scatter,
+		TableID:      tableID,
+		RegionMisses: misses,
+	})
+	if misses > MaxRegionMissRetries {
+		s.mu.Unlock()
+		return nil, errors.Trace(tikverr.ErrRegionUnavailable)
+	}
+	// The function is called without the lock, so it can use the Store.
+	if fn := s.mu.splitFunc; fn != nil {
+		s.mu.Unlock()
+		return fn(keys, scatter)
+	}
+	defer s.mu.Unlock()
+	regionIDs = make([]uint64, 0, len(keys))
+	for range keys {
+		regionIDs = append(regionIDs, s.mu.nextRegionID)
+		s.mu.nextRegionID++
+	}
+	return regionIDs, nil
+}
+
+// WaitScatterRegionFinish implements tikv.SplittableStore interface.
+// It returns the error set by SetScatterError, or the error of the context.
+func (s *Store) WaitScatterRegionFinish(ctx context.Context, regionID uint64, backOff int) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.scatterErrs[regionID]
+}
+
+// CheckRegionInScattering implements tikv.SplittableStore interface.
+func (s *Store) CheckRegionInScattering(regionID uint64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.scattering[regionID], nil
+}
+
+// GC implements tikv.GCStore interface.
+// Like PD, the safepoint never moves backward, the current safepoint is returned if the given one is smaller.
+func (s *Store) GC(ctx context.Context, safepoint uint64, opts ...tikv.GCOption) (newSafePoint uint64, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.gcCalls = append(s.mu.gcCalls, GCCall{SafePoint: safepoint})
+	if s.mu.gcErr != nil {
+		return 0, s.mu.gcErr
+	}
+	if safepoint > s.mu.safePoint {
+		s.mu.safePoint = safepoint
+	}
+	return s.mu.safePoint, nil
+}
+
+// GetGCSafePoint implements tikv.GCStore interface.
+func (s *Store) GetGCSafePoint(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.safePoint, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testkit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/assert"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+func TestSplit(t *testing.T) {
+	s := NewStore()
+	ctx := context.Background()
+	tableID := int64(10)
+
+	s.InjectRegionMisses(2)
+	regionIDs, err := s.SplitRegions(ctx, [][]byte{[]byte("a"), []byte("b")}, true, &tableID)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2}, regionIDs)
+	regionIDs, err = s.SplitRegions(ctx, [][]byte{[]byte("c")}, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{3}, regionIDs)
+
+	calls := s.SplitCalls()
+	assert.Len(t, calls, 2)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, calls[0].Keys)
+	assert.True(t, calls[0].Scatter)
+	assert.Equal(t, &tableID, calls[0].TableID)
+	assert.Equal(t, 2, calls[0].RegionMisses)
+	assert.False(t, calls[1].Scatter)
+	assert.Equal(t, 0, calls[1].RegionMisses)
+
+	splitErr := errors.New("split failed")
+	s.SetSplitFunc(func(keys [][]byte, scatter bool) ([]uint64, error) {
+		return []uint64{100}, splitErr
+	})
+	regionIDs, err = s.SplitRegions(ctx, [][]byte{[]byte("d"), []byte("e")}, true, nil)
+	assert.Equal(t, splitErr, err)
+	assert.Equal(t, []uint64{100}, regionIDs)
+	assert.Len(t, s.SplitCalls(), 3)
+
+	// The split function can use the store.
+	s.SetSplitFunc(func(keys [][]byte, scatter bool) ([]uint64, error) {
+		return nil, s.WaitScatterRegionFinish(ctx, 1, 0)
+	})
+	_, err = s.SplitRegions(ctx, [][]byte{[]byte("f")}, false, nil)
+	assert.Nil(t, err)
+	s.SetSplitFunc(nil)
+
+	// The misses outlast the retries.
+	s.InjectRegionMisses(MaxRegionMissRetries + 2)
+	_, err = s.SplitRegions(ctx, [][]byte{[]byte("g")}, false, nil)
+	assert.Equal(t, tikverr.ErrRegionUnavailable, errors.Cause(err))
+	regionIDs, err = s.SplitRegions(ctx, [][]byte{[]byte("g")}, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{4}, regionIDs)
+	calls = s.SplitCalls()
+	assert.Equal(t, MaxRegionMissRetries+1, calls[4].RegionMisses)
+	assert.Equal(t, 1, calls[5].RegionMisses)
+}
+
+func TestScatter(t *testing.T) {
+	s := NewStore()
+	ctx := context.Background()
+
+	scattering, err := s.CheckRegionInScattering(1)
+	assert.Nil(t, err)
+	assert.False(t, scattering)
+	s.SetScattering(1, true)
+	scattering, err = s.CheckRegionInScattering(1)
+	assert.Nil(t, err)
+	assert.True(t, scattering)
+
+	assert.Nil(t, s.WaitScatterRegionFinish(ctx, 1, 0))
+	scatterErr := errors.New("scatter failed")
+	s.SetScatterError(1, scatterErr)
+	assert.Equal(t, scatterErr, s.WaitScatterRegionFinish(ctx, 1, 0))
+	s.SetScatterError(1, nil)
+	assert.Nil(t, s.WaitScatterRegionFinish(ctx, 1, 0))
+}
+
+func TestGC(t *testing.T) {
+	s := NewStore()
+	ctx := context.Background()
+
+	safePoint, err := s.GC(ctx, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), safePoint)
+	// The safepoint doesn't move backward.
+	safePoint, err = s.GC(ctx, 50)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), safePoint)
+
+	gcErr := errors.New("gc failed")
+	s.SetGCError(gcErr)
+	_, err = s.GC(ctx, 200)
+	assert.Equal(t, gcErr, err)
+	safePoint, err = s.GetGCSafePoint(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), safePoint)
+	assert.Equal(t, []GCCall{{SafePoint: 100}, {SafePoint: 50}, {SafePoint: 200}}, s.GCCalls())
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.GC(cancelCtx, 300)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+}
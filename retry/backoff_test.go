@@ -47,3 +47,13 @@ func TestBackoffWithMax(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 30, b.totalSleep)
 }
+
+func TestConfigWithJitter(t *testing.T) {
+	cfg := BoPDRPC.WithJitter(FullJitter)
+
+	assert.Equal(t, FullJitter, cfg.fnCfg.jitter)
+	assert.Equal(t, EqualJitter, BoPDRPC.fnCfg.jitter)
+	assert.Equal(t, BoPDRPC.String(), cfg.String())
+	assert.Equal(t, BoPDRPC.fnCfg.base, cfg.fnCfg.base)
+	assert.Equal(t, BoPDRPC.fnCfg.cap, cfg.fnCfg.cap)
+}
@@ -97,6 +97,21 @@ func (c *Config) String() string {
 	return c.name
 }
 
+// WithJitter returns a copy of the Config whose backoff function applies the
+// given jitter mode (NoJitter, FullJitter, EqualJitter or DecorrJitter).
+// It's useful when many goroutines retry the same kind of request at the same
+// time, jitter spreads the retries so they don't hit the server in lockstep.
+func (c *Config) WithJitter(jitter int) *Config {
+	fnCfg := *c.fnCfg
+	fnCfg.jitter = jitter
+	return &Config{
+		name:   c.name,
+		metric: c.metric,
+		fnCfg:  &fnCfg,
+		err:    c.err,
+	}
+}
+
 const txnLockFastName = "txnLockFast"
 
 // Backoff Config variables.
@@ -48,6 +48,8 @@ type BackoffConfig = retry.Config
 // Maximum total sleep time(in ms) for kv/cop commands.
 const (
 	gcResolveLockMaxBackoff = 100000
+	// gcUpdateSafePointMaxBackoff is max sleep time of updating the GC safepoint to PD.
+	gcUpdateSafePointMaxBackoff = 20000
 	// CommitSecondaryMaxBackoff is max sleep time of the 'commit' command
 	CommitSecondaryMaxBackoff = 41000
 )
@@ -69,6 +71,35 @@ func NewBackoffer(ctx context.Context, maxSleep int) *Backoffer {
 	return retry.NewBackoffer(ctx, maxSleep)
 }
 
+// NewBulkOpBackoffer creates a Backoffer with maximum sleep time(in ms) for the user bulk operations built on
+// KVStore, e.g. locating and grouping many keys before splitting or resolving them, so they can share one budget.
+// If maxSleepMs <= 0, the max backoff budget of a SplitRegions call is used, see SetMaxSplitRegionsBackoff.
+// The Backoffer is accepted by KVStore.SendReq, KVStore.LocateKeys, LockResolver.BatchResolveLocks,
+// LockResolver.BatchResolveLocksInRegion and the methods of the region cache taking a Backoffer, e.g.
+// RegionCache.LocateKey and RegionCache.GroupKeysByRegion. The methods taking a context, e.g. SplitRegions, have
+// their own budgets, bound them with the deadline of the context instead.
+func NewBulkOpBackoffer(ctx context.Context, maxSleepMs int) *Backoffer {
+	if maxSleepMs <= 0 {
+		maxSleepMs = int(GetMaxSplitRegionsBackoff().Milliseconds())
+	}
+	return retry.NewBackofferWithVars(ctx, maxSleepMs, nil)
+}
+
+// RetryWithBackoff runs fn until it succeeds, backing off with cfg on each of its errors, e.g. BoRegionMiss() or
+// BoPDRPC(), so the retry loops built on KVStore behave like the ones of the library. It returns the error of the
+// backoff if bo runs out of its budget or its context is done.
+func RetryWithBackoff(bo *Backoffer, cfg *BackoffConfig, fn func() error) error {
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if err = bo.Backoff(cfg, err); err != nil {
+			return err
+		}
+	}
+}
+
 // TxnStartKey is a key for transaction start_ts info in context.Context.
 func TxnStartKey() interface{} {
 	return retry.TxnStartKey
@@ -99,6 +99,34 @@ func (t *DeleteRangeTask) Execute(ctx context.Context) error {
 	return err
 }
 
+// DeleteRange deletes all keys in [startKey, endKey) of the store's keyspace region by region, the range is split
+// into tasks that run with the given concurrency. An empty endKey means the end of the keyspace, deleting an
+// unbounded range is rejected if the store has no keyspace. It returns the number of regions the range is deleted
+// from, which is partial if ctx is canceled or the deletion fails.
+// Be careful while using this API, it deletes all versions of the keys immediately, see NewDeleteRangeTask.
+func (s *KVStore) DeleteRange(ctx context.Context, startKey, endKey []byte, concurrency int) (stat RangeTaskStat, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	// The keys are encoded below, attach the keys of the caller.
+	defer func(startKey, endKey []byte) {
+		err = tikverr.WithOperation(err, "DeleteRange", startKey, endKey)
+	}(startKey, endKey)
+	ctx = s.withOperationID(ctx)
+	startKey = s.encodeKeyspaceKey(startKey)
+	if len(endKey) == 0 {
+		_, endKey = s.keyspaceRange()
+		if len(endKey) == 0 {
+			return RangeTaskStat{}, errors.New("delete range: unbounded range isn't allowed")
+		}
+	} else {
+		endKey = s.encodeKeyspaceKey(endKey)
+	}
+	task := NewDeleteRangeTask(s, startKey, endKey, concurrency)
+	runner := NewRangeTaskRunner(task.getRunnerName(), s, concurrency, task.sendReqOnRange)
+	err = runner.RunOnRange(ctx, startKey, endKey)
+	return runner.Stat(), errors.Trace(err)
+}
+
 const deleteRangeOneRegionMaxBackoff = 100000
 
 // Execute performs the delete range operation.
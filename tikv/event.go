@@ -0,0 +1,34 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+// EventSink receives the lifecycle events of splitting regions and GC, so the automation can react to them
+// without scraping the logs. The methods are called synchronously from concurrent goroutines, so they should
+// be thread-safe and return quickly.
+type EventSink interface {
+	// OnSplitComplete is called after a split batch of the region succeeds, newCount is the number of the
+	// new regions returned by the split batch.
+	OnSplitComplete(regionID uint64, newCount int)
+	// OnScatterFailed is called when scattering a new region fails.
+	OnScatterFailed(regionID uint64, err error)
+	// OnGCRegionDone is called after GC resolves all the locks in a region, resolvedLocks is the number of
+	// the locks found in the region.
+	OnGCRegionDone(regionID uint64, resolvedLocks int)
+}
+
+// SetEventSink sets the sink to receive the split and GC events of the store. It should be called before
+// using the store.
+func (s *KVStore) SetEventSink(sink EventSink) {
+	s.eventSink = sink
+}
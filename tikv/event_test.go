@@ -0,0 +1,80 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordEventSink struct {
+	mu          sync.Mutex
+	splits      map[uint64]int
+	scatterErrs map[uint64]error
+	gcRegions   map[uint64]int
+}
+
+func newRecordEventSink() *recordEventSink {
+	return &recordEventSink{
+		splits:      make(map[uint64]int),
+		scatterErrs: make(map[uint64]error),
+		gcRegions:   make(map[uint64]int),
+	}
+}
+
+func (s *recordEventSink) OnSplitComplete(regionID uint64, newCount int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.splits[regionID] = newCount
+}
+
+func (s *recordEventSink) OnScatterFailed(regionID uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scatterErrs[regionID] = err
+}
+
+func (s *recordEventSink) OnGCRegionDone(regionID uint64, resolvedLocks int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gcRegions[regionID] = resolvedLocks
+}
+
+func TestEventSink(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+	sink := newRecordEventSink()
+	store.SetEventSink(sink)
+
+	_, err := store.SplitRegions(context.Background(), [][]byte{[]byte("b"), []byte("c"), []byte("x")}, true, nil)
+	assert.Nil(t, err)
+	// The keys are located in 2 regions.
+	assert.Len(t, sink.splits, 2)
+	newCount := 0
+	for _, n := range sink.splits {
+		newCount += n
+	}
+	assert.GreaterOrEqual(t, newCount, 3)
+	assert.Empty(t, sink.scatterErrs)
+
+	_, err = store.GC(context.Background(), 100)
+	assert.Nil(t, err)
+	assert.Len(t, sink.gcRegions, 5)
+	for _, n := range sink.gcRegions {
+		assert.Equal(t, 0, n)
+	}
+}
@@ -16,15 +16,25 @@ package tikv
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/internal/locate"
 	"github.com/tikv/client-go/v2/kv"
-	"github.com/tikv/client-go/v2/logutil"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/retry"
 	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/util"
 	zap "go.uber.org/zap"
 )
 
@@ -41,40 +51,685 @@ import (
 ///
 /// This is a simplified version of [GC in TiDB](https://docs.pingcap.com/tidb/stable/garbage-collection-overview).
 /// We skip the second step "delete ranges" which is an optimization for TiDB.
-func (s *KVStore) GC(ctx context.Context, safepoint uint64) (newSafePoint uint64, err error) {
-	err = s.resolveLocks(ctx, safepoint, 8)
+///
+/// The returned `newSafePoint` is the safepoint PD keeps, which may differ from `safepoint`: it's lower if PD holds
+/// the safepoint back, e.g. for a service safepoint, and higher if the safepoint was already higher, since PD never
+/// moves it backward, or if it's clamped by WithGCMaxSafePointAdvance. Use WithGCSafePointResult to tell whether
+/// the requested safepoint is honored.
+func (s *KVStore) GC(ctx context.Context, safepoint uint64, opts ...GCOption) (newSafePoint uint64, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() { err = tikverr.WithOperation(err, "GC", nil, nil) }()
+	gcOpts := newGCOptions(opts)
+	if err = gcOpts.validate(); err != nil {
+		return
+	}
+	ctx = s.withOperationID(ctx)
+
+	requested := safepoint
+	var oldSafePoint uint64
+	oldQueried := false
+	if gcOpts.maxSafePointAdvance > 0 {
+		// PD never moves the safepoint backward, updating it to 0 returns the current one.
+		if oldSafePoint, err = s.updateGCSafePoint(ctx, 0, gcOpts); err != nil {
+			return
+		}
+		oldQueried = true
+		safepoint = clampSafePoint(oldSafePoint, safepoint, gcOpts.maxSafePointAdvance)
+		if safepoint < requested {
+			s.ctxLogger(ctx).Warn("[gc worker] gc safepoint is clamped by the max advance",
+				zap.Uint64("requested", requested),
+				zap.Uint64("clamped", safepoint),
+				zap.Uint64("previous", oldSafePoint),
+				zap.Duration("max advance", gcOpts.maxSafePointAdvance))
+		}
+	}
+
+	_, err = s.resolveLocks(ctx, safepoint, 8, gcOpts)
 	if err != nil {
 		return
 	}
 
-	return s.pdClient.UpdateGCSafePoint(ctx, safepoint)
+	if gcOpts.onSafePointAdvanced != nil && !oldQueried {
+		if oldSafePoint, err = s.updateGCSafePoint(ctx, 0, gcOpts); err != nil {
+			return
+		}
+	}
+	newSafePoint, err = s.updateGCSafePoint(ctx, safepoint, gcOpts)
+	if err != nil {
+		return
+	}
+	if gcOpts.onSafePointAdvanced != nil {
+		gcOpts.onSafePointAdvanced(oldSafePoint, newSafePoint)
+	}
+	if newSafePoint < safepoint {
+		s.ctxLogger(ctx).Warn("[gc worker] gc safepoint is held back by PD",
+			zap.Uint64("requested", safepoint),
+			zap.Uint64("effective", newSafePoint))
+	}
+	if gcOpts.safePointResult != nil {
+		*gcOpts.safePointResult = GCSafePointResult{
+			Requested: requested,
+			Effective: newSafePoint,
+			Honored:   newSafePoint >= requested,
+		}
+	}
+	return
 }
 
-func (s *KVStore) resolveLocks(ctx context.Context, safePoint uint64, concurrency int) error {
-	handler := func(ctx context.Context, r kv.KeyRange) (RangeTaskStat, error) {
-		return s.resolveLocksForRange(ctx, safePoint, r.StartKey, r.EndKey)
+// clampSafePoint returns the safepoint advanced from oldSafePoint by at most maxAdvance. The safepoint isn't
+// clamped if the old one is 0, i.e. GC has never run.
+func clampSafePoint(oldSafePoint, safepoint uint64, maxAdvance time.Duration) uint64 {
+	if oldSafePoint == 0 {
+		return safepoint
 	}
+	limit := oracle.GoTimeToTS(oracle.GetTimeFromTS(oldSafePoint).Add(maxAdvance))
+	if safepoint > limit {
+		return limit
+	}
+	return safepoint
+}
 
-	runner := NewRangeTaskRunner("resolve-locks-runner", s, concurrency, handler)
-	// Run resolve lock on the whole TiKV cluster. Empty keys means the range is unbounded.
-	err := runner.RunOnRange(ctx, []byte(""), []byte(""))
+// GCSafePointResult is the result of updating the GC safepoint to PD at the end of GC.
+// PD doesn't report why a safepoint is held back, e.g. which service safepoint blocks it, query the service
+// safepoints from PD to find it out.
+type GCSafePointResult struct {
+	// Requested is the safepoint passed to GC.
+	Requested uint64
+	// Effective is the safepoint PD keeps after the update, which is returned by GC.
+	Effective uint64
+	// Honored indicates whether the effective safepoint reaches the requested one, i.e. the data before the
+	// requested safepoint can be garbage collected. It's false if PD holds the safepoint back.
+	Honored bool
+}
+
+// ResolveLocksUpToSafepoint performs only the first step of GC, i.e. resolving all locks with timestamp <=
+// `safepoint`, with the given number of concurrent range tasks, and leaves the PD GC safepoint to the caller. It's
+// for the setups where another component owns the safepoint. The returned stats are partial if it fails.
+func (s *KVStore) ResolveLocksUpToSafepoint(ctx context.Context, safepoint uint64, concurrency int, opts ...GCOption) (stat RangeTaskStat, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() { err = tikverr.WithOperation(err, "ResolveLocksUpToSafepoint", nil, nil) }()
+	if concurrency <= 0 {
+		return RangeTaskStat{}, errors.Errorf("[gc worker] resolve locks concurrency should be positive, got %d", concurrency)
+	}
+	gcOpts := newGCOptions(opts)
+	if err = gcOpts.validate(); err != nil {
+		return RangeTaskStat{}, err
+	}
+	return s.resolveLocks(s.withOperationID(ctx), safepoint, concurrency, gcOpts)
+}
+
+// GetGCSafePoint returns the current GC safepoint of the cluster, so the callers can avoid moving the safepoint
+// backward before calling GC. It retries on PD errors, and returns ErrPDServerTimeout if PD is still unreachable.
+// PD has no API to read the safepoint, so it's read by updating the safepoint to 0, which never changes it.
+func (s *KVStore) GetGCSafePoint(ctx context.Context) (safePoint uint64, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() { err = tikverr.WithOperation(err, "GetGCSafePoint", nil, nil) }()
+	// PD never moves the safepoint backward, it returns the current safepoint if the given one is smaller.
+	return s.updateGCSafePoint(s.withOperationID(ctx), 0, newGCOptions(nil))
+}
+
+// updateGCSafePoint updates the GC safepoint to PD, and retries on errors so that a transient
+// PD failure doesn't waste the whole resolve locks phase.
+func (s *KVStore) updateGCSafePoint(ctx context.Context, safepoint uint64, opts *gcOptions) (uint64, error) {
+	bo := retry.NewBackofferWithVars(ctx, opts.updateSafePointMaxBackoff, nil)
+	for {
+		newSafePoint, err := s.splitGCPDClient.UpdateGCSafePoint(ctx, safepoint)
+		if err == nil {
+			return newSafePoint, nil
+		}
+		if opts.updateSafePointMaxBackoff == 0 {
+			return 0, errors.Trace(err)
+		}
+		// Updating the safepoint to 0 only reads it, see GetGCSafePoint.
+		s.ctxLogger(ctx).Warn("[gc worker] update gc safepoint failed",
+			zap.Uint64("safepoint", safepoint),
+			zap.Bool("readOnly", safepoint == 0),
+			zap.Error(err))
+		if boErr := bo.Backoff(retry.BoPDRPC, errors.New(err.Error())); boErr != nil {
+			if _, ok := errors.Cause(boErr).(*tikverr.ErrPDServerTimeout); ok {
+				return 0, errors.Trace(tikverr.NewErrPDServerTimeout(fmt.Sprintf(
+					"[gc worker] update gc safepoint %d timed out, last error: %v", safepoint, err)))
+			}
+			return 0, errors.Trace(boErr)
+		}
+	}
+}
+
+const unsafeDestroyRangeTimeout = 5 * time.Minute
+
+// UnsafeDestroyRange deletes all data in the range [startKey, endKey) of the store's keyspace physically on all
+// TiKV stores. It doesn't go through raft nor keep any MVCC versions, so the data can't be recovered and concurrent
+// reads and writes in the range may see inconsistent results. Be careful while using this API, make sure that no
+// one will access the range anymore, e.g. the range belongs to a dropped table.
+// As a guard against destroying the whole cluster by mistake, the range must be bounded: an empty key means the
+// start or end of the keyspace, so both keys must be set if the store has no keyspace. An empty or inverted range
+// is rejected as well.
+func (s *KVStore) UnsafeDestroyRange(ctx context.Context, startKey []byte, endKey []byte) (err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	// The keys are encoded below, attach the keys of the caller.
+	defer func(startKey, endKey []byte) {
+		err = tikverr.WithOperation(err, "UnsafeDestroyRange", startKey, endKey)
+	}(startKey, endKey)
+	if len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0 {
+		return errors.Errorf("[unsafe destroy range] invalid range [%s, %s)", kv.StrKey(startKey), kv.StrKey(endKey))
+	}
+	startKey = s.encodeKeyspaceKey(startKey)
+	if len(endKey) == 0 {
+		_, endKey = s.keyspaceRange()
+	} else {
+		endKey = s.encodeKeyspaceKey(endKey)
+	}
+	if len(startKey) == 0 || len(endKey) == 0 {
+		return errors.New("[unsafe destroy range] unbounded range isn't allowed")
+	}
+	// Get all stores every time destroying a range, so the store list is less probably to be stale.
+	stores, err := s.getUpTiKVStores(ctx)
 	if err != nil {
 		return errors.Trace(err)
 	}
+
+	req := tikvrpc.NewRequest(tikvrpc.CmdUnsafeDestroyRange, &kvrpcpb.UnsafeDestroyRangeRequest{
+		StartKey: startKey,
+		EndKey:   endKey,
+	})
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(stores))
+	for _, store := range stores {
+		wg.Add(1)
+		go func(storeID uint64, addr string) {
+			defer wg.Done()
+			resp, err := s.GetTiKVClient().SendRequest(ctx, addr, req, unsafeDestroyRangeTimeout)
+			if err == nil {
+				if resp == nil || resp.Resp == nil {
+					err = errors.Errorf("unsafe destroy range returns nil response from store %v", storeID)
+				} else if errStr := resp.Resp.(*kvrpcpb.UnsafeDestroyRangeResponse).GetError(); len(errStr) > 0 {
+					err = errors.Errorf("unsafe destroy range failed on store %v: %s", storeID, errStr)
+				}
+			}
+			errCh <- err
+		}(store.GetId(), store.GetAddress())
+	}
+	wg.Wait()
+	close(errCh)
+
+	var errs []string
+	for err := range errCh {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Errorf("[unsafe destroy range] destroy range finished with errors: %v", errs)
+	}
+	return nil
+}
+
+// getUpTiKVStores returns all TiKV stores whose state is up.
+func (s *KVStore) getUpTiKVStores(ctx context.Context) ([]*metapb.Store, error) {
+	stores, err := s.splitGCPDClient.GetAllStores(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	upStores := make([]*metapb.Store, 0, len(stores))
+	for _, store := range stores {
+		if store.GetState() == metapb.StoreState_Up && GetStoreTypeByMeta(store) == tikvrpc.TiKV {
+			upStores = append(upStores, store)
+		}
+	}
+	return upStores, nil
+}
+
+// GCOption configures the behavior of GC.
+type GCOption func(*gcOptions)
+
+type gcOptions struct {
+	// scanLockTimeout is the timeout of each scan lock request.
+	scanLockTimeout time.Duration
+	// scanLockLimit is the max number of locks returned by each scan lock request.
+	// It's derived from the resolved cache size of the lock resolver if not set.
+	scanLockLimit int
+	// updateSafePointMaxBackoff is the max total sleep time(in ms) of retrying to update the GC safepoint.
+	updateSafePointMaxBackoff int
+	// resolveLockMaxBackoff is the max total sleep time(in ms) of scanning and resolving the locks of a region.
+	resolveLockMaxBackoff int
+	// skipPessimisticLocks indicates whether to leave the pessimistic locks unresolved.
+	skipPessimisticLocks bool
+	// expiredPessimisticLocksOnly indicates whether to roll back the pessimistic locks only if their TTL expire.
+	expiredPessimisticLocksOnly bool
+	// resolveLockBatchSize is the max number of locks resolved by each batch resolve lock request.
+	resolveLockBatchSize int
+	// resolveConcurrency is the max number of goroutines resolving the locks of a region concurrently.
+	resolveConcurrency int
+	// resolveRPCLimit is the max number of in-flight resolve lock requests of the whole GC, 0 means no limit.
+	resolveRPCLimit int
+	// resolveLimiter is shared by all range workers of a GC to enforce resolveRPCLimit, nil means no limit.
+	resolveLimiter *util.RateLimit
+	// stats receives the statistics of the GC if it's not nil.
+	stats *GCStats
+	// storeLocks counts the locks per leader store for stats, it's nil if stats is nil.
+	storeLocks *storeLockCounter
+	// safePointResult receives the result of updating the GC safepoint if it's not nil.
+	safePointResult *GCSafePointResult
+	// onSafePointAdvanced is called with the safepoints before and after the update if it's not nil.
+	onSafePointAdvanced func(oldSafePoint, newSafePoint uint64)
+	// txnID restricts resolving to the locks of the transaction, 0 means the locks of all transactions.
+	txnID uint64
+	// txnStatusPrecheckWindow is the max distance between the start ts of a transaction and the safepoint for its
+	// locks to be checked before being resolved, 0 means no check.
+	txnStatusPrecheckWindow time.Duration
+	// txnStatusPrecheckMaxWait is the max total time of waiting for the checked transactions to finish.
+	txnStatusPrecheckMaxWait time.Duration
+	// priority is the priority of the requests sent by GC to scan and resolve the locks.
+	priority Priority
+	// scanPrefetch indicates whether to scan the next batch of locks while resolving the current one.
+	scanPrefetch bool
+	// maxSafePointAdvance is the max distance the safepoint advances by in a GC, 0 means no limit.
+	maxSafePointAdvance time.Duration
+	// rescanDelay is the base delay before re-scanning a region which has more locks than the scan limit, 0 means
+	// no delay.
+	rescanDelay time.Duration
+	// stuckLockRetries is the number of consecutive rounds resolving none of a batch of locks, after which the
+	// primaries of the locks are checked and the locks are resolved one by one, 0 means no check.
+	stuckLockRetries int
+}
+
+// GCStats is the statistics of resolving locks in a GC.
+type GCStats struct {
+	// CompletedRegions is the number of regions whose locks are resolved.
+	CompletedRegions int
+	// PessimisticLocks is the number of pessimistic locks encountered.
+	PessimisticLocks int
+	// LocksPerStore is the number of locks found in the regions led by each store, keyed by the store ID.
+	// The locks are counted to store 0 if the leader of the region is unknown.
+	LocksPerStore map[uint64]int
+}
+
+// storeLockCounter counts the locks per store concurrently.
+type storeLockCounter struct {
+	mu     sync.Mutex
+	counts map[uint64]int
+}
+
+func (c *storeLockCounter) add(storeID uint64, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[storeID] += n
+}
+
+func newGCOptions(opts []GCOption) *gcOptions {
+	o := &gcOptions{
+		scanLockTimeout:           ReadTimeoutMedium,
+		updateSafePointMaxBackoff: gcUpdateSafePointMaxBackoff,
+		resolveLockMaxBackoff:     gcResolveLockMaxBackoff,
+		priority:                  PriorityNormal,
+		resolveLockBatchSize:      gcResolveLockBatchSize,
+		resolveConcurrency:        1,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.resolveRPCLimit > 0 {
+		o.resolveLimiter = util.NewRateLimit(o.resolveRPCLimit)
+	}
+	if o.stats != nil {
+		o.storeLocks = &storeLockCounter{counts: make(map[uint64]int)}
+	}
+	return o
+}
+
+func (o *gcOptions) validate() error {
+	if o.scanLockTimeout <= 0 {
+		return errors.Errorf("[gc worker] scan lock timeout should be positive, got %v", o.scanLockTimeout)
+	}
+	if o.scanLockLimit < 0 {
+		return errors.Errorf("[gc worker] scan lock limit should not be negative, got %v", o.scanLockLimit)
+	}
+	if o.updateSafePointMaxBackoff < 0 {
+		return errors.Errorf("[gc worker] update safepoint max backoff should not be negative, got %v", o.updateSafePointMaxBackoff)
+	}
+	if o.resolveLockMaxBackoff <= 0 {
+		return errors.Errorf("[gc worker] resolve lock max backoff should be positive, got %v", o.resolveLockMaxBackoff)
+	}
+	if o.txnStatusPrecheckWindow < 0 {
+		return errors.Errorf("[gc worker] txn status precheck window should not be negative, got %v", o.txnStatusPrecheckWindow)
+	}
+	if o.txnStatusPrecheckWindow > 0 && o.txnStatusPrecheckMaxWait < time.Millisecond {
+		return errors.Errorf("[gc worker] txn status precheck max wait should be at least 1ms, got %v", o.txnStatusPrecheckMaxWait)
+	}
+	if o.resolveLockBatchSize <= 0 {
+		return errors.Errorf("[gc worker] resolve lock batch size should be positive, got %v", o.resolveLockBatchSize)
+	}
+	if o.resolveConcurrency <= 0 {
+		return errors.Errorf("[gc worker] resolve lock concurrency should be positive, got %v", o.resolveConcurrency)
+	}
+	if o.maxSafePointAdvance < 0 {
+		return errors.Errorf("[gc worker] max safepoint advance should not be negative, got %v", o.maxSafePointAdvance)
+	}
+	if o.rescanDelay < 0 {
+		return errors.Errorf("[gc worker] rescan delay should not be negative, got %v", o.rescanDelay)
+	}
+	if o.stuckLockRetries < 0 {
+		return errors.Errorf("[gc worker] stuck lock retries should not be negative, got %v", o.stuckLockRetries)
+	}
+	if o.resolveRPCLimit < 0 {
+		return errors.Errorf("[gc worker] resolve lock rpc limit should not be negative, got %v", o.resolveRPCLimit)
+	}
+	return nil
+}
+
+// requestContext returns the context of the requests sent by GC, i.e. the scan lock, resolve lock, check txn status,
+// check secondary locks and pessimistic rollback requests. Only the priority is set: the kvrpcpb.Context of the
+// kvproto version in use has neither a resource control group nor a request source field, and ResourceGroupTag is
+// the tag of the statement for TopSQL rather than either of them, so GC can't be bound to a resource group or tell
+// TiKV its requests come from the GC worker until kvproto is upgraded.
+func (o *gcOptions) requestContext() kvrpcpb.Context {
+	return kvrpcpb.Context{
+		Priority: o.priority.ToPB(),
+	}
+}
+
+// newResolveLockBackoffer creates a Backoffer to scan and resolve the locks of a region.
+func (o *gcOptions) newResolveLockBackoffer(ctx context.Context) *Backoffer {
+	return retry.NewBackofferWithVars(ctx, o.resolveLockMaxBackoff, nil)
+}
+
+// acquireResolveToken blocks until the resolve lock request is allowed to be sent, or the backoffer's
+// context is done.
+func (o *gcOptions) acquireResolveToken(bo *Backoffer) error {
+	if o.resolveLimiter == nil {
+		return nil
+	}
+	if exit := o.resolveLimiter.GetToken(bo.GetCtx().Done()); exit {
+		return errors.Trace(bo.GetCtx().Err())
+	}
 	return nil
 }
 
-// We don't want gc to sweep out the cached info belong to other processes, like coprocessor.
-const gcScanLockLimit = ResolvedCacheSize / 2
+func (o *gcOptions) releaseResolveToken() {
+	if o.resolveLimiter != nil {
+		o.resolveLimiter.PutToken()
+	}
+}
+
+// WithGCScanLockTimeout sets the timeout of the scan lock requests sent by GC. The default is ReadTimeoutMedium.
+// Raise it on clusters where scanning locks over dense regions is slow, to avoid needless timeouts and re-scans.
+func WithGCScanLockTimeout(timeout time.Duration) GCOption {
+	return func(o *gcOptions) {
+		o.scanLockTimeout = timeout
+	}
+}
+
+func (s *KVStore) resolveLocks(ctx context.Context, safePoint uint64, concurrency int, opts *gcOptions) (RangeTaskStat, error) {
+	handler := func(ctx context.Context, r kv.KeyRange) (RangeTaskStat, error) {
+		return s.resolveLocksForRange(ctx, safePoint, r.StartKey, r.EndKey, opts)
+	}
+
+	runner := NewRangeTaskRunner("resolve-locks-runner", s, concurrency, handler)
+	// Run resolve lock on the whole keyspace, or the whole TiKV cluster if no keyspace is set.
+	startKey, endKey := s.keyspaceRange()
+	err := runner.RunOnRange(ctx, startKey, endKey)
+	stat := runner.Stat()
+	if err != nil {
+		return stat, errors.Trace(err)
+	}
+	if n := runner.PessimisticLocks(); n > 0 {
+		s.ctxLogger(ctx).Info("[gc worker] pessimistic locks encountered",
+			zap.Uint64("safePoint", safePoint),
+			zap.Int("pessimistic locks", n),
+			zap.Bool("skipped", opts.skipPessimisticLocks))
+	}
+	if opts.stats != nil {
+		*opts.stats = GCStats{
+			CompletedRegions: runner.CompletedRegions(),
+			PessimisticLocks: runner.PessimisticLocks(),
+			LocksPerStore:    opts.storeLocks.counts,
+		}
+	}
+	return stat, nil
+}
+
+// EstimateRangeTasks returns the number of regions in [startKey, endKey) of the store's keyspace and the number of
+// stores leading them, without running any task. The regions are walked like RangeTaskRunner does, so it tells how
+// many regions GC would resolve locks in and how many stores it would send the requests to, which helps to size
+// the GC concurrency before kicking off a run. Empty keys mean unbounded, i.e. the whole keyspace like GC.
+// The regions without a known leader are counted, but their stores aren't.
+func (s *KVStore) EstimateRangeTasks(ctx context.Context, startKey, endKey []byte) (regionCount int, storeCount int, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	// The keys are encoded below, attach the keys of the caller.
+	defer func(startKey, endKey []byte) {
+		err = tikverr.WithOperation(err, "EstimateRangeTasks", startKey, endKey)
+	}(startKey, endKey)
+	if len(endKey) > 0 && bytes.Compare(startKey, endKey) > 0 {
+		return 0, 0, errors.Errorf("invalid key range [%s, %s)", kv.StrKey(startKey), kv.StrKey(endKey))
+	}
+	if len(endKey) > 0 && bytes.Equal(startKey, endKey) {
+		return 0, 0, nil
+	}
+	startKey = s.encodeKeyspaceKey(startKey)
+	if len(endKey) > 0 {
+		endKey = s.encodeKeyspaceKey(endKey)
+	} else {
+		_, endKey = s.keyspaceRange()
+	}
+
+	bo := retry.NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+	stores := make(map[uint64]struct{})
+	err = s.loadRegionsInRange(bo, startKey, endKey, func(r *locate.Region) {
+		regionCount++
+		if storeID := r.GetLeaderStoreID(); storeID != 0 {
+			stores[storeID] = struct{}{}
+		}
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return regionCount, len(stores), nil
+}
+
+// GCDryRun scans the locks whose timestamp is <= `safepoint` in the whole keyspace, or the whole TiKV cluster if
+// no keyspace is set, like GC does. But it's read-only: the locks are never resolved and PD's safepoint is never
+// updated, so it can be used to estimate the workload of GC before running it.
+func (s *KVStore) GCDryRun(ctx context.Context, safepoint uint64) (lockCount uint64, regionCount uint64, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() { err = tikverr.WithOperation(err, "GCDryRun", nil, nil) }()
+	ctx = s.withOperationID(ctx)
+	opts := newGCOptions(nil)
+	handler := func(ctx context.Context, r kv.KeyRange) (RangeTaskStat, error) {
+		return s.countLocksForRange(ctx, safepoint, r.StartKey, r.EndKey, opts, &lockCount)
+	}
+
+	runner := NewRangeTaskRunner("gc-dry-run-runner", s, 8, handler)
+	startKey, endKey := s.keyspaceRange()
+	if err = runner.RunOnRange(ctx, startKey, endKey); err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	return atomic.LoadUint64(&lockCount), uint64(runner.CompletedRegions()), nil
+}
+
+// VerifyNoPendingTxnBefore scans the locks in the whole keyspace, or the whole TiKV cluster if no keyspace is
+// set, to check that no transaction started before `safepoint` is still pending. It returns true if there is no
+// lock whose start ts is < `safepoint`, otherwise it returns false and the min start ts of the locks, so a GC
+// scheduler can refuse to advance the safepoint past a running transaction. Note that the locks of a crashed
+// transaction are reported too, they are left until they are resolved. It's read-only like GCDryRun.
+func (s *KVStore) VerifyNoPendingTxnBefore(ctx context.Context, safepoint uint64) (ok bool, oldestStartTS uint64, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() { err = tikverr.WithOperation(err, "VerifyNoPendingTxnBefore", nil, nil) }()
+	if safepoint == 0 {
+		return true, 0, nil
+	}
+	ctx = s.withOperationID(ctx)
+	opts := newGCOptions(nil)
+	var mu sync.Mutex
+	handler := func(ctx context.Context, r kv.KeyRange) (RangeTaskStat, error) {
+		var stat RangeTaskStat
+		err := s.scanLocksInPages(ctx, r.StartKey, r.EndKey, safepoint-1, s.gcScanLockLimit(opts), opts, func(locks []*Lock, _ *locate.KeyLocation, regionDone bool) error {
+			mu.Lock()
+			for _, l := range locks {
+				if oldestStartTS == 0 || l.TxnID < oldestStartTS {
+					oldestStartTS = l.TxnID
+				}
+			}
+			mu.Unlock()
+			if regionDone {
+				stat.CompletedRegions++
+			}
+			return nil
+		})
+		return stat, err
+	}
+
+	runner := NewRangeTaskRunner("verify-pending-txn-runner", s, 8, handler)
+	startKey, endKey := s.keyspaceRange()
+	if err = runner.RunOnRange(ctx, startKey, endKey); err != nil {
+		return false, 0, errors.Trace(err)
+	}
+	return oldestStartTS == 0, oldestStartTS, nil
+}
+
+// leaderStoreID returns the ID of the leader store of the region known by the region cache, or 0 if it's unknown.
+func (s *KVStore) leaderStoreID(id locate.RegionVerID) uint64 {
+	if r := s.regionCache.GetCachedRegionWithRLock(id); r != nil {
+		return r.GetLeaderStoreID()
+	}
+	return 0
+}
+
+// countLocksForRange scans the locks in the range region by region like resolveLocksForRange, and adds the
+// number of them to lockCount.
+func (s *KVStore) countLocksForRange(ctx context.Context, safePoint uint64, startKey []byte, endKey []byte, opts *gcOptions, lockCount *uint64) (RangeTaskStat, error) {
+	var stat RangeTaskStat
+	err := s.scanLocksInPages(ctx, startKey, endKey, safePoint, s.gcScanLockLimit(opts), opts, func(locks []*Lock, _ *locate.KeyLocation, regionDone bool) error {
+		atomic.AddUint64(lockCount, uint64(len(locks)))
+		if regionDone {
+			stat.CompletedRegions++
+		}
+		return nil
+	})
+	return stat, err
+}
+
+// ScanLocksInPages scans the locks whose timestamp is <= maxVersion in [startKey, endKey), an empty endKey means
+// the end of the keyspace. The locks are scanned region by region, at most pageSize locks a time, and fn is called
+// with each page before scanning the next one, so the memory is bounded by the page size no matter how dense the
+// locks are. It stops and returns the error if fn returns an error. If the store works in a keyspace, the keys are
+// prefixed with the keyspace prefix, and the keys of the locks are the encoded ones.
+func (s *KVStore) ScanLocksInPages(ctx context.Context, startKey, endKey []byte, maxVersion uint64, pageSize int, fn func(locks []*Lock) error) (err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	// The keys are encoded below, attach the keys of the caller.
+	defer func(startKey, endKey []byte) {
+		err = tikverr.WithOperation(err, "ScanLocksInPages", startKey, endKey)
+	}(startKey, endKey)
+	if pageSize <= 0 {
+		return errors.Errorf("page size should be positive, got %v", pageSize)
+	}
+	startKey = s.encodeKeyspaceKey(startKey)
+	if len(endKey) == 0 {
+		_, endKey = s.keyspaceRange()
+	} else {
+		endKey = s.encodeKeyspaceKey(endKey)
+	}
+	return s.scanLocksInPages(ctx, startKey, endKey, maxVersion, pageSize, newGCOptions(nil), func(locks []*Lock, _ *locate.KeyLocation, _ bool) error {
+		if len(locks) == 0 {
+			return nil
+		}
+		return fn(locks)
+	})
+}
+
+// scanLocksInPages scans the locks in [startKey, endKey) page by page, and calls fn with each page and the location
+// of the region it's scanned from. regionDone indicates whether it's the last page of the region.
+func (s *KVStore) scanLocksInPages(ctx context.Context, startKey, endKey []byte, maxVersion uint64, pageSize int, opts *gcOptions,
+	fn func(locks []*Lock, loc *locate.KeyLocation, regionDone bool) error) error {
+	key := startKey
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		default:
+		}
 
-func (s *KVStore) resolveLocksForRange(ctx context.Context, safePoint uint64, startKey []byte, endKey []byte) (RangeTaskStat, error) {
+		bo := opts.newResolveLockBackoffer(ctx)
+		locks, loc, err := s.scanLocksInRegionWithStartKey(bo, key, maxVersion, uint32(pageSize), opts)
+		if err != nil {
+			return err
+		}
+		regionDone := len(locks) < pageSize
+		if regionDone {
+			key = loc.EndKey
+		} else {
+			key = kv.NextKey(locks[len(locks)-1].Key)
+		}
+		// The region may exceed the range.
+		if len(endKey) != 0 {
+			i := len(locks)
+			for i > 0 && bytes.Compare(locks[i-1].Key, endKey) >= 0 {
+				i--
+			}
+			locks = locks[:i]
+		}
+		if err = fn(locks, loc, regionDone); err != nil {
+			return err
+		}
+
+		if len(key) == 0 || (len(endKey) != 0 && bytes.Compare(key, endKey) >= 0) {
+			return nil
+		}
+	}
+}
+
+// gcScanLockLimit returns the max number of locks to scan in a batch.
+// We don't want gc to sweep out the cached info belong to other processes, like coprocessor,
+// so it's half of the resolved cache size unless it's set explicitly.
+func (s *KVStore) gcScanLockLimit(opts *gcOptions) int {
+	if opts.scanLockLimit > 0 {
+		return opts.scanLockLimit
+	}
+	limit := s.lockResolver.getResolvedCacheSize() / 2
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// gcRegionTooManyLocksScans is the number of consecutive scans of a region returning as many locks as the limit,
+// after which GC warns that the region has too many locks.
+const gcRegionTooManyLocksScans = 10
+
+// scanLocksResult is the result of scanning a batch of locks in a region.
+type scanLocksResult struct {
+	locks []*Lock
+	loc   *locate.KeyLocation
+	err   error
+}
+
+func (s *KVStore) resolveLocksForRange(ctx context.Context, safePoint uint64, startKey []byte, endKey []byte, opts *gcOptions) (RangeTaskStat, error) {
 	// for scan lock request, we must return all locks even if they are generated
 	// by the same transaction. because gc worker need to make sure all locks have been
 	// cleaned.
 
 	var stat RangeTaskStat
 	key := startKey
-	bo := NewGcResolveLockMaxBackoffer(ctx)
+	scanLimit := s.gcScanLockLimit(opts)
+	// regionLocks is the number of locks found in the current region, which may be scanned in several batches.
+	regionLocks := 0
+	// overLimitScans is the number of consecutive scans of the current region returning as many locks as the limit.
+	overLimitScans := 0
+	bo := opts.newResolveLockBackoffer(ctx)
+	// Each batch of locks scanned from a region is traced by a span, finishSpan finishes the current one.
+	finishSpan := func() {}
+	defer func() { finishSpan() }()
+	// prefetched receives the next batch of locks scanned while resolving the current one, it's nil if the next
+	// batch isn't prefetched.
+	var prefetched chan scanLocksResult
 	for {
 		select {
 		case <-ctx.Done():
@@ -82,54 +737,174 @@ func (s *KVStore) resolveLocksForRange(ctx context.Context, safePoint uint64, st
 		default:
 		}
 
-		locks, loc, err := s.scanLocksInRegionWithStartKey(bo, key, safePoint, gcScanLockLimit)
+		var span opentracing.Span
+		span, finishSpan = startBackofferSpan(bo, "tikvStore.resolveLocksInRegion")
+		var res scanLocksResult
+		if prefetched != nil {
+			res = <-prefetched
+			prefetched = nil
+		} else {
+			res.locks, res.loc, res.err = s.scanLocksInRegionWithStartKey(bo, key, safePoint, uint32(scanLimit), opts)
+		}
+		locks, loc, err := res.locks, res.loc, res.err
 		if err != nil {
 			return stat, err
 		}
+		if span != nil {
+			span.SetTag("region_id", loc.Region.GetID())
+			span.SetTag("locks", len(locks))
+		}
+		// The paging is decided by the scanned locks, while only the locks of the transaction are resolved.
+		scanned := locks
+		if opts.txnID != 0 {
+			locks = filterLocksOfTxn(locks, opts.txnID, endKey)
+		}
+		if opts.storeLocks != nil && len(locks) > 0 {
+			opts.storeLocks.add(s.leaderStoreID(loc.Region), len(locks))
+		}
+		regionDone := len(scanned) < scanLimit
+		nextKey := loc.EndKey
+		if !regionDone {
+			// The last lock may be a skipped pessimistic lock, don't scan it again.
+			nextKey = kv.NextKey(scanned[len(scanned)-1].Key)
+		}
+		rangeDone := len(nextKey) == 0 || (len(endKey) != 0 && bytes.Compare(nextKey, endKey) >= 0)
+		// The re-scan of a region over the limit is delayed, so don't prefetch it.
+		if opts.scanPrefetch && !rangeDone && (regionDone || opts.rescanDelay == 0) {
+			// The next batch doesn't depend on resolving this one, scan it meanwhile to overlap the latency.
+			prefetched = make(chan scanLocksResult, 1)
+			go func(bo *Backoffer, key []byte) {
+				var res scanLocksResult
+				res.locks, res.loc, res.err = s.scanLocksInRegionWithStartKey(bo, key, safePoint, uint32(scanLimit), opts)
+				prefetched <- res
+			}(opts.newResolveLockBackoffer(ctx), nextKey)
+		}
 
-		resolvedLocation, err1 := s.batchResolveLocksInARegion(bo, locks, loc)
+		// Pessimistic locks can't be committed, they're left by transactions which have finished or crashed
+		// before the safepoint, so they should be rolled back by PessimisticRollback instead of ResolveLock.
+		pessimisticLocks, otherLocks := splitPessimisticLocks(locks)
+		stat.PessimisticLocks += len(pessimisticLocks)
+		if err = s.waitBorderlineTxns(bo.GetCtx(), otherLocks, safePoint, opts); err != nil {
+			return stat, errors.Trace(err)
+		}
+		resolvedLocation, err1 := s.parallelResolveLocksInARegion(bo, otherLocks, loc, opts)
 		if err1 != nil {
 			return stat, errors.Trace(err1)
 		}
-		// resolve locks failed since the locks are not in one region anymore, need retry.
-		if resolvedLocation == nil {
-			continue
+		if err = s.resolvePessimisticLocks(bo, pessimisticLocks, opts); err != nil {
+			return stat, errors.Trace(err)
 		}
-		if len(locks) < gcScanLockLimit {
+		regionLocks += len(locks)
+		key = nextKey
+		if regionDone {
 			stat.CompletedRegions++
-			key = loc.EndKey
-			logutil.Logger(ctx).Info("[gc worker] one region finshed ",
+			s.ctxLogger(ctx).Info("[gc worker] one region finshed ",
 				zap.Int("regionID", int(resolvedLocation.Region.GetID())),
 				zap.Int("resolvedLocksNum", len(locks)))
+			if s.eventSink != nil {
+				s.eventSink.OnGCRegionDone(resolvedLocation.Region.GetID(), regionLocks)
+			}
+			regionLocks = 0
+			overLimitScans = 0
 		} else {
-			logutil.Logger(ctx).Info("[gc worker] region has more than limit locks",
+			s.ctxLogger(ctx).Info("[gc worker] region has more than limit locks",
 				zap.Int("regionID", int(resolvedLocation.Region.GetID())),
 				zap.Int("resolvedLocksNum", len(locks)),
-				zap.Int("scan lock limit", gcScanLockLimit))
-			key = locks[len(locks)-1].Key
+				zap.Int("scan lock limit", scanLimit))
+			overLimitScans++
+			if overLimitScans == gcRegionTooManyLocksScans {
+				// It's likely that a stuck transaction keeps leaving locks in the region.
+				s.ctxLogger(ctx).Warn("[gc worker] region has too many locks",
+					zap.Uint64("regionID", resolvedLocation.Region.GetID()),
+					zap.Int("scans", overLimitScans),
+					zap.Int("locks", regionLocks),
+					zap.Int("scan lock limit", scanLimit))
+				metrics.TiKVGCRegionTooManyLocksCounter.Inc()
+			}
 		}
 
-		if len(key) == 0 || (len(endKey) != 0 && bytes.Compare(key, endKey) >= 0) {
+		finishSpan()
+		finishSpan = func() {}
+		if rangeDone {
 			break
 		}
-		bo = NewGcResolveLockMaxBackoffer(ctx)
+		if !regionDone && opts.rescanDelay > 0 {
+			select {
+			case <-time.After(jitterRescanDelay(opts.rescanDelay)):
+			case <-ctx.Done():
+			}
+		}
+		bo = opts.newResolveLockBackoffer(ctx)
 	}
 	return stat, nil
 }
 
-func (s *KVStore) scanLocksInRegionWithStartKey(bo *retry.Backoffer, startKey []byte, maxVersion uint64, limit uint32) (locks []*Lock, loc *locate.KeyLocation, err error) {
+// jitterRescanDelay returns a random delay in [delay/2, delay], so the range tasks don't re-scan in lockstep.
+func jitterRescanDelay(delay time.Duration) time.Duration {
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// ResolveLocksForTxn resolves the locks left by the transaction of startTS in [startKey, endKey), an empty endKey
+// means the end of the keyspace. It's a surgical alternative to GC for cleaning up a known stuck transaction: the
+// locks are scanned with startTS as the max version, and the locks of other transactions are left untouched. Like
+// GC, the transaction is rolled back if it's not committed, so make sure it's dead before calling it. It never
+// updates PD's GC safepoint.
+func (s *KVStore) ResolveLocksForTxn(ctx context.Context, startTS uint64, startKey, endKey []byte) (stat RangeTaskStat, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	// The keys are encoded below, attach the keys of the caller.
+	defer func(startKey, endKey []byte) {
+		err = tikverr.WithOperation(err, "ResolveLocksForTxn", startKey, endKey)
+	}(startKey, endKey)
+	if startTS == 0 {
+		return RangeTaskStat{}, errors.New("[gc worker] start ts of the transaction should be positive")
+	}
+	ctx = s.withOperationID(ctx)
+	startKey = s.encodeKeyspaceKey(startKey)
+	if len(endKey) == 0 {
+		_, endKey = s.keyspaceRange()
+	} else {
+		endKey = s.encodeKeyspaceKey(endKey)
+	}
+	opts := newGCOptions(nil)
+	opts.txnID = startTS
+	return s.resolveLocksForRange(ctx, startTS, startKey, endKey, opts)
+}
+
+// filterLocksOfTxn returns the locks of the transaction of txnID before endKey, an empty endKey means no bound.
+func filterLocksOfTxn(locks []*Lock, txnID uint64, endKey []byte) []*Lock {
+	res := make([]*Lock, 0, len(locks))
+	for _, l := range locks {
+		if len(endKey) != 0 && bytes.Compare(l.Key, endKey) >= 0 {
+			break
+		}
+		if l.TxnID == txnID {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+func (s *KVStore) scanLocksInRegionWithStartKey(bo *retry.Backoffer, startKey []byte, maxVersion uint64, limit uint32, opts *gcOptions) (locks []*Lock, loc *locate.KeyLocation, err error) {
 	for {
+		// Don't retry the region errors on a canceled context.
+		select {
+		case <-bo.GetCtx().Done():
+			return nil, nil, errors.Trace(bo.GetCtx().Err())
+		default:
+		}
 		loc, err := s.GetRegionCache().LocateKey(bo, startKey)
 		if err != nil {
 			return nil, loc, errors.Trace(err)
 		}
 		req := tikvrpc.NewRequest(tikvrpc.CmdScanLock, &kvrpcpb.ScanLockRequest{
 			MaxVersion: maxVersion,
-			Limit:      gcScanLockLimit,
+			Limit:      limit,
 			StartKey:   startKey,
 			EndKey:     loc.EndKey,
-		})
-		resp, err := s.SendReq(bo, req, loc.Region, ReadTimeoutMedium)
+		}, opts.requestContext())
+		resp, err := s.SendReq(bo, req, loc.Region, opts.scanLockTimeout)
 		if err != nil {
 			return nil, loc, errors.Trace(err)
 		}
@@ -140,6 +915,10 @@ func (s *KVStore) scanLocksInRegionWithStartKey(bo *retry.Backoffer, startKey []
 		if regionErr != nil {
 			err = bo.Backoff(BoRegionMiss(), errors.New(regionErr.String()))
 			if err != nil {
+				// The backoffer returns the region error if the context is done, report the context error.
+				if ctxErr := bo.GetCtx().Err(); ctxErr != nil {
+					return nil, loc, errors.Trace(ctxErr)
+				}
 				return nil, loc, errors.Trace(err)
 			}
 			continue
@@ -160,33 +939,495 @@ func (s *KVStore) scanLocksInRegionWithStartKey(bo *retry.Backoffer, startKey []
 	}
 }
 
+// waitBorderlineTxns checks the status of the transactions which start shortly before the safepoint, as they
+// may be committing right now, e.g. a large transaction committing slowly. It waits for the alive ones to finish
+// or expire, at most txnStatusPrecheckMaxWait in total, so they're less likely to be rolled back by GC. The locks
+// are resolved by force afterwards anyway, like the others.
+func (s *KVStore) waitBorderlineTxns(ctx context.Context, locks []*Lock, safePoint uint64, opts *gcOptions) error {
+	if opts.txnStatusPrecheckWindow <= 0 {
+		return nil
+	}
+	var borderline []*Lock
+	checked := make(map[uint64]struct{})
+	for _, l := range locks {
+		if _, ok := checked[l.TxnID]; ok {
+			continue
+		}
+		checked[l.TxnID] = struct{}{}
+		distance := oracle.ExtractPhysical(safePoint) - oracle.ExtractPhysical(l.TxnID)
+		if distance < opts.txnStatusPrecheckWindow.Milliseconds() {
+			borderline = append(borderline, l)
+		}
+	}
+	if len(borderline) == 0 {
+		return nil
+	}
+
+	bo := retry.NewBackofferWithVars(ctx, int(opts.txnStatusPrecheckMaxWait.Milliseconds()), nil)
+	for len(borderline) > 0 {
+		currentTS, err := s.GetOracle().GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		alive := borderline[:0]
+		for _, l := range borderline {
+			// It rolls back the transaction only if its TTL expires, and never rolls back a committing one.
+			status, err := s.lockResolver.getTxnStatus(bo, l.TxnID, l.Primary, 0, currentTS, true, false, l, opts.requestContext())
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if status.ttl > 0 {
+				alive = append(alive, l)
+			}
+		}
+		borderline = alive
+		if len(borderline) == 0 {
+			return nil
+		}
+		if err = bo.Backoff(retry.BoTxnLock, errors.Errorf("%d transactions near the safepoint are alive", len(borderline))); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return errors.Trace(ctxErr)
+			}
+			s.ctxLogger(ctx).Warn("[gc worker] transactions near the safepoint are still alive, resolve their locks anyway",
+				zap.Uint64("safePoint", safePoint),
+				zap.Uint64("txnID", borderline[0].TxnID),
+				zap.Int("txns", len(borderline)))
+			return nil
+		}
+	}
+	return nil
+}
+
+// splitPessimisticLocks splits the locks into pessimistic locks and the others.
+func splitPessimisticLocks(locks []*Lock) (pessimisticLocks []*Lock, otherLocks []*Lock) {
+	otherLocks = make([]*Lock, 0, len(locks))
+	for _, l := range locks {
+		if l.LockType == kvrpcpb.Op_PessimisticLock {
+			pessimisticLocks = append(pessimisticLocks, l)
+		} else {
+			otherLocks = append(otherLocks, l)
+		}
+	}
+	return pessimisticLocks, otherLocks
+}
+
+// resolvePessimisticLocks rolls back the pessimistic locks by PessimisticRollback. The pessimistic locks
+// are skipped if GC is told to skip them, or they're not expired while GC only rolls back the expired ones.
+func (s *KVStore) resolvePessimisticLocks(bo *Backoffer, locks []*Lock, opts *gcOptions) error {
+	if opts.skipPessimisticLocks {
+		return nil
+	}
+	for _, l := range locks {
+		if opts.expiredPessimisticLocksOnly && !s.GetOracle().IsExpired(l.TxnID, l.TTL, &oracle.Option{TxnScope: oracle.GlobalTxnScope}) {
+			s.ctxLogger(bo.GetCtx()).Info("[gc worker] skip the pessimistic lock not expired",
+				zap.Stringer("lock", l))
+			continue
+		}
+		if err := opts.acquireResolveToken(bo); err != nil {
+			return err
+		}
+		err := s.GetLockResolver().resolvePessimisticLock(bo, l, nil, opts.requestContext())
+		opts.releaseResolveToken()
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// ResolveLock resolves the lock on the given key left by the transaction of startTS. It's a fast path to clean
+// up a single known lock, without scanning and resolving the locks of a whole range like GC does. Like GC, the
+// transaction is rolled back if it's not committed, no matter whether the lock is expired, so make sure the
+// transaction is dead before calling it. It does nothing if the lock is already gone, so it's safe to call repeatedly.
+func (s *KVStore) ResolveLock(ctx context.Context, key []byte, startTS uint64) (err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	// The key is encoded below, attach the key of the caller.
+	defer func(key []byte) { err = tikverr.WithOperation(err, "ResolveLock", key, key) }(key)
+	ctx = s.withOperationID(ctx)
+	key = s.encodeKeyspaceKey(key)
+	opts := newGCOptions(nil)
+	bo := opts.newResolveLockBackoffer(ctx)
+	for {
+		// The locks are scanned in key order, so the lock on the key must be the first one if it exists.
+		locks, loc, err := s.scanLocksInRegionWithStartKey(bo, key, startTS, 1, opts)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(locks) == 0 || !bytes.Equal(locks[0].Key, key) || locks[0].TxnID != startTS {
+			s.ctxLogger(ctx).Info("[resolve lock] lock not found, it may be resolved already",
+				zap.String("key", kv.StrKey(key)),
+				zap.Uint64("startTS", startTS))
+			return nil
+		}
+		if locks[0].LockType == kvrpcpb.Op_PessimisticLock {
+			return errors.Trace(s.GetLockResolver().resolvePessimisticLock(bo, locks[0], nil, opts.requestContext()))
+		}
+		remain, err := s.GetLockResolver().batchResolveLocksInRegion(bo, locks, loc, opts.requestContext())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(remain) == 0 {
+			return nil
+		}
+		// The region has changed, scan the lock again.
+	}
+}
+
+// parallelResolveLocksInARegion groups the locks in a region by transaction, and resolves the groups with
+// batchResolveLocksInARegion concurrently. Looking up the status of the transactions is the bottleneck when
+// a region has locks of many transactions, resolving them concurrently speeds it up.
+// Used it in gcworker only!
+func (s *KVStore) parallelResolveLocksInARegion(bo *Backoffer, locks []*Lock, expectedLoc *locate.KeyLocation, opts *gcOptions) (*locate.KeyLocation, error) {
+	groups := groupLocksByTxn(locks, opts.resolveConcurrency)
+	if len(groups) <= 1 {
+		return s.batchResolveLocksInARegion(bo, locks, expectedLoc, opts)
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(groups))
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group []*Lock) {
+			defer wg.Done()
+			backoffer, cancel := bo.Fork()
+			defer cancel()
+			_, err := s.batchResolveLocksInARegion(backoffer, group, expectedLoc, opts)
+			errCh <- err
+		}(group)
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expectedLoc, nil
+}
+
+// groupLocksByTxn splits the locks into at most n groups, the locks of a transaction are in the same group.
+func groupLocksByTxn(locks []*Lock, n int) [][]*Lock {
+	groupOfTxn := make(map[uint64]int)
+	groups := make([][]*Lock, 0, n)
+	for _, l := range locks {
+		i, ok := groupOfTxn[l.TxnID]
+		if !ok {
+			i = len(groupOfTxn) % n
+			groupOfTxn[l.TxnID] = i
+			if i == len(groups) {
+				groups = append(groups, nil)
+			}
+		}
+		groups[i] = append(groups[i], l)
+	}
+	return groups
+}
+
+// gcResolveStaleRegionRounds is the number of consecutive rounds resolving nothing, after which the region of the
+// locks is reloaded from PD.
+const gcResolveStaleRegionRounds = 3
+
+// gcResolveLockBatchSize is the default max number of locks resolved by each batch resolve lock request.
+const gcResolveLockBatchSize = 1024
+
 // batchResolveLocksInARegion resolves locks in a region.
 // It returns the real location of the resolved locks if resolve locks success.
 // It returns error when meet an unretryable error.
-// When the locks are not in one region, resolve locks should be failed, it returns with nil resolveLocation and nil err.
+// The locks are resolved in batches of at most resolveLockBatchSize locks in key order, so a retry only resends
+// the current batch instead of all the locks.
 // Used it in gcworker only!
-func (s *KVStore) batchResolveLocksInARegion(bo *Backoffer, locks []*Lock, expectedLoc *locate.KeyLocation) (resolvedLocation *locate.KeyLocation, err error) {
-	resolvedLocation = expectedLoc
+func (s *KVStore) batchResolveLocksInARegion(bo *Backoffer, locks []*Lock, expectedLoc *locate.KeyLocation, opts *gcOptions) (resolvedLocation *locate.KeyLocation, err error) {
+	loc := expectedLoc
+	for len(locks) > 0 {
+		n := len(locks)
+		if n > opts.resolveLockBatchSize {
+			n = opts.resolveLockBatchSize
+		}
+		if !loc.Contains(locks[0].Key) {
+			// The previous batch ends at the end of the region, which has changed.
+			if loc, err = s.GetRegionCache().LocateKey(bo, locks[0].Key); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		if loc, err = s.resolveLockBatchInARegion(bo, locks[:n], loc, opts); err != nil {
+			return nil, err
+		}
+		locks = locks[n:]
+	}
+	return expectedLoc, nil
+}
+
+// resolveLockBatchInARegion resolves a batch of locks in a region, and returns the location of the last locks
+// resolved. If the region has changed, e.g. split, only the locks failed to resolve are retried in their new
+// regions. Each round of resolving holds a token of the GC's resolve lock rpc limit.
+func (s *KVStore) resolveLockBatchInARegion(bo *Backoffer, locks []*Lock, loc *locate.KeyLocation, opts *gcOptions) (*locate.KeyLocation, error) {
+	// staleRounds is the number of consecutive rounds resolving nothing since the region is reloaded.
+	staleRounds := 0
+	// unresolvedRounds is the number of consecutive rounds resolving nothing.
+	unresolvedRounds := 0
 	for {
-		ok, err := s.GetLockResolver().BatchResolveLocks(bo, locks, resolvedLocation.Region)
-		if ok {
-			return resolvedLocation, nil
+		if err := opts.acquireResolveToken(bo); err != nil {
+			return nil, err
 		}
+		remain, err := s.GetLockResolver().batchResolveLocksInRegion(bo, locks, loc, opts.requestContext())
+		opts.releaseResolveToken()
 		if err != nil {
 			return nil, err
 		}
-		err = bo.Backoff(retry.BoTxnLock, errors.Errorf("remain locks: %d", len(locks)))
+		if len(remain) == 0 {
+			return loc, nil
+		}
+		if len(remain) == len(locks) {
+			// Nothing is resolved in this round, the region cache is probably stale.
+			err = bo.Backoff(retry.BoTxnLock, errors.Errorf("remain locks: %d", len(remain)))
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			unresolvedRounds++
+			if opts.stuckLockRetries > 0 && unresolvedRounds >= opts.stuckLockRetries {
+				if err = s.resolveStuckLocks(bo, remain, unresolvedRounds, opts); err != nil {
+					return nil, err
+				}
+				return loc, nil
+			}
+			staleRounds++
+			if staleRounds >= gcResolveStaleRegionRounds {
+				// The regions keep changing, e.g. merging, locate the locks from PD instead of the cache.
+				s.ctxLogger(bo.GetCtx()).Info("[gc worker] region keeps changing while resolving locks, reload it",
+					zap.Uint64("regionID", loc.Region.GetID()),
+					zap.Int("rounds", staleRounds),
+					zap.Int("remain locks", len(remain)))
+				s.GetRegionCache().InvalidateCachedRegion(loc.Region)
+				staleRounds = 0
+			}
+		} else {
+			staleRounds = 0
+			unresolvedRounds = 0
+		}
+		locks = remain
+		loc, err = s.GetRegionCache().LocateKey(bo, locks[0].Key)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
-		region, err1 := s.GetRegionCache().LocateKey(bo, locks[0].Key)
-		if err1 != nil {
-			return nil, errors.Trace(err1)
+	}
+}
+
+// resolveStuckLocks resolves the locks which batch resolving keeps resolving none of, e.g. the status of their
+// transaction can't be settled in a batch, or the requests to their region keep failing. The status of each
+// transaction is checked on its primary lock and the transaction is rolled back if it's still alive, then its locks
+// are resolved one by one in the regions located by their own keys. It returns ErrGCLockStuck identifying the
+// transaction if it still fails, so GC fails instead of hanging on the locks until the backoff runs out.
+func (s *KVStore) resolveStuckLocks(bo *Backoffer, locks []*Lock, retries int, opts *gcOptions) error {
+	// Group the locks one transaction per group.
+	for _, txnLocks := range groupLocksByTxn(locks, len(locks)) {
+		l := txnLocks[0]
+		s.ctxLogger(bo.GetCtx()).Warn("[gc worker] locks are stuck, check the primary lock",
+			zap.Uint64("txnID", l.TxnID),
+			zap.String("primary", kv.StrKey(l.Primary)),
+			zap.Int("retries", retries),
+			zap.Int("locks", len(txnLocks)))
+		if err := s.resolveStuckTxnLocks(bo, txnLocks, opts.requestContext()); err != nil {
+			err = &tikverr.ErrGCLockStuck{TxnID: l.TxnID, Primary: l.Primary, Retries: retries, Err: err}
+			s.ctxLogger(bo.GetCtx()).Error("[gc worker] failed to resolve stuck locks", zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveStuckTxnLocks resolves the locks of a transaction one by one.
+func (s *KVStore) resolveStuckTxnLocks(bo *Backoffer, locks []*Lock, reqCtx kvrpcpb.Context) error {
+	lr := s.GetLockResolver()
+	l := locks[0]
+	// Use currentTS = math.MaxUint64 to roll back the transaction if it's still alive, as batch resolving does.
+	status, err := lr.getTxnStatus(bo, l.TxnID, l.Primary, 0, math.MaxUint64, true, false, l, reqCtx)
+	if err != nil {
+		return err
+	}
+	if status.primaryLock != nil && status.primaryLock.UseAsyncCommit {
+		// Resolving an async commit transaction resolves all its locks.
+		err = lr.resolveLockAsync(bo, l, status, reqCtx)
+		if _, ok := errors.Cause(err).(*nonAsyncCommitLock); !ok {
+			return err
+		}
+		if status, err = lr.getTxnStatus(bo, l.TxnID, l.Primary, 0, math.MaxUint64, true, true, l, reqCtx); err != nil {
+			return err
+		}
+	}
+	if status.ttl > 0 {
+		return errors.Errorf("txn is still alive, ttl %d", status.ttl)
+	}
+	cleanRegions := make(map[locate.RegionVerID]struct{})
+	for _, l := range locks {
+		if l.LockType == kvrpcpb.Op_PessimisticLock {
+			err = lr.resolvePessimisticLock(bo, l, cleanRegions, reqCtx)
+		} else {
+			err = lr.resolveLock(bo, l, status, true, cleanRegions, reqCtx)
 		}
-		if !region.Contains(locks[len(locks)-1].Key) {
-			// retry scan since the locks are not in the same region anymore.
-			return nil, nil
+		if err != nil {
+			return err
 		}
-		resolvedLocation = region
+	}
+	return nil
+}
+
+// WithGCStuckLockRetries sets the number of consecutive rounds resolving none of a batch of locks, after which GC
+// checks the primary locks of the stuck transactions and resolves their locks one by one, or fails with
+// tikverr.ErrGCLockStuck identifying the transaction if it still can't. It's 0 by default, which disables the
+// check, so GC keeps retrying until the resolve lock backoff runs out.
+func WithGCStuckLockRetries(retries int) GCOption {
+	return func(o *gcOptions) {
+		o.stuckLockRetries = retries
+	}
+}
+
+// WithGCScanLockLimit sets the max number of locks returned by each scan lock request sent by GC.
+// By default it's half of the resolved cache size of the lock resolver, see LockResolver.SetResolvedCacheSize.
+func WithGCScanLockLimit(limit int) GCOption {
+	return func(o *gcOptions) {
+		o.scanLockLimit = limit
+	}
+}
+
+// WithGCResolveLockBatchSize sets the max number of locks resolved by each batch resolve lock request sent by GC.
+// The locks of a region are resolved in batches of this size, so a retry after a failed request only resends one
+// batch. The default is 1024.
+func WithGCResolveLockBatchSize(size int) GCOption {
+	return func(o *gcOptions) {
+		o.resolveLockBatchSize = size
+	}
+}
+
+// WithGCUpdateSafePointMaxBackoff sets the max total sleep time of retrying to update the GC safepoint
+// to PD after all locks are resolved. The default is 20 seconds, and 0 means no retry.
+func WithGCUpdateSafePointMaxBackoff(maxBackoff time.Duration) GCOption {
+	return func(o *gcOptions) {
+		o.updateSafePointMaxBackoff = int(maxBackoff / time.Millisecond)
+	}
+}
+
+// WithGCResolveLockMaxBackoff sets the max total sleep time of retrying to scan and resolve the locks of a region,
+// the budget is reset for each region. The default is 100 seconds. Raise it to ride out transient PD or TiKV
+// slowness, or lower it to fail the GC sooner.
+func WithGCResolveLockMaxBackoff(maxBackoff time.Duration) GCOption {
+	return func(o *gcOptions) {
+		o.resolveLockMaxBackoff = int(maxBackoff / time.Millisecond)
+	}
+}
+
+// WithGCTxnStatusPrecheck makes GC check the status of the transactions starting less than window before the
+// safepoint before resolving their locks. Such a transaction may be committing right now, GC waits for it to
+// finish or expire, at most maxWait in total for the transactions of each batch of locks, to reduce the chance
+// of rolling back an in-flight commit. The locks are still resolved after the wait. By default, GC rolls back
+// all uncommitted transactions before the safepoint at once.
+func WithGCTxnStatusPrecheck(window, maxWait time.Duration) GCOption {
+	return func(o *gcOptions) {
+		o.txnStatusPrecheckWindow = window
+		o.txnStatusPrecheckMaxWait = maxWait
+	}
+}
+
+// WithGCRescanDelay makes GC wait a random delay in [delay/2, delay] before re-scanning a region which has more
+// locks than the scan limit. By default, such a region is re-scanned immediately, a region full of locks, e.g. left
+// by a stuck transaction, may keep a range task busy and starve the other ranges of the resolving capacity, the
+// delay lets the other range tasks share it. With WithGCScanPrefetch, such re-scans aren't prefetched.
+func WithGCRescanDelay(delay time.Duration) GCOption {
+	return func(o *gcOptions) {
+		o.rescanDelay = delay
+	}
+}
+
+// WithGCScanPrefetch makes GC scan the locks of the next region, or the next batch of locks of the same region,
+// while resolving the current batch, which pipelines scanning and resolving within each range task. It cuts the
+// time of GC on wide ranges with few locks, where most of the time is spent waiting for the scan requests, at the
+// cost of an in-flight scan request per range task.
+func WithGCScanPrefetch() GCOption {
+	return func(o *gcOptions) {
+		o.scanPrefetch = true
+	}
+}
+
+// WithGCPriority sets the priority of the requests sent by GC to scan and resolve the locks, e.g. PriorityLow lets
+// TiKV schedule them after the user queries. The default is PriorityNormal.
+func WithGCPriority(pri Priority) GCOption {
+	return func(o *gcOptions) {
+		o.priority = pri
+	}
+}
+
+// WithGCSkipPessimisticLocks makes GC leave the pessimistic locks unresolved, they're still counted in
+// RangeTaskStat.PessimisticLocks. By default, GC rolls back the pessimistic locks by PessimisticRollback.
+func WithGCSkipPessimisticLocks() GCOption {
+	return func(o *gcOptions) {
+		o.skipPessimisticLocks = true
+	}
+}
+
+// WithGCExpiredPessimisticLocksOnly makes GC roll back the pessimistic locks only if their TTL expire, so the
+// abandoned pessimistic locks are cleaned up while the ones still kept alive by their transactions are left.
+func WithGCExpiredPessimisticLocksOnly() GCOption {
+	return func(o *gcOptions) {
+		o.expiredPessimisticLocksOnly = true
+	}
+}
+
+// WithGCResolveLockConcurrency sets the max number of goroutines resolving the locks of a region concurrently,
+// the locks are grouped by transaction. The default is 1, raise it to speed up GC on regions with locks of
+// many transactions.
+func WithGCResolveLockConcurrency(concurrency int) GCOption {
+	return func(o *gcOptions) {
+		o.resolveConcurrency = concurrency
+	}
+}
+
+// WithGCResolveLockRPCLimit sets the max number of in-flight resolve lock requests of the whole GC, shared by
+// all range workers. The default is 0, which means no limit.
+//
+// It's different from the other concurrency knobs: the range concurrency of GC decides how many ranges are
+// scanned in parallel, and WithGCResolveLockConcurrency decides how many goroutines resolve the locks of a
+// region in parallel, so without this limit up to their product of resolve requests may hit TiKV at once
+// when many regions have locks. Set it to bound the burst, scanning isn't limited by it.
+func WithGCResolveLockRPCLimit(limit int) GCOption {
+	return func(o *gcOptions) {
+		o.resolveRPCLimit = limit
+	}
+}
+
+// WithGCSafePointResult makes GC fill the result of updating the GC safepoint to PD into res, so the caller can
+// tell whether the requested safepoint is honored or held back by PD. res is filled only if the update succeeds.
+func WithGCSafePointResult(res *GCSafePointResult) GCOption {
+	return func(o *gcOptions) {
+		o.safePointResult = res
+	}
+}
+
+// WithGCMaxSafePointAdvance makes GC clamp the safepoint to at most maxAdvance after the current GC safepoint, e.g.
+// never garbage collect more than 24h of history in one GC, which is a safety rail against a misconfigured
+// scheduler deleting too much at once. The locks are resolved up to the clamped safepoint, and the clamped
+// safepoint is returned. The current safepoint is queried from PD first, which costs one more PD request, and the
+// safepoint isn't clamped if GC has never run. The default is 0, which means no limit.
+func WithGCMaxSafePointAdvance(maxAdvance time.Duration) GCOption {
+	return func(o *gcOptions) {
+		o.maxSafePointAdvance = maxAdvance
+	}
+}
+
+// WithGCOnSafePointAdvanced makes GC call fn with the GC safepoints before and after updating it to PD, once the
+// update succeeds, so the caller can persist the progression of the safepoint, e.g. for an audit trail. The
+// safepoint before the update is queried from PD first, which costs one more PD request. newSafePoint equals
+// oldSafePoint if the safepoint isn't advanced. fn is called synchronously before GC returns.
+func WithGCOnSafePointAdvanced(fn func(oldSafePoint, newSafePoint uint64)) GCOption {
+	return func(o *gcOptions) {
+		o.onSafePointAdvanced = fn
+	}
+}
+
+// WithGCStats makes GC fill the statistics of resolving locks into stats, including the number of locks per
+// leader store, which helps to spot the hot or unhealthy stores. stats is filled only if all locks are resolved.
+func WithGCStats(stats *GCStats) GCOption {
+	return func(o *gcOptions) {
+		o.stats = stats
 	}
 }
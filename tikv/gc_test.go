@@ -0,0 +1,859 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/retry"
+	"github.com/tikv/client-go/v2/tikv/testutil"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	pd "github.com/tikv/pd/client"
+)
+
+// mockSafePointPDClient wraps a pd.Client and fails the first UpdateGCSafePoint calls.
+type mockSafePointPDClient struct {
+	pd.Client
+
+	mu       sync.Mutex
+	failures int
+	calls    int
+}
+
+func (c *mockSafePointPDClient) UpdateGCSafePoint(ctx context.Context, safePoint uint64) (uint64, error) {
+	c.mu.Lock()
+	c.calls++
+	fail := c.calls <= c.failures
+	c.mu.Unlock()
+	if fail {
+		return 0, errors.New("mock pd error")
+	}
+	return c.Client.UpdateGCSafePoint(ctx, safePoint)
+}
+
+func TestGCOptions(t *testing.T) {
+	opts := newGCOptions(nil)
+	assert.Nil(t, opts.validate())
+	assert.Equal(t, ReadTimeoutMedium, opts.scanLockTimeout)
+
+	opts = newGCOptions([]GCOption{WithGCScanLockTimeout(2 * time.Minute)})
+	assert.Nil(t, opts.validate())
+	assert.Equal(t, 2*time.Minute, opts.scanLockTimeout)
+
+	opts = newGCOptions([]GCOption{WithGCScanLockTimeout(0)})
+	assert.NotNil(t, opts.validate())
+
+	opts = newGCOptions([]GCOption{WithGCScanLockLimit(-1)})
+	assert.NotNil(t, opts.validate())
+
+	opts = newGCOptions([]GCOption{WithGCUpdateSafePointMaxBackoff(-time.Second)})
+	assert.NotNil(t, opts.validate())
+
+	assert.Equal(t, gcResolveLockMaxBackoff, newGCOptions(nil).resolveLockMaxBackoff)
+	opts = newGCOptions([]GCOption{WithGCResolveLockMaxBackoff(5 * time.Second)})
+	assert.Nil(t, opts.validate())
+	assert.Equal(t, 5000, opts.resolveLockMaxBackoff)
+	opts = newGCOptions([]GCOption{WithGCResolveLockMaxBackoff(0)})
+	assert.NotNil(t, opts.validate())
+
+	opts = newGCOptions([]GCOption{WithGCTxnStatusPrecheck(time.Minute, time.Second)})
+	assert.Nil(t, opts.validate())
+	opts = newGCOptions([]GCOption{WithGCTxnStatusPrecheck(time.Minute, 0)})
+	assert.NotNil(t, opts.validate())
+	opts = newGCOptions([]GCOption{WithGCTxnStatusPrecheck(-time.Minute, time.Second)})
+	assert.NotNil(t, opts.validate())
+
+	assert.Equal(t, gcResolveLockBatchSize, newGCOptions(nil).resolveLockBatchSize)
+	opts = newGCOptions([]GCOption{WithGCResolveLockBatchSize(0)})
+	assert.NotNil(t, opts.validate())
+
+	assert.Equal(t, 1, newGCOptions(nil).resolveConcurrency)
+	opts = newGCOptions([]GCOption{WithGCResolveLockConcurrency(0)})
+	assert.NotNil(t, opts.validate())
+
+	assert.Nil(t, newGCOptions(nil).resolveLimiter)
+	opts = newGCOptions([]GCOption{WithGCResolveLockRPCLimit(4)})
+	assert.Nil(t, opts.validate())
+	assert.Equal(t, 4, opts.resolveLimiter.GetCapacity())
+	opts = newGCOptions([]GCOption{WithGCResolveLockRPCLimit(-1)})
+	assert.NotNil(t, opts.validate())
+
+	assert.False(t, newGCOptions(nil).scanPrefetch)
+	assert.True(t, newGCOptions([]GCOption{WithGCScanPrefetch()}).scanPrefetch)
+
+	assert.Zero(t, newGCOptions(nil).rescanDelay)
+	opts = newGCOptions([]GCOption{WithGCRescanDelay(-time.Second)})
+	assert.NotNil(t, opts.validate())
+
+	assert.Zero(t, newGCOptions(nil).stuckLockRetries)
+	opts = newGCOptions([]GCOption{WithGCStuckLockRetries(-1)})
+	assert.NotNil(t, opts.validate())
+
+	assert.Nil(t, newGCOptions(nil).storeLocks)
+	assert.NotNil(t, newGCOptions([]GCOption{WithGCStats(&GCStats{})}).storeLocks)
+}
+
+func TestGCResolveToken(t *testing.T) {
+	opts := newGCOptions([]GCOption{WithGCResolveLockRPCLimit(1)})
+	bo := NewGcResolveLockMaxBackoffer(context.Background())
+	assert.Nil(t, opts.acquireResolveToken(bo))
+
+	// The token is taken, acquiring another one blocks until the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, errors.Cause(opts.acquireResolveToken(NewGcResolveLockMaxBackoffer(ctx))))
+
+	opts.releaseResolveToken()
+	assert.Nil(t, opts.acquireResolveToken(bo))
+	opts.releaseResolveToken()
+
+	// No limit by default.
+	opts = newGCOptions(nil)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, opts.acquireResolveToken(bo))
+	}
+}
+
+func TestGroupLocksByTxn(t *testing.T) {
+	locks := []*Lock{
+		{Key: []byte("a"), TxnID: 1},
+		{Key: []byte("b"), TxnID: 2},
+		{Key: []byte("c"), TxnID: 1},
+		{Key: []byte("d"), TxnID: 3},
+		{Key: []byte("e"), TxnID: 2},
+	}
+
+	groups := groupLocksByTxn(locks, 1)
+	assert.Equal(t, [][]*Lock{locks}, groups)
+
+	groups = groupLocksByTxn(locks, 2)
+	assert.Equal(t, [][]*Lock{{locks[0], locks[2], locks[3]}, {locks[1], locks[4]}}, groups)
+
+	groups = groupLocksByTxn(locks, 8)
+	assert.Equal(t, [][]*Lock{{locks[0], locks[2]}, {locks[1], locks[4]}, {locks[3]}}, groups)
+
+	assert.Len(t, groupLocksByTxn(nil, 8), 0)
+}
+
+func TestGCScanLockLimit(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+
+	assert.Equal(t, ResolvedCacheSize/2, store.gcScanLockLimit(newGCOptions(nil)))
+	assert.Nil(t, store.GetLockResolver().SetResolvedCacheSize(100))
+	assert.Equal(t, 50, store.gcScanLockLimit(newGCOptions(nil)))
+	assert.Nil(t, store.GetLockResolver().SetResolvedCacheSize(1))
+	assert.Equal(t, 1, store.gcScanLockLimit(newGCOptions(nil)))
+	// The limit set explicitly isn't affected by the cache size.
+	assert.Equal(t, 10, store.gcScanLockLimit(newGCOptions([]GCOption{WithGCScanLockLimit(10)})))
+}
+
+func TestGCRetryUpdateSafePoint(t *testing.T) {
+	mockPD := &mockSafePointPDClient{failures: 1}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	safePoint, err := store.GC(context.Background(), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), safePoint)
+	assert.Equal(t, 2, mockPD.calls)
+
+	// Don't retry if the retry budget is 0.
+	mockPD.calls, mockPD.failures = 0, 1
+	_, err = store.GC(context.Background(), 200, WithGCUpdateSafePointMaxBackoff(0))
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, mockPD.calls)
+}
+
+func TestGetGCSafePoint(t *testing.T) {
+	mockPD := &mockSafePointPDClient{}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	safePoint, err := store.GetGCSafePoint(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), safePoint)
+
+	_, err = store.GC(context.Background(), 100)
+	assert.Nil(t, err)
+	mockPD.calls, mockPD.failures = 0, 1
+	safePoint, err = store.GetGCSafePoint(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), safePoint)
+	assert.Equal(t, 2, mockPD.calls)
+}
+
+func TestGetGCSafePointPDServerTimeout(t *testing.T) {
+	mockPD := &mockSafePointPDClient{failures: 1 << 30}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	// Use a tiny retry budget, GetGCSafePoint shares the path with the default budget.
+	opts := newGCOptions(nil)
+	opts.updateSafePointMaxBackoff = 1
+	_, err := store.updateGCSafePoint(context.Background(), 0, opts)
+	_, ok := errors.Cause(err).(*tikverr.ErrPDServerTimeout)
+	assert.True(t, ok, "%v", err)
+	assert.Contains(t, err.Error(), "mock pd error")
+	assert.Greater(t, mockPD.calls, 1)
+}
+
+// prewriteLocks prewrites n keys with the given prefix in a transaction, and leaves the locks there.
+func prewriteLocks(t testing.TB, store *KVStore, prefix string, n int) uint64 {
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for i := 0; i < n; i++ {
+		require.Nil(t, txn.Set([]byte(fmt.Sprintf("%s%06d", prefix, i)), []byte("v")))
+	}
+	committer, err := newTwoPhaseCommitterWithInit(txn, 0)
+	require.Nil(t, err)
+	require.Nil(t, committer.prewriteMutations(retry.NewBackofferWithVars(context.Background(), PrewriteMaxBackoff, nil), committer.mutations))
+	return txn.StartTS()
+}
+
+func TestGCRegionTooManyLocks(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	readCounter := func() float64 {
+		pb := &dto.Metric{}
+		assert.Nil(t, metrics.TiKVGCRegionTooManyLocksCounter.Write(pb))
+		return pb.GetCounter().GetValue()
+	}
+
+	// The scans return as many locks as the limit more than gcRegionTooManyLocksScans times, but it's counted once.
+	// The locks of a transaction in the region are all resolved at once, so each scan returns another transaction.
+	for i := 0; i <= gcRegionTooManyLocksScans; i++ {
+		prewriteLocks(t, store, fmt.Sprintf("k%02d", i), 4)
+	}
+	safePoint, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	before := readCounter()
+	_, err = store.GC(context.Background(), safePoint, WithGCScanLockLimit(4))
+	assert.Nil(t, err)
+	assert.Equal(t, before+1, readCounter())
+
+	// A few scans of a region are fine.
+	prewriteLocks(t, store, "x", 8)
+	safePoint, err = store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	_, err = store.GC(context.Background(), safePoint, WithGCScanLockLimit(4))
+	assert.Nil(t, err)
+	assert.Equal(t, before+1, readCounter())
+}
+
+func TestGCScanPrefetch(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("k000010"), []byte("k000020"))
+	defer store.Close()
+	startTS := prewriteLocks(t, store, "k", 30)
+	safePoint, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+
+	// The regions are scanned in several batches, each of them prefetched while resolving the previous one.
+	var stats GCStats
+	_, err = store.GC(context.Background(), safePoint, WithGCScanPrefetch(), WithGCScanLockLimit(4), WithGCStats(&stats))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, stats.CompletedRegions)
+
+	count := 0
+	err = store.ScanLocksInPages(context.Background(), []byte("k"), []byte("l"), startTS, 100, func(locks []*Lock) error {
+		count += len(locks)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+// scanLockTimeClient records the time of each scan lock request.
+type scanLockTimeClient struct {
+	Client
+
+	mu    sync.Mutex
+	times []time.Time
+}
+
+func (c *scanLockTimeClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdScanLock {
+		c.mu.Lock()
+		c.times = append(c.times, time.Now())
+		c.mu.Unlock()
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestGCRescanDelay(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	client := &scanLockTimeClient{Client: store.GetTiKVClient()}
+	store.SetTiKVClient(client)
+
+	// The region is scanned 4 times, the first 3 scans return as many locks as the limit. The locks of a
+	// transaction in the region are all resolved at once, so each scan returns the locks of another transaction.
+	for _, prefix := range []string{"k", "l", "m"} {
+		prewriteLocks(t, store, prefix, 2)
+	}
+	safePoint, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	delay := 200 * time.Millisecond
+	_, err = store.GC(context.Background(), safePoint, WithGCScanLockLimit(2), WithGCRescanDelay(delay), WithGCScanPrefetch())
+	assert.Nil(t, err)
+	require.Len(t, client.times, 4)
+	for i := 1; i < len(client.times); i++ {
+		assert.GreaterOrEqual(t, int64(client.times[i].Sub(client.times[i-1])), int64(delay/2))
+	}
+}
+
+func TestGCTxnStatusPrecheck(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+
+	// The transaction is alive and starts right before the safepoint, GC waits for it before rolling it back.
+	startTS := prewriteLocks(t, store, "k", 3)
+	safePoint, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	start := time.Now()
+	_, err = store.GC(context.Background(), safePoint, WithGCTxnStatusPrecheck(time.Hour, 300*time.Millisecond))
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	locks, err := StoreProbe{store}.ScanLocks(context.Background(), []byte("k"), safePoint)
+	assert.Nil(t, err)
+	assert.Empty(t, locks)
+
+	// The transaction is far from the safepoint, it's rolled back at once.
+	prewriteLocks(t, store, "x", 3)
+	safePoint = oracle.ComposeTS(oracle.ExtractPhysical(startTS)+int64(time.Hour/time.Millisecond), 0)
+	_, err = store.GC(context.Background(), safePoint, WithGCTxnStatusPrecheck(time.Millisecond, 10*time.Second))
+	assert.Nil(t, err)
+	locks, err = StoreProbe{store}.ScanLocks(context.Background(), []byte("x"), safePoint)
+	assert.Nil(t, err)
+	assert.Empty(t, locks)
+}
+
+func TestGCSafePointWithFakePD(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	fakePD := testutil.NewPDClient()
+	StoreProbe{store}.SetSplitGCPDClient(fakePD)
+
+	newSafePoint, err := store.GC(context.Background(), 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), newSafePoint)
+
+	// The safepoint is never moved backward.
+	newSafePoint, err = store.GC(context.Background(), 500)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), newSafePoint)
+	safePoint, err := store.GetGCSafePoint(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), safePoint)
+	assert.Equal(t, uint64(1000), fakePD.GCSafePoint())
+}
+
+func TestGCSafePointResult(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	fakePD := testutil.NewPDClient()
+	StoreProbe{store}.SetSplitGCPDClient(fakePD)
+
+	var res GCSafePointResult
+	newSafePoint, err := store.GC(context.Background(), 1000, WithGCSafePointResult(&res))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), newSafePoint)
+	assert.Equal(t, GCSafePointResult{Requested: 1000, Effective: 1000, Honored: true}, res)
+
+	// The safepoint is held back by a service safepoint.
+	fakePD.SetServiceSafePoint(1500)
+	newSafePoint, err = store.GC(context.Background(), 2000, WithGCSafePointResult(&res))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1500), newSafePoint)
+	assert.Equal(t, GCSafePointResult{Requested: 2000, Effective: 1500, Honored: false}, res)
+}
+
+func TestGCOnSafePointAdvanced(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	fakePD := testutil.NewPDClient()
+	StoreProbe{store}.SetSplitGCPDClient(fakePD)
+
+	var history [][2]uint64
+	onAdvanced := WithGCOnSafePointAdvanced(func(oldSafePoint, newSafePoint uint64) {
+		history = append(history, [2]uint64{oldSafePoint, newSafePoint})
+	})
+	for _, safePoint := range []uint64{1000, 2000, 1500} {
+		_, err := store.GC(context.Background(), safePoint, onAdvanced)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, [][2]uint64{{0, 1000}, {1000, 2000}, {2000, 2000}}, history)
+}
+
+func TestGCMaxSafePointAdvance(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	fakePD := testutil.NewPDClient()
+	StoreProbe{store}.SetSplitGCPDClient(fakePD)
+
+	// The first GC isn't clamped.
+	now := time.Now()
+	first := oracle.GoTimeToTS(now.Add(-48 * time.Hour))
+	maxAdvance := WithGCMaxSafePointAdvance(24 * time.Hour)
+	newSafePoint, err := store.GC(context.Background(), first, maxAdvance)
+	assert.Nil(t, err)
+	assert.Equal(t, first, newSafePoint)
+
+	var res GCSafePointResult
+	requested := oracle.GoTimeToTS(now)
+	newSafePoint, err = store.GC(context.Background(), requested, maxAdvance, WithGCSafePointResult(&res))
+	assert.Nil(t, err)
+	clamped := oracle.GoTimeToTS(now.Add(-24 * time.Hour))
+	assert.Equal(t, clamped, newSafePoint)
+	assert.Equal(t, GCSafePointResult{Requested: requested, Effective: clamped, Honored: false}, res)
+
+	// The safepoint within the max advance isn't clamped.
+	newSafePoint, err = store.GC(context.Background(), requested, maxAdvance)
+	assert.Nil(t, err)
+	assert.Equal(t, requested, newSafePoint)
+
+	assert.NotNil(t, newGCOptions([]GCOption{WithGCMaxSafePointAdvance(-time.Hour)}).validate())
+}
+
+func TestResolveLocksUpToSafepoint(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("k000005"))
+	defer store.Close()
+	fakePD := testutil.NewPDClient()
+	StoreProbe{store}.SetSplitGCPDClient(fakePD)
+	startTS := prewriteLocks(t, store, "k", 10)
+	safePoint, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+
+	stat, err := store.ResolveLocksUpToSafepoint(context.Background(), safePoint, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stat.CompletedRegions)
+	count := 0
+	err = store.ScanLocksInPages(context.Background(), []byte("k"), []byte("l"), startTS, 100, func(locks []*Lock) error {
+		count += len(locks)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	// The safepoint is left to the caller.
+	assert.Equal(t, uint64(0), fakePD.GCSafePoint())
+
+	_, err = store.ResolveLocksUpToSafepoint(context.Background(), safePoint, 0)
+	assert.NotNil(t, err)
+}
+
+// reqCtxRecordClient records the contexts of the requests sent through it.
+type reqCtxRecordClient struct {
+	Client
+
+	mu   sync.Mutex
+	ctxs map[tikvrpc.CmdType][]kvrpcpb.Context
+}
+
+func (c *reqCtxRecordClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	c.mu.Lock()
+	c.ctxs[req.Type] = append(c.ctxs[req.Type], req.Context)
+	c.mu.Unlock()
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestGCRequestContext(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	client := &reqCtxRecordClient{Client: store.GetTiKVClient(), ctxs: make(map[tikvrpc.CmdType][]kvrpcpb.Context)}
+	store.SetTiKVClient(client)
+
+	prewriteLocks(t, store, "k", 3)
+	safePoint, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	_, err = store.GC(context.Background(), safePoint, WithGCPriority(PriorityLow))
+	assert.Nil(t, err)
+
+	for _, cmd := range []tikvrpc.CmdType{tikvrpc.CmdScanLock, tikvrpc.CmdCheckTxnStatus, tikvrpc.CmdResolveLock} {
+		assert.NotEmpty(t, client.ctxs[cmd], cmd.String())
+		for _, reqCtx := range client.ctxs[cmd] {
+			assert.Equal(t, kvrpcpb.CommandPri_Low, reqCtx.Priority, cmd.String())
+		}
+	}
+
+	// The stuck locks are resolved one by one with the same context.
+	client.ctxs = make(map[tikvrpc.CmdType][]kvrpcpb.Context)
+	prewriteLocks(t, store, "x", 3)
+	safePoint, err = store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	opts := newGCOptions([]GCOption{WithGCPriority(PriorityLow)})
+	bo := opts.newResolveLockBackoffer(context.Background())
+	locks, _, err := store.scanLocksInRegionWithStartKey(bo, []byte("x"), safePoint, 100, opts)
+	require.Nil(t, err)
+	require.Len(t, locks, 3)
+	assert.Nil(t, store.resolveStuckLocks(bo, locks, 1, opts))
+
+	// The pessimistic locks are rolled back with the same context.
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	txn.SetPessimistic(true)
+	require.Nil(t, txn.LockKeys(context.Background(), &kv.LockCtx{ForUpdateTS: txn.StartTS(), WaitStartTime: time.Now()}, []byte("p")))
+	l := &Lock{Key: []byte("p"), Primary: []byte("p"), TxnID: txn.StartTS(), LockForUpdateTS: txn.StartTS(), LockType: kvrpcpb.Op_PessimisticLock}
+	assert.Nil(t, store.resolvePessimisticLocks(bo, []*Lock{l}, opts))
+	for _, cmd := range []tikvrpc.CmdType{tikvrpc.CmdCheckTxnStatus, tikvrpc.CmdResolveLock, tikvrpc.CmdPessimisticRollback} {
+		assert.NotEmpty(t, client.ctxs[cmd], cmd.String())
+		for _, reqCtx := range client.ctxs[cmd] {
+			assert.Equal(t, kvrpcpb.CommandPri_Low, reqCtx.Priority, cmd.String())
+		}
+	}
+	assert.Nil(t, txn.Rollback())
+
+	reqCtx := newGCOptions(nil).requestContext()
+	assert.Equal(t, kvrpcpb.CommandPri_Normal, reqCtx.Priority)
+}
+
+func TestGCErrOperation(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+
+	_, err := store.GC(context.Background(), 100, WithGCScanLockTimeout(0))
+	opErr, ok := tikverr.OperationOf(err)
+	require.True(t, ok)
+	assert.Equal(t, "GC", opErr.Op)
+	assert.Nil(t, opErr.StartKey)
+
+	_, err = store.ResolveLocksForTxn(context.Background(), 0, []byte("a"), []byte("b"))
+	opErr, ok = tikverr.OperationOf(err)
+	require.True(t, ok)
+	assert.Equal(t, "ResolveLocksForTxn", opErr.Op)
+	assert.Equal(t, []byte("a"), opErr.StartKey)
+	assert.Equal(t, []byte("b"), opErr.EndKey)
+
+	err = store.ScanLocksInPages(context.Background(), []byte("a"), nil, 100, 0, nil)
+	opErr, ok = tikverr.OperationOf(err)
+	require.True(t, ok)
+	assert.Equal(t, "ScanLocksInPages", opErr.Op)
+	assert.Equal(t, []byte("a"), opErr.StartKey)
+	assert.Nil(t, opErr.EndKey)
+
+	_, err = store.DeleteRange(context.Background(), []byte("a"), nil, 1)
+	opErr, ok = tikverr.OperationOf(err)
+	require.True(t, ok)
+	assert.Equal(t, "DeleteRange", opErr.Op)
+
+	_, err = store.GC(context.Background(), 100)
+	assert.Nil(t, err)
+}
+
+func TestBatchResolveLocksInBatches(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	client := &reqCtxRecordClient{Client: store.GetTiKVClient(), ctxs: make(map[tikvrpc.CmdType][]kvrpcpb.Context)}
+	store.SetTiKVClient(client)
+
+	startTS := prewriteLocks(t, store, "k", 6)
+	safePoint, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	_, err = store.GC(context.Background(), safePoint, WithGCResolveLockBatchSize(2))
+	assert.Nil(t, err)
+	assert.Len(t, client.ctxs[tikvrpc.CmdResolveLock], 3)
+
+	count := 0
+	err = store.ScanLocksInPages(context.Background(), []byte("k"), []byte("l"), startTS, 100, func(locks []*Lock) error {
+		count += len(locks)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestBatchResolveLocksRegionChanged(t *testing.T) {
+	store, cluster := newTestKVStore(t, nil)
+	defer store.Close()
+	startTS := prewriteLocks(t, store, "k", 6)
+	safePoint, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	opts := newGCOptions(nil)
+	bo := opts.newResolveLockBackoffer(context.Background())
+	locks, loc, err := store.scanLocksInRegionWithStartKey(bo, []byte("k"), safePoint, 100, opts)
+	require.Nil(t, err)
+	require.Len(t, locks, 6)
+
+	// The region changes several times after the locks are scanned, so they span several regions.
+	for _, key := range []string{"k000002", "k000004"} {
+		region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte(key)))
+		ids := cluster.AllocIDs(2)
+		cluster.Split(region.GetId(), ids[0], []byte(key), []uint64{ids[1]}, ids[1])
+	}
+	_, err = store.batchResolveLocksInARegion(bo, locks, loc, opts)
+	assert.Nil(t, err)
+
+	count := 0
+	err = store.ScanLocksInPages(context.Background(), []byte("k"), []byte("l"), startTS, 100, func(locks []*Lock) error {
+		count += len(locks)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+// batchResolveErrClient fails the batch resolve lock requests with region errors, and the other resolve lock
+// requests with key errors if failResolve is set.
+type batchResolveErrClient struct {
+	Client
+
+	failResolve bool
+}
+
+func (c *batchResolveErrClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type != tikvrpc.CmdResolveLock {
+		return c.Client.SendRequest(ctx, addr, req, timeout)
+	}
+	if len(req.ResolveLock().TxnInfos) > 0 {
+		return &tikvrpc.Response{Resp: &kvrpcpb.ResolveLockResponse{
+			RegionError: &errorpb.Error{EpochNotMatch: &errorpb.EpochNotMatch{}},
+		}}, nil
+	}
+	if c.failResolve {
+		return &tikvrpc.Response{Resp: &kvrpcpb.ResolveLockResponse{
+			Error: &kvrpcpb.KeyError{Abort: "mock stuck lock"},
+		}}, nil
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestGCResolveStuckLocks(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	client := &batchResolveErrClient{Client: store.GetTiKVClient()}
+	store.SetTiKVClient(client)
+
+	startTS := prewriteLocks(t, store, "k", 3)
+	safePoint, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	opts := newGCOptions([]GCOption{WithGCStuckLockRetries(2)})
+	bo := opts.newResolveLockBackoffer(context.Background())
+	locks, loc, err := store.scanLocksInRegionWithStartKey(bo, []byte("k"), safePoint, 100, opts)
+	require.Nil(t, err)
+	require.Len(t, locks, 3)
+
+	// The locks can't be resolved one by one either, the stuck transaction is reported.
+	client.failResolve = true
+	_, err = store.batchResolveLocksInARegion(bo, locks, loc, opts)
+	stuckErr, ok := errors.Cause(err).(*tikverr.ErrGCLockStuck)
+	require.True(t, ok)
+	assert.Equal(t, startTS, stuckErr.TxnID)
+	assert.Equal(t, []byte("k000000"), stuckErr.Primary)
+	assert.Equal(t, 2, stuckErr.Retries)
+
+	// The batch keeps failing, so the locks are resolved one by one.
+	client.failResolve = false
+	bo = opts.newResolveLockBackoffer(context.Background())
+	_, err = store.batchResolveLocksInARegion(bo, locks, loc, opts)
+	assert.Nil(t, err)
+	count := 0
+	err = store.ScanLocksInPages(context.Background(), []byte("k"), []byte("l"), startTS, 100, func(locks []*Lock) error {
+		count += len(locks)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+// scanLockRegionErrClient fails the scan lock requests with region errors, and calls onScanLock on each of them.
+type scanLockRegionErrClient struct {
+	Client
+
+	scanLocks  int32
+	onScanLock func()
+}
+
+func (c *scanLockRegionErrClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type != tikvrpc.CmdScanLock {
+		return c.Client.SendRequest(ctx, addr, req, timeout)
+	}
+	atomic.AddInt32(&c.scanLocks, 1)
+	c.onScanLock()
+	return &tikvrpc.Response{Resp: &kvrpcpb.ScanLockResponse{
+		RegionError: &errorpb.Error{EpochNotMatch: &errorpb.EpochNotMatch{}},
+	}}, nil
+}
+
+func TestScanLocksCanceledBetweenRetries(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &scanLockRegionErrClient{Client: store.GetTiKVClient(), onScanLock: cancel}
+	store.SetTiKVClient(client)
+
+	opts := newGCOptions(nil)
+	_, _, err := store.scanLocksInRegionWithStartKey(opts.newResolveLockBackoffer(ctx), []byte("k"), 100, 10, opts)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&client.scanLocks))
+}
+
+func TestEstimateRangeTasks(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("b"), []byte("d"))
+	defer store.Close()
+
+	for _, c := range []struct {
+		startKey, endKey string
+		regions          int
+	}{
+		{"", "", 3},
+		{"a", "c", 2},
+		{"b", "d", 1},
+		{"c", "", 2},
+		{"c", "c", 0},
+	} {
+		regions, stores, err := store.EstimateRangeTasks(context.Background(), []byte(c.startKey), []byte(c.endKey))
+		assert.Nil(t, err)
+		assert.Equal(t, c.regions, regions)
+		if c.regions > 0 {
+			assert.Equal(t, 1, stores)
+		}
+	}
+
+	_, _, err := store.EstimateRangeTasks(context.Background(), []byte("d"), []byte("b"))
+	assert.NotNil(t, err)
+}
+
+func TestScanLocksInPages(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("k000050"))
+	defer store.Close()
+	startTS := prewriteLocks(t, store, "k", 100)
+
+	var pages []int
+	count := 0
+	err := store.ScanLocksInPages(context.Background(), []byte("k"), []byte("k000090"), startTS, 30, func(locks []*Lock) error {
+		pages = append(pages, len(locks))
+		count += len(locks)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 90, count)
+	for _, n := range pages {
+		assert.LessOrEqual(t, n, 30)
+	}
+
+	// Stop on the error of the callback.
+	mockErr := errors.New("stop")
+	pages = pages[:0]
+	err = store.ScanLocksInPages(context.Background(), nil, nil, startTS, 30, func(locks []*Lock) error {
+		pages = append(pages, len(locks))
+		return mockErr
+	})
+	assert.Equal(t, mockErr, errors.Cause(err))
+	assert.Len(t, pages, 1)
+
+	assert.NotNil(t, store.ScanLocksInPages(context.Background(), nil, nil, startTS, 0, nil))
+}
+
+func benchmarkScanLocks(b *testing.B, pageSize int) {
+	client, cluster, pdClient, err := mocktikv.NewTiKVAndPDClient("", nil)
+	require.Nil(b, err)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	store, err := NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	require.Nil(b, err)
+	defer store.Close()
+	const lockCount = 10000
+	startTS := prewriteLocks(b, store, "k", lockCount)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		err := store.ScanLocksInPages(context.Background(), nil, nil, startTS, pageSize, func(locks []*Lock) error {
+			count += len(locks)
+			return nil
+		})
+		require.Nil(b, err)
+		require.Equal(b, lockCount, count)
+	}
+}
+
+// BenchmarkScanLocksWhole loads all locks of the region at once, like scanning them into a single slice.
+func BenchmarkScanLocksWhole(b *testing.B) {
+	benchmarkScanLocks(b, 10000)
+}
+
+// BenchmarkScanLocksPaged scans the locks in small pages, the peak memory is bounded by the page size.
+func BenchmarkScanLocksPaged(b *testing.B) {
+	benchmarkScanLocks(b, 256)
+}
+
+func TestUnsafeDestroyRange(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+
+	// The unbounded and inverted ranges are rejected.
+	assert.NotNil(t, store.UnsafeDestroyRange(context.Background(), nil, nil))
+	assert.NotNil(t, store.UnsafeDestroyRange(context.Background(), []byte("a"), nil))
+	assert.NotNil(t, store.UnsafeDestroyRange(context.Background(), nil, []byte("b")))
+	assert.NotNil(t, store.UnsafeDestroyRange(context.Background(), []byte("b"), []byte("a")))
+	assert.NotNil(t, store.UnsafeDestroyRange(context.Background(), []byte("a"), []byte("a")))
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for _, key := range []string{"k1", "k2", "x", "p/a", "p/k1", "p/x"} {
+		require.Nil(t, txn.Set([]byte(key), []byte("v")))
+	}
+	require.Nil(t, txn.Commit(context.Background()))
+	exists := func(key string) bool {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		_, err = txn.Get(context.Background(), []byte(key))
+		if tikverr.IsErrNotFound(err) {
+			return false
+		}
+		require.Nil(t, err)
+		return true
+	}
+
+	require.Nil(t, store.UnsafeDestroyRange(context.Background(), []byte("k"), []byte("l")))
+	assert.False(t, exists("k1"))
+	assert.False(t, exists("k2"))
+	assert.True(t, exists("x"))
+	assert.True(t, exists("p/k1"))
+
+	// The range is in the keyspace, and an empty key means the start or end of the keyspace.
+	store.SetKeyspacePrefix([]byte("p/"))
+	require.Nil(t, store.UnsafeDestroyRange(context.Background(), []byte("k"), nil))
+	assert.False(t, exists("p/k1"))
+	assert.False(t, exists("p/x"))
+	assert.True(t, exists("p/a"))
+	require.Nil(t, store.UnsafeDestroyRange(context.Background(), nil, []byte("b")))
+	assert.False(t, exists("p/a"))
+	assert.True(t, exists("x"))
+}
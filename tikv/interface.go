@@ -33,11 +33,15 @@
 package tikv
 
 import (
+	"context"
 	"time"
 
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/tikv/client-go/v2/internal/locate"
 	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/tikvrpc"
+	pd "github.com/tikv/pd/client"
 )
 
 // Storage represent the kv.Storage runs on TiKV.
@@ -80,3 +84,49 @@ type Storage interface {
 	// SupportDeleteRange gets the storage support delete range or not.
 	SupportDeleteRange() (supported bool)
 }
+
+// SplittableStore is the kv store which supports split regions.
+// Implementations of fakes for the split API can assert conformance with
+// `var _ SplittableStore = (*myFake)(nil)`.
+type SplittableStore interface {
+	// SplitRegions splits regions by splitKeys and returns the IDs of the new regions.
+	// If scatter is true, the new regions are scattered to other stores.
+	SplitRegions(ctx context.Context, splitKeys [][]byte, scatter bool, tableID *int64) (regionIDs []uint64, err error)
+	// WaitScatterRegionFinish waits until the scatter operator of the region finishes.
+	// backOff is the max back off time(in ms) of the wait, a non-positive value means the default.
+	WaitScatterRegionFinish(ctx context.Context, regionID uint64, backOff int) error
+	// CheckRegionInScattering checks whether the region is still being scattered.
+	CheckRegionInScattering(regionID uint64) (bool, error)
+}
+
+var _ SplittableStore = (*KVStore)(nil)
+
+// GCStore is the kv store which supports GC.
+// Like SplittableStore, fakes can assert conformance with `var _ GCStore = (*myFake)(nil)`.
+type GCStore interface {
+	// GC resolves the locks before the safepoint and updates the GC safepoint, it returns the new safepoint.
+	GC(ctx context.Context, safepoint uint64, opts ...GCOption) (newSafePoint uint64, err error)
+	// GetGCSafePoint returns the current GC safepoint of the cluster.
+	GetGCSafePoint(ctx context.Context) (uint64, error)
+}
+
+var _ GCStore = (*KVStore)(nil)
+
+// PDClient is the subset of pd.Client used by the split, scatter and GC paths of KVStore. It's small enough to
+// be faked in memory, e.g. by testutil.PDClient, so the paths can be tested without PD.
+type PDClient interface {
+	// ScatterRegions scatters the regions.
+	ScatterRegions(ctx context.Context, regionsID []uint64, opts ...pd.RegionsOption) (*pdpb.ScatterRegionResponse, error)
+	// GetOperator gets the running operator of the region.
+	GetOperator(ctx context.Context, regionID uint64) (*pdpb.GetOperatorResponse, error)
+	// UpdateGCSafePoint updates the GC safepoint and returns the new one, which never moves backward.
+	UpdateGCSafePoint(ctx context.Context, safePoint uint64) (uint64, error)
+	// GetAllStores gets all stores of the cluster.
+	GetAllStores(ctx context.Context, opts ...pd.GetStoreOption) ([]*metapb.Store, error)
+	// GetRegionByID gets the region and its leader by the region ID, the region is nil if it's not found.
+	GetRegionByID(ctx context.Context, regionID uint64) (*pd.Region, error)
+	// GetLeaderAddr returns the address of the PD leader, whose HTTP API is used where the gRPC API lacks a feature.
+	GetLeaderAddr() string
+}
+
+var _ PDClient = (pd.Client)(nil)
@@ -33,6 +33,7 @@
 package tikv
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
@@ -43,6 +44,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
@@ -117,9 +119,97 @@ type KVStore struct {
 
 	replicaReadSeed uint32 // this is used to load balance followers / learners when replica read is enabled
 
+	// keyspacePrefix is the prefix of the keyspace the store works in, empty means no keyspace.
+	keyspacePrefix []byte
+	// logger is used by the split, scatter and GC paths, nil means the global logger.
+	logger *zap.Logger
+	// eventSink receives the split and GC events, nil means the events are dropped.
+	eventSink EventSink
+	// hooks are the test seams injected by StoreProbe.
+	hooks testHooks
+	// preSplitNoWaitScatter is set to 1 if 2PC doesn't wait for the pre-split regions to be scattered.
+	preSplitNoWaitScatter uint32
+	// preSplitScatterWaitTimeout is the max time(in ms) 2PC waits for the pre-split regions to be scattered,
+	// 0 means defaultPreSplitScatterWaitTimeout.
+	preSplitScatterWaitTimeout int64
+	// splitGCPDClient is used by the split, scatter and GC paths, it's pdClient unless set by WithSplitGCPDClient.
+	splitGCPDClient PDClient
+
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
+	// ops tracks the in-flight split, scatter and GC operations, Close cancels them and waits for them to return.
+	ops struct {
+		sync.Mutex
+		wg     sync.WaitGroup
+		closed bool
+		// inflight is the number of the in-flight operations.
+		inflight int
+	}
+	// closeOpsWaitTimeout is the max time Close waits for the in-flight operations to return.
+	closeOpsWaitTimeout time.Duration
+}
+
+// closeOpsWaitTimeout is the default max time Close waits for the in-flight split, scatter and GC operations to
+// return.
+const closeOpsWaitTimeout = 10 * time.Second
+
+// startOp registers an in-flight split, scatter or GC operation. The returned context is canceled once the store
+// is closed, or it's canceled already if the store is closed. The returned function must be called when the
+// operation returns.
+func (s *KVStore) startOp(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	s.ops.Lock()
+	defer s.ops.Unlock()
+	if s.ops.closed {
+		cancel()
+		return ctx, func() {}
+	}
+	s.ops.wg.Add(1)
+	s.ops.inflight++
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	return ctx, func() {
+		close(stop)
+		cancel()
+		s.ops.Lock()
+		s.ops.inflight--
+		s.ops.Unlock()
+		s.ops.wg.Done()
+	}
+}
+
+// waitOps cancels the in-flight split, scatter and GC operations, and waits for them to return, at most timeout.
+// It returns the number of the operations abandoned, which are still running after timeout.
+func (s *KVStore) waitOps(timeout time.Duration) int {
+	s.ops.Lock()
+	s.ops.closed = true
+	s.ops.Unlock()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.ops.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return 0
+	case <-time.After(timeout):
+		s.ops.Lock()
+		abandoned := s.ops.inflight
+		s.ops.Unlock()
+		s.bgLogger().Warn("in-flight split, scatter or gc operations don't return in time after the store is closed",
+			zap.Duration("timeout", timeout),
+			zap.Int("abandoned operations", abandoned))
+		return abandoned
+	}
 }
 
 // UpdateSPCache updates cached safepoint.
@@ -154,8 +244,28 @@ func (s *KVStore) CheckVisibility(startTime uint64) error {
 	return nil
 }
 
+// Option configures the KVStore created by NewKVStore.
+type Option func(*KVStore)
+
+// WithSplitGCPDClient makes the store send the PD requests of the split, scatter and GC paths by the client
+// instead of the PD client of the store, e.g. a fake of testutil.PDClient, or a client with its own timeouts.
+func WithSplitGCPDClient(client PDClient) Option {
+	return func(s *KVStore) {
+		s.splitGCPDClient = client
+	}
+}
+
+// WithCloseOpsWaitTimeout sets the max time Close waits for the in-flight split, scatter and GC operations to return
+// after canceling them, 10s by default. The operations still running after it are abandoned, and their number is
+// logged, e.g. an operation blocked in a call that ignores the context.
+func WithCloseOpsWaitTimeout(timeout time.Duration) Option {
+	return func(s *KVStore) {
+		s.closeOpsWaitTimeout = timeout
+	}
+}
+
 // NewKVStore creates a new TiKV store instance.
-func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Client) (*KVStore, error) {
+func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Client, opts ...Option) (*KVStore, error) {
 	o, err := oracles.NewPdOracle(pdClient, time.Duration(oracleUpdateInterval)*time.Millisecond)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -166,6 +276,7 @@ func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Cl
 		uuid:            uuid,
 		oracle:          o,
 		pdClient:        pdClient,
+		splitGCPDClient: pdClient,
 		regionCache:     locate.NewRegionCache(pdClient),
 		kv:              spkv,
 		safePoint:       0,
@@ -174,6 +285,10 @@ func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Cl
 		ctx:             ctx,
 		cancel:          cancel,
 	}
+	store.closeOpsWaitTimeout = closeOpsWaitTimeout
+	for _, opt := range opts {
+		opt(store)
+	}
 	store.clientMu.client = client.NewReqCollapse(tikvclient)
 	store.lockResolver = newLockResolver(store)
 
@@ -246,6 +361,89 @@ func (s *KVStore) EnableTxnLocalLatches(size uint) {
 	s.txnLatches = latch.NewScheduler(size)
 }
 
+// SetKeyspacePrefix sets the prefix of the keyspace the store works in. Once set, the split keys passed
+// to SplitRegions are prefixed with it, and GC only resolves locks in the keyspace. It should be called
+// before using the store.
+func (s *KVStore) SetKeyspacePrefix(prefix []byte) {
+	s.keyspacePrefix = prefix
+}
+
+// encodeKeyspaceKey prefixes the key with the keyspace prefix.
+func (s *KVStore) encodeKeyspaceKey(key []byte) []byte {
+	if len(s.keyspacePrefix) == 0 {
+		return key
+	}
+	buf := make([]byte, 0, len(s.keyspacePrefix)+len(key))
+	buf = append(buf, s.keyspacePrefix...)
+	return append(buf, key...)
+}
+
+// keyspaceRange returns the encoded range of the whole keyspace. Empty keys mean the range is unbounded.
+func (s *KVStore) keyspaceRange() (startKey []byte, endKey []byte) {
+	if len(s.keyspacePrefix) == 0 {
+		return []byte(""), []byte("")
+	}
+	return s.keyspacePrefix, kv.PrefixNextKey(s.keyspacePrefix)
+}
+
+// decodeKeyspaceRange strips the keyspace prefix from the range of a region in the keyspace. The bounds outside the
+// keyspace are returned as empty keys, which mean the range is unbounded.
+func (s *KVStore) decodeKeyspaceRange(startKey, endKey []byte) ([]byte, []byte) {
+	if len(s.keyspacePrefix) == 0 {
+		return startKey, endKey
+	}
+	if bytes.HasPrefix(startKey, s.keyspacePrefix) {
+		startKey = startKey[len(s.keyspacePrefix):]
+	} else {
+		startKey = []byte{}
+	}
+	if bytes.HasPrefix(endKey, s.keyspacePrefix) {
+		endKey = endKey[len(s.keyspacePrefix):]
+	} else {
+		endKey = []byte{}
+	}
+	return startKey, endKey
+}
+
+// SetLogger sets the logger used by the split, scatter and GC paths of the store, so the logs of the stores
+// embedded in one process can carry their own fields. It should be called before using the store.
+func (s *KVStore) SetLogger(logger *zap.Logger) {
+	s.logger = logger
+}
+
+// bgLogger returns the logger of the store, or the global logger if it's not set.
+func (s *KVStore) bgLogger() *zap.Logger {
+	if s.logger != nil {
+		return s.logger
+	}
+	return logutil.BgLogger()
+}
+
+// ctxLogger returns the logger carried by the context, or the logger of the store if there isn't one.
+func (s *KVStore) ctxLogger(ctx context.Context) *zap.Logger {
+	if ctxLogger, ok := ctx.Value(logutil.CtxLogKey).(*zap.Logger); ok {
+		return ctxLogger
+	}
+	return s.bgLogger()
+}
+
+type loggedOperationIDCtxKey struct{}
+
+// withOperationID attaches the operation ID to the logger in the context, so the logs of the whole operation can be
+// correlated. The ID set by util.SetOperationID is used, or a random one is generated and set if it's absent.
+// It's called by the top-level split and GC calls, and does nothing if the ID is already attached.
+func (s *KVStore) withOperationID(ctx context.Context) context.Context {
+	id, ok := ctx.Value(util.OperationID).(string)
+	if !ok || id == "" {
+		id = uuid.New().String()
+		ctx = util.SetOperationID(ctx, id)
+	} else if logged, _ := ctx.Value(loggedOperationIDCtxKey{}).(string); logged == id {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, logutil.CtxLogKey, s.ctxLogger(ctx).With(zap.String("operationID", id)))
+	return context.WithValue(ctx, loggedOperationIDCtxKey{}, id)
+}
+
 // IsLatchEnabled is used by mockstore.TestConfig.
 func (s *KVStore) IsLatchEnabled() bool {
 	return s.txnLatches != nil
@@ -290,9 +488,10 @@ func (s *KVStore) GetSnapshot(ts uint64) *KVSnapshot {
 	return snapshot
 }
 
-// Close store
+// Close store. The in-flight split, scatter and GC operations are canceled, and Close waits for them to return
+// for a while.
 func (s *KVStore) Close() error {
-	s.cancel()
+	s.waitOps(s.closeOpsWaitTimeout)
 	s.wg.Wait()
 
 	s.oracle.Close()
@@ -387,6 +586,40 @@ func (s *KVStore) GetRegionCache() *locate.RegionCache {
 	return s.regionCache
 }
 
+// LocateKeys locates the regions of the given keys, the returned locations are aligned with keys.
+// Keys are grouped by region first, so keys that fall into the same region share one lookup. It's
+// much cheaper than calling LocateKey for each key when the keys are sorted.
+func (s *KVStore) LocateKeys(bo *Backoffer, keys [][]byte) ([]*locate.KeyLocation, error) {
+	groups, _, err := s.regionCache.GroupKeysByRegion(bo, keys, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	locByKey := make(map[string]*locate.KeyLocation, len(keys))
+	for _, groupKeys := range groups {
+		// The region has just been loaded by GroupKeysByRegion, so it's a cache hit.
+		loc, err := s.regionCache.LocateKey(bo, groupKeys[0])
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, key := range groupKeys {
+			locByKey[string(key)] = loc
+		}
+	}
+	locs := make([]*locate.KeyLocation, len(keys))
+	for i, key := range keys {
+		loc := locByKey[string(key)]
+		// The region may have been changed after grouping, locate the key again.
+		if loc == nil || !loc.Contains(key) {
+			loc, err = s.regionCache.LocateKey(bo, key)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		locs[i] = loc
+	}
+	return locs, nil
+}
+
 // GetLockResolver returns the lock resolver instance.
 func (s *KVStore) GetLockResolver() *LockResolver {
 	return s.lockResolver
@@ -0,0 +1,179 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/logutil"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/util"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newTestKVStore creates a KVStore backed by mocktikv, the cluster is split into len(splitKeys)+1 regions.
+// pdClientHijack can be used to wrap the pd client, it's ignored if nil.
+func newTestKVStore(t *testing.T, pdClientHijack func(pd.Client) pd.Client, splitKeys ...[]byte) (*KVStore, *mocktikv.Cluster) {
+	client, cluster, pdClient, err := mocktikv.NewTiKVAndPDClient("", nil)
+	require.Nil(t, err)
+	mocktikv.BootstrapWithMultiRegions(cluster, splitKeys...)
+	store, err := NewTestTiKVStore(client, pdClient, nil, pdClientHijack, 0)
+	require.Nil(t, err)
+	return store, cluster
+}
+
+func TestLocateKeys(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("b"), []byte("d"))
+	defer store.Close()
+
+	bo := NewBackofferWithVars(context.Background(), 5000, nil)
+	keys := [][]byte{[]byte("a"), []byte("c"), []byte("a1"), []byte("e"), []byte("b")}
+	locs, err := store.LocateKeys(bo, keys)
+	assert.Nil(t, err)
+	assert.Len(t, locs, len(keys))
+	for i, key := range keys {
+		assert.True(t, locs[i].Contains(key))
+	}
+	assert.Equal(t, locs[0].Region, locs[2].Region)
+	assert.Equal(t, locs[1].Region, locs[4].Region)
+	assert.NotEqual(t, locs[0].Region, locs[1].Region)
+	assert.NotEqual(t, locs[1].Region, locs[3].Region)
+}
+
+func TestNewBulkOpBackoffer(t *testing.T) {
+	store, cluster := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+
+	// Group the keys by region with a Backoffer, and split each group.
+	bo := NewBulkOpBackoffer(context.Background(), 2000)
+	keys := [][]byte{[]byte("b"), []byte("x"), []byte("d")}
+	groups, _, err := store.GetRegionCache().GroupKeysByRegion(bo, keys, FilterKeysAtRegionStart)
+	require.Nil(t, err)
+	assert.Len(t, groups, 2)
+	for _, batch := range ChunkKeysByRegion(groups, -1) {
+		regionIDs, err := store.SplitRegions(bo.GetCtx(), batch.Keys, false, nil)
+		assert.Nil(t, err)
+		assert.Len(t, regionIDs, len(batch.Keys))
+	}
+	for _, key := range keys {
+		region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey(key))
+		assert.Equal(t, []byte(mocktikv.NewMvccKey(key)), region.GetStartKey())
+	}
+
+	// The default budget is used if the given one isn't positive.
+	bo = NewBulkOpBackoffer(context.Background(), 0)
+	assert.Nil(t, bo.Backoff(BoRegionMiss(), errors.New("region miss")))
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	// The flaky function succeeds on the third call.
+	calls := 0
+	flaky := func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("flaky")
+		}
+		return nil
+	}
+	bo := NewBackofferWithVars(context.Background(), 1000, nil)
+	assert.Nil(t, RetryWithBackoff(bo, BoRegionMiss(), flaky))
+	assert.Equal(t, 3, calls)
+	assert.Greater(t, bo.GetTotalSleep(), 0)
+
+	// The function always failing gives up after the budget runs out.
+	calls = 0
+	bo = NewBackofferWithVars(context.Background(), 10, nil)
+	err := RetryWithBackoff(bo, BoRegionMiss(), func() error {
+		calls++
+		return errors.New("always fail")
+	})
+	assert.NotNil(t, err)
+	assert.Greater(t, calls, 1)
+}
+
+func TestKeyspacePrefix(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+
+	startKey, endKey := store.keyspaceRange()
+	assert.Empty(t, startKey)
+	assert.Empty(t, endKey)
+	assert.Equal(t, []byte("k"), store.encodeKeyspaceKey([]byte("k")))
+
+	store.SetKeyspacePrefix([]byte("x1"))
+	startKey, endKey = store.keyspaceRange()
+	assert.Equal(t, []byte("x1"), startKey)
+	assert.Equal(t, []byte("x2"), endKey)
+	assert.Equal(t, []byte("x1k"), store.encodeKeyspaceKey([]byte("k")))
+}
+
+func TestSetLogger(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	assert.Equal(t, logutil.BgLogger(), store.bgLogger())
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	store.SetLogger(zap.New(core).With(zap.String("tenant", "t1")))
+	assert.Nil(t, store.WaitScatterRegionFinish(context.Background(), 1, 0))
+	entries := logs.FilterField(zap.String("tenant", "t1")).All()
+	assert.NotEmpty(t, entries)
+
+	// The logger in the context takes precedence.
+	ctxCore, ctxLogs := observer.New(zapcore.InfoLevel)
+	ctx := context.WithValue(context.Background(), logutil.CtxLogKey, zap.New(ctxCore))
+	store.ctxLogger(ctx).Info("test")
+	assert.Equal(t, 1, ctxLogs.Len())
+	assert.Equal(t, len(entries), logs.Len())
+}
+
+func TestOperationID(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	core, logs := observer.New(zapcore.InfoLevel)
+	store.SetLogger(zap.New(core))
+
+	ctx := util.SetOperationID(context.Background(), "op-1")
+	_, err := store.SplitRegions(ctx, [][]byte{[]byte("b")}, true, nil)
+	assert.Nil(t, err)
+	assert.NotZero(t, logs.Len())
+	assert.Equal(t, logs.Len(), logs.FilterField(zap.String("operationID", "op-1")).Len())
+
+	// A random ID is generated for each call if it's absent.
+	ids := make(map[string]struct{})
+	for i := 0; i < 2; i++ {
+		logs.TakeAll()
+		_, err = store.GC(context.Background(), uint64(100+i))
+		assert.Nil(t, err)
+		entries := logs.All()
+		assert.NotEmpty(t, entries)
+		id := entries[0].ContextMap()["operationID"]
+		assert.NotEmpty(t, id)
+		for _, entry := range entries {
+			assert.Equal(t, id, entry.ContextMap()["operationID"])
+		}
+		ids[id.(string)] = struct{}{}
+	}
+	assert.Len(t, ids, 2)
+
+	// The ID isn't attached twice.
+	ctx = store.withOperationID(ctx)
+	assert.Equal(t, ctx, store.withOperationID(ctx))
+}
@@ -40,6 +40,7 @@ import (
 	"fmt"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingcap/errors"
@@ -58,7 +59,7 @@ import (
 	"go.uber.org/zap"
 )
 
-// ResolvedCacheSize is max number of cached txn status.
+// ResolvedCacheSize is the default max number of cached txn status.
 const ResolvedCacheSize = 2048
 
 // bigTxnThreshold : transaction involves keys exceed this threshold can be treated as `big transaction`.
@@ -66,12 +67,19 @@ const bigTxnThreshold = 16
 
 // LockResolver resolves locks and also caches resolved txn status.
 type LockResolver struct {
+	// The hits and misses of the resolved cache, they're accessed atomically
+	// and kept at the head of the struct to be 64-bit aligned.
+	resolvedCacheHits   uint64
+	resolvedCacheMisses uint64
+
 	store *KVStore
 	mu    struct {
 		sync.RWMutex
 		// resolved caches resolved txns (FIFO, txn id -> txnStatus).
 		resolved       map[uint64]TxnStatus
 		recentResolved *list.List
+		// resolvedCacheSize is max number of cached txn status.
+		resolvedCacheSize int
 	}
 	testingKnobs struct {
 		meetLock func(locks []*Lock)
@@ -84,6 +92,7 @@ func newLockResolver(store *KVStore) *LockResolver {
 	}
 	r.mu.resolved = make(map[uint64]TxnStatus)
 	r.mu.recentResolved = list.New()
+	r.mu.resolvedCacheSize = ResolvedCacheSize
 	return r
 }
 
@@ -221,24 +230,97 @@ func (lr *LockResolver) saveResolved(txnID uint64, status TxnStatus) {
 	}
 	lr.mu.resolved[txnID] = status
 	lr.mu.recentResolved.PushBack(txnID)
-	if len(lr.mu.resolved) > ResolvedCacheSize {
+	lr.evictResolvedLocked()
+}
+
+// evictResolvedLocked evicts the oldest txn status until the cache fits in its capacity.
+// It must be called with lr.mu held.
+func (lr *LockResolver) evictResolvedLocked() {
+	for len(lr.mu.resolved) > lr.mu.resolvedCacheSize {
 		front := lr.mu.recentResolved.Front()
 		delete(lr.mu.resolved, front.Value.(uint64))
 		lr.mu.recentResolved.Remove(front)
 	}
 }
 
+// SetResolvedCacheSize sets the max number of cached txn status, the default is ResolvedCacheSize.
+// Shrink it on memory-constrained deployments, or enlarge it on clusters with heavy GC. The oldest
+// cached txn status are evicted if the cache holds more than the new size. Note that GC scans locks
+// in batches of half the cache size, unless the batch size is set by WithGCScanLockLimit.
+func (lr *LockResolver) SetResolvedCacheSize(size int) error {
+	if size <= 0 {
+		return errors.Errorf("resolved cache size should be positive, got %v", size)
+	}
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.mu.resolvedCacheSize = size
+	lr.evictResolvedLocked()
+	return nil
+}
+
+// getResolvedCacheSize returns the max number of cached txn status.
+func (lr *LockResolver) getResolvedCacheSize() int {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+	return lr.mu.resolvedCacheSize
+}
+
 func (lr *LockResolver) getResolved(txnID uint64) (TxnStatus, bool) {
 	lr.mu.RLock()
 	defer lr.mu.RUnlock()
 
 	s, ok := lr.mu.resolved[txnID]
+	if ok {
+		atomic.AddUint64(&lr.resolvedCacheHits, 1)
+	} else {
+		atomic.AddUint64(&lr.resolvedCacheMisses, 1)
+	}
 	return s, ok
 }
 
+// ResolvedCacheStats is the statistics of the resolved txn status cache.
+type ResolvedCacheStats struct {
+	// Size is the number of txn status in the cache.
+	Size int
+	// Capacity is the max number of txn status the cache holds.
+	Capacity int
+	// Hits is how many times a lookup finds the txn status in the cache.
+	Hits uint64
+	// Misses is how many times a lookup doesn't find the txn status in the cache.
+	Misses uint64
+}
+
+// HitRate returns the ratio of hits to lookups, or 0 if there is no lookup yet.
+func (s ResolvedCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// CacheStats returns the statistics of the resolved txn status cache. It helps to tell
+// whether resolving locks benefits from the cache, or the cache is thrashing.
+func (lr *LockResolver) CacheStats() ResolvedCacheStats {
+	lr.mu.RLock()
+	size, capacity := len(lr.mu.resolved), lr.mu.resolvedCacheSize
+	lr.mu.RUnlock()
+	return ResolvedCacheStats{
+		Size:     size,
+		Capacity: capacity,
+		Hits:     atomic.LoadUint64(&lr.resolvedCacheHits),
+		Misses:   atomic.LoadUint64(&lr.resolvedCacheMisses),
+	}
+}
+
 // BatchResolveLocks resolve locks in a batch.
 // Used it in gcworker only!
 func (lr *LockResolver) BatchResolveLocks(bo *Backoffer, locks []*Lock, loc locate.RegionVerID) (bool, error) {
+	return lr.batchResolveLocks(bo, locks, loc, kvrpcpb.Context{})
+}
+
+// batchResolveLocks is like BatchResolveLocks, the resolve lock request is sent with reqCtx, e.g. to tag it with
+// a priority or resource group.
+func (lr *LockResolver) batchResolveLocks(bo *Backoffer, locks []*Lock, loc locate.RegionVerID, reqCtx kvrpcpb.Context) (bool, error) {
 	if len(locks) == 0 {
 		return true, nil
 	}
@@ -258,7 +340,7 @@ func (lr *LockResolver) BatchResolveLocks(bo *Backoffer, locks []*Lock, loc loca
 		metrics.LockResolverCountWithExpired.Inc()
 
 		// Use currentTS = math.MaxUint64 means rollback the txn, no matter the lock is expired or not!
-		status, err := lr.getTxnStatus(bo, l.TxnID, l.Primary, 0, math.MaxUint64, true, false, l)
+		status, err := lr.getTxnStatus(bo, l.TxnID, l.Primary, 0, math.MaxUint64, true, false, l, reqCtx)
 		if err != nil {
 			return false, err
 		}
@@ -266,13 +348,13 @@ func (lr *LockResolver) BatchResolveLocks(bo *Backoffer, locks []*Lock, loc loca
 		// If the transaction uses async commit, CheckTxnStatus will reject rolling back the primary lock.
 		// Then we need to check the secondary locks to determine the final status of the transaction.
 		if status.primaryLock != nil && status.primaryLock.UseAsyncCommit {
-			resolveData, err := lr.checkAllSecondaries(bo, l, &status)
+			resolveData, err := lr.checkAllSecondaries(bo, l, &status, reqCtx)
 			if err == nil {
 				txnInfos[l.TxnID] = resolveData.commitTs
 				continue
 			}
 			if _, ok := errors.Cause(err).(*nonAsyncCommitLock); ok {
-				status, err = lr.getTxnStatus(bo, l.TxnID, l.Primary, 0, math.MaxUint64, true, true, l)
+				status, err = lr.getTxnStatus(bo, l.TxnID, l.Primary, 0, math.MaxUint64, true, true, l, reqCtx)
 				if err != nil {
 					return false, err
 				}
@@ -300,7 +382,7 @@ func (lr *LockResolver) BatchResolveLocks(bo *Backoffer, locks []*Lock, loc loca
 		})
 	}
 
-	req := tikvrpc.NewRequest(tikvrpc.CmdResolveLock, &kvrpcpb.ResolveLockRequest{TxnInfos: listTxnInfos})
+	req := tikvrpc.NewRequest(tikvrpc.CmdResolveLock, &kvrpcpb.ResolveLockRequest{TxnInfos: listTxnInfos}, reqCtx)
 	startTime = time.Now()
 	resp, err := lr.store.SendReq(bo, req, loc, client.ReadTimeoutShort)
 	if err != nil {
@@ -334,6 +416,36 @@ func (lr *LockResolver) BatchResolveLocks(bo *Backoffer, locks []*Lock, loc loca
 	return true, nil
 }
 
+// BatchResolveLocksInRegion is like BatchResolveLocks, but it only resolves the locks in the given location, and
+// returns the locks remained unresolved, i.e. the locks out of the location, or all the locks if the region has
+// changed. So the caller can retry the remaining locks only, instead of the whole batch.
+// Used it in gcworker only!
+func (lr *LockResolver) BatchResolveLocksInRegion(bo *Backoffer, locks []*Lock, loc *locate.KeyLocation) (remain []*Lock, err error) {
+	return lr.batchResolveLocksInRegion(bo, locks, loc, kvrpcpb.Context{})
+}
+
+func (lr *LockResolver) batchResolveLocksInRegion(bo *Backoffer, locks []*Lock, loc *locate.KeyLocation, reqCtx kvrpcpb.Context) (remain []*Lock, err error) {
+	inRegion := make([]*Lock, 0, len(locks))
+	for _, l := range locks {
+		if loc.Contains(l.Key) {
+			inRegion = append(inRegion, l)
+		} else {
+			remain = append(remain, l)
+		}
+	}
+	if len(inRegion) == 0 {
+		return remain, nil
+	}
+	ok, err := lr.batchResolveLocks(bo, inRegion, loc.Region, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return locks, nil
+	}
+	return remain, nil
+}
+
 // ResolveLocks tries to resolve Locks. The resolving process is in 3 steps:
 // 1) Use the `lockTTL` to pick up all expired locks. Only locks that are too
 //    old are considered orphan locks and will be handled later. If all locks
@@ -394,14 +506,14 @@ func (lr *LockResolver) resolveLocks(bo *Backoffer, callerStartTS uint64, locks
 			}
 
 			if status.primaryLock != nil && !forceSyncCommit && status.primaryLock.UseAsyncCommit && !exists {
-				err = lr.resolveLockAsync(bo, l, status)
+				err = lr.resolveLockAsync(bo, l, status, kvrpcpb.Context{})
 				if _, ok := errors.Cause(err).(*nonAsyncCommitLock); ok {
 					err = resolve(l, true)
 				}
 			} else if l.LockType == kvrpcpb.Op_PessimisticLock {
-				err = lr.resolvePessimisticLock(bo, l, cleanRegions)
+				err = lr.resolvePessimisticLock(bo, l, cleanRegions, kvrpcpb.Context{})
 			} else {
-				err = lr.resolveLock(bo, l, status, lite, cleanRegions)
+				err = lr.resolveLock(bo, l, status, lite, cleanRegions, kvrpcpb.Context{})
 			}
 			if err != nil {
 				return err
@@ -494,7 +606,7 @@ func (lr *LockResolver) GetTxnStatus(txnID uint64, callerStartTS uint64, primary
 	if err != nil {
 		return status, err
 	}
-	return lr.getTxnStatus(bo, txnID, primary, callerStartTS, currentTS, true, false, nil)
+	return lr.getTxnStatus(bo, txnID, primary, callerStartTS, currentTS, true, false, nil, kvrpcpb.Context{})
 }
 
 func (lr *LockResolver) getTxnStatusFromLock(bo *Backoffer, l *Lock, callerStartTS uint64, forceSyncCommit bool) (TxnStatus, error) {
@@ -520,7 +632,7 @@ func (lr *LockResolver) getTxnStatusFromLock(bo *Backoffer, l *Lock, callerStart
 		time.Sleep(100 * time.Millisecond)
 	}
 	for {
-		status, err = lr.getTxnStatus(bo, l.TxnID, l.Primary, callerStartTS, currentTS, rollbackIfNotExist, forceSyncCommit, l)
+		status, err = lr.getTxnStatus(bo, l.TxnID, l.Primary, callerStartTS, currentTS, rollbackIfNotExist, forceSyncCommit, l, kvrpcpb.Context{})
 		if err == nil {
 			return status, nil
 		}
@@ -574,7 +686,7 @@ func (e txnNotFoundErr) Error() string {
 // getTxnStatus sends the CheckTxnStatus request to the TiKV server.
 // When rollbackIfNotExist is false, the caller should be careful with the txnNotFoundErr error.
 func (lr *LockResolver) getTxnStatus(bo *Backoffer, txnID uint64, primary []byte,
-	callerStartTS, currentTS uint64, rollbackIfNotExist bool, forceSyncCommit bool, lockInfo *Lock) (TxnStatus, error) {
+	callerStartTS, currentTS uint64, rollbackIfNotExist bool, forceSyncCommit bool, lockInfo *Lock, reqCtx kvrpcpb.Context) (TxnStatus, error) {
 	if s, ok := lr.getResolved(txnID); ok {
 		return s, nil
 	}
@@ -600,7 +712,7 @@ func (lr *LockResolver) getTxnStatus(bo *Backoffer, txnID uint64, primary []byte
 		RollbackIfNotExist:       rollbackIfNotExist,
 		ForceSyncCommit:          forceSyncCommit,
 		ResolvingPessimisticLock: resolvingPessimisticLock,
-	})
+	}, reqCtx)
 	for {
 		loc, err := lr.store.GetRegionCache().LocateKey(bo, primary)
 		if err != nil {
@@ -735,12 +847,12 @@ func (data *asyncResolveData) addKeys(locks []*kvrpcpb.LockInfo, expected int, s
 	return nil
 }
 
-func (lr *LockResolver) checkSecondaries(bo *Backoffer, txnID uint64, curKeys [][]byte, curRegionID locate.RegionVerID, shared *asyncResolveData) error {
+func (lr *LockResolver) checkSecondaries(bo *Backoffer, txnID uint64, curKeys [][]byte, curRegionID locate.RegionVerID, shared *asyncResolveData, reqCtx kvrpcpb.Context) error {
 	checkReq := &kvrpcpb.CheckSecondaryLocksRequest{
 		Keys:         curKeys,
 		StartVersion: txnID,
 	}
-	req := tikvrpc.NewRequest(tikvrpc.CmdCheckSecondaryLocks, checkReq)
+	req := tikvrpc.NewRequest(tikvrpc.CmdCheckSecondaryLocks, checkReq, reqCtx)
 	metrics.LockResolverCountWithQueryCheckSecondaryLocks.Inc()
 	resp, err := lr.store.SendReq(bo, req, curRegionID, client.ReadTimeoutShort)
 	if err != nil {
@@ -766,7 +878,7 @@ func (lr *LockResolver) checkSecondaries(bo *Backoffer, txnID uint64, curKeys []
 		}
 		for regionID, keys := range regions {
 			// Recursion will terminate because the resolve request succeeds or the Backoffer reaches its limit.
-			if err = lr.checkSecondaries(bo, txnID, keys, regionID, shared); err != nil {
+			if err = lr.checkSecondaries(bo, txnID, keys, regionID, shared, reqCtx); err != nil {
 				return err
 			}
 		}
@@ -781,10 +893,10 @@ func (lr *LockResolver) checkSecondaries(bo *Backoffer, txnID uint64, curKeys []
 }
 
 // resolveLockAsync resolves l assuming it was locked using the async commit protocol.
-func (lr *LockResolver) resolveLockAsync(bo *Backoffer, l *Lock, status TxnStatus) error {
+func (lr *LockResolver) resolveLockAsync(bo *Backoffer, l *Lock, status TxnStatus, reqCtx kvrpcpb.Context) error {
 	metrics.LockResolverCountWithResolveAsync.Inc()
 
-	resolveData, err := lr.checkAllSecondaries(bo, l, &status)
+	resolveData, err := lr.checkAllSecondaries(bo, l, &status, reqCtx)
 	if err != nil {
 		return err
 	}
@@ -805,7 +917,7 @@ func (lr *LockResolver) resolveLockAsync(bo *Backoffer, l *Lock, status TxnStatu
 		curLocks := locks
 		curRegion := region
 		go func() {
-			errChan <- lr.resolveRegionLocks(bo, l, curRegion, curLocks, status)
+			errChan <- lr.resolveRegionLocks(bo, l, curRegion, curLocks, status, reqCtx)
 		}()
 	}
 
@@ -826,7 +938,7 @@ func (lr *LockResolver) resolveLockAsync(bo *Backoffer, l *Lock, status TxnStatu
 
 // checkAllSecondaries checks the secondary locks of an async commit transaction to find out the final
 // status of the transaction
-func (lr *LockResolver) checkAllSecondaries(bo *Backoffer, l *Lock, status *TxnStatus) (*asyncResolveData, error) {
+func (lr *LockResolver) checkAllSecondaries(bo *Backoffer, l *Lock, status *TxnStatus, reqCtx kvrpcpb.Context) (*asyncResolveData, error) {
 	regions, _, err := lr.store.GetRegionCache().GroupKeysByRegion(bo, status.primaryLock.Secondaries, nil)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -847,7 +959,7 @@ func (lr *LockResolver) checkAllSecondaries(bo *Backoffer, l *Lock, status *TxnS
 		curKeys := keys
 
 		go func() {
-			errChan <- lr.checkSecondaries(checkBo, l.TxnID, curKeys, curRegionID, &shared)
+			errChan <- lr.checkSecondaries(checkBo, l.TxnID, curKeys, curRegionID, &shared, reqCtx)
 		}()
 	}
 
@@ -862,7 +974,7 @@ func (lr *LockResolver) checkAllSecondaries(bo *Backoffer, l *Lock, status *TxnS
 }
 
 // resolveRegionLocks is essentially the same as resolveLock, but we resolve all keys in the same region at the same time.
-func (lr *LockResolver) resolveRegionLocks(bo *Backoffer, l *Lock, region locate.RegionVerID, keys [][]byte, status TxnStatus) error {
+func (lr *LockResolver) resolveRegionLocks(bo *Backoffer, l *Lock, region locate.RegionVerID, keys [][]byte, status TxnStatus, reqCtx kvrpcpb.Context) error {
 	lreq := &kvrpcpb.ResolveLockRequest{
 		StartVersion: l.TxnID,
 	}
@@ -870,7 +982,7 @@ func (lr *LockResolver) resolveRegionLocks(bo *Backoffer, l *Lock, region locate
 		lreq.CommitVersion = status.CommitTS()
 	}
 	lreq.Keys = keys
-	req := tikvrpc.NewRequest(tikvrpc.CmdResolveLock, lreq)
+	req := tikvrpc.NewRequest(tikvrpc.CmdResolveLock, lreq, reqCtx)
 
 	resp, err := lr.store.SendReq(bo, req, region, client.ReadTimeoutShort)
 	if err != nil {
@@ -896,7 +1008,7 @@ func (lr *LockResolver) resolveRegionLocks(bo *Backoffer, l *Lock, region locate
 		}
 		for regionID, keys := range regions {
 			// Recursion will terminate because the resolve request succeeds or the Backoffer reaches its limit.
-			if err = lr.resolveRegionLocks(bo, l, regionID, keys, status); err != nil {
+			if err = lr.resolveRegionLocks(bo, l, regionID, keys, status, reqCtx); err != nil {
 				return err
 			}
 		}
@@ -914,7 +1026,7 @@ func (lr *LockResolver) resolveRegionLocks(bo *Backoffer, l *Lock, region locate
 	return nil
 }
 
-func (lr *LockResolver) resolveLock(bo *Backoffer, l *Lock, status TxnStatus, lite bool, cleanRegions map[locate.RegionVerID]struct{}) error {
+func (lr *LockResolver) resolveLock(bo *Backoffer, l *Lock, status TxnStatus, lite bool, cleanRegions map[locate.RegionVerID]struct{}, reqCtx kvrpcpb.Context) error {
 	metrics.LockResolverCountWithResolveLocks.Inc()
 	resolveLite := lite || l.TxnSize < bigTxnThreshold
 	for {
@@ -940,7 +1052,7 @@ func (lr *LockResolver) resolveLock(bo *Backoffer, l *Lock, status TxnStatus, li
 			metrics.LockResolverCountWithResolveLockLite.Inc()
 			lreq.Keys = [][]byte{l.Key}
 		}
-		req := tikvrpc.NewRequest(tikvrpc.CmdResolveLock, lreq)
+		req := tikvrpc.NewRequest(tikvrpc.CmdResolveLock, lreq, reqCtx)
 		resp, err := lr.store.SendReq(bo, req, loc.Region, client.ReadTimeoutShort)
 		if err != nil {
 			return errors.Trace(err)
@@ -972,7 +1084,7 @@ func (lr *LockResolver) resolveLock(bo *Backoffer, l *Lock, status TxnStatus, li
 	}
 }
 
-func (lr *LockResolver) resolvePessimisticLock(bo *Backoffer, l *Lock, cleanRegions map[locate.RegionVerID]struct{}) error {
+func (lr *LockResolver) resolvePessimisticLock(bo *Backoffer, l *Lock, cleanRegions map[locate.RegionVerID]struct{}, reqCtx kvrpcpb.Context) error {
 	metrics.LockResolverCountWithResolveLocks.Inc()
 	for {
 		loc, err := lr.store.GetRegionCache().LocateKey(bo, l.Key)
@@ -991,7 +1103,7 @@ func (lr *LockResolver) resolvePessimisticLock(bo *Backoffer, l *Lock, cleanRegi
 			ForUpdateTs:  forUpdateTS,
 			Keys:         [][]byte{l.Key},
 		}
-		req := tikvrpc.NewRequest(tikvrpc.CmdPessimisticRollback, pessimisticRollbackReq)
+		req := tikvrpc.NewRequest(tikvrpc.CmdPessimisticRollback, pessimisticRollbackReq, reqCtx)
 		resp, err := lr.store.SendReq(bo, req, loc.Region, client.ReadTimeoutShort)
 		if err != nil {
 			return errors.Trace(err)
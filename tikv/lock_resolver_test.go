@@ -0,0 +1,63 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolvedCacheStats(t *testing.T) {
+	lr := newLockResolver(nil)
+	stats := lr.CacheStats()
+	assert.Equal(t, 0, stats.Size)
+	assert.Equal(t, ResolvedCacheSize, stats.Capacity)
+	assert.Equal(t, float64(0), stats.HitRate())
+
+	lr.saveResolved(1, TxnStatus{commitTS: 2})
+	_, ok := lr.getResolved(1)
+	assert.True(t, ok)
+	_, ok = lr.getResolved(2)
+	assert.False(t, ok)
+
+	stats = lr.CacheStats()
+	assert.Equal(t, 1, stats.Size)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 0.5, stats.HitRate())
+}
+
+func TestSetResolvedCacheSize(t *testing.T) {
+	lr := newLockResolver(nil)
+	assert.NotNil(t, lr.SetResolvedCacheSize(0))
+	assert.NotNil(t, lr.SetResolvedCacheSize(-1))
+	assert.Equal(t, ResolvedCacheSize, lr.CacheStats().Capacity)
+
+	for i := uint64(1); i <= 4; i++ {
+		lr.saveResolved(i, TxnStatus{commitTS: i + 1})
+	}
+	assert.Nil(t, lr.SetResolvedCacheSize(2))
+	stats := lr.CacheStats()
+	assert.Equal(t, 2, stats.Size)
+	assert.Equal(t, 2, stats.Capacity)
+	// The oldest txn status are evicted.
+	_, ok := lr.getResolved(2)
+	assert.False(t, ok)
+	_, ok = lr.getResolved(4)
+	assert.True(t, ok)
+
+	lr.saveResolved(5, TxnStatus{commitTS: 6})
+	assert.Equal(t, 2, lr.CacheStats().Size)
+}
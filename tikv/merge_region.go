@@ -0,0 +1,96 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/retry"
+	"go.uber.org/zap"
+)
+
+// MergeRegions asks PD to merge the adjacent regions, e.g. the tiny regions left by a bulk deletion, which PD may
+// take long to merge by itself. The regions should be adjacent in the given order. Since a region can be in only
+// one merge at a time, each odd-positioned region is merged into the region before it, which halves the regions,
+// call it again after the merges finish to merge further.
+// The gRPC API of PD of this version can't create operators, so the merges are requested by the HTTP API of the
+// PD leader. It returns after PD accepts the merge operators, which may still fail, e.g. a region is split again
+// meanwhile, use GetScatterStatus to check the operators on the regions.
+func (s *KVStore) MergeRegions(ctx context.Context, regionIDs []uint64) (err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() { err = tikverr.WithRegionOperation(err, "MergeRegions", regionIDs...) }()
+	if len(regionIDs) < 2 {
+		return nil
+	}
+	regions := make([]*metapb.Region, 0, len(regionIDs))
+	for _, regionID := range regionIDs {
+		region, err := s.loadRegionMeta(ctx, regionID)
+		if err != nil {
+			return err
+		}
+		if len(regions) > 0 && !bytes.Equal(regions[len(regions)-1].GetEndKey(), region.GetStartKey()) {
+			return errors.Errorf("region %d and region %d are not adjacent", regions[len(regions)-1].GetId(), regionID)
+		}
+		regions = append(regions, region)
+	}
+	for i := 1; i < len(regions); i += 2 {
+		if err := s.createMergeOperator(ctx, regions[i].GetId(), regions[i-1].GetId()); err != nil {
+			return err
+		}
+		s.ctxLogger(ctx).Info("merge region requested",
+			zap.Uint64("source region ID", regions[i].GetId()),
+			zap.Uint64("target region ID", regions[i-1].GetId()))
+	}
+	return nil
+}
+
+// loadRegionMeta loads the region from PD, it retries on PD errors.
+func (s *KVStore) loadRegionMeta(ctx context.Context, regionID uint64) (*metapb.Region, error) {
+	bo := retry.NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+	for {
+		region, err := s.splitGCPDClient.GetRegionByID(ctx, regionID)
+		if err == nil {
+			if region == nil || region.Meta == nil {
+				return nil, errors.Errorf("region not found for regionID %d", regionID)
+			}
+			return region.Meta, nil
+		}
+		if err = bo.Backoff(retry.BoPDRPC, errors.New(err.Error())); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+}
+
+// createMergeOperator creates an operator on PD to merge the source region into the target region.
+func (s *KVStore) createMergeOperator(ctx context.Context, sourceID, targetID uint64) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"name":             "merge-region",
+		"source_region_id": sourceID,
+		"target_region_id": targetID,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = s.pdHTTPRequest(ctx, http.MethodPost, pdOperatorsAPI, body); err != nil {
+		return errors.Annotatef(err, "merge region %d into region %d", sourceID, targetID)
+	}
+	return nil
+}
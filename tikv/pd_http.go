@@ -0,0 +1,86 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/config"
+)
+
+// The HTTP APIs of PD used by the region management methods which the gRPC API of PD of this version lacks.
+const (
+	// pdOperatorsAPI is the path of the HTTP API of PD to create operators.
+	pdOperatorsAPI = "/pd/api/v1/operators"
+	// pdRegionByIDAPI is the path prefix of the HTTP API of PD to get a region with its statistics.
+	pdRegionByIDAPI = "/pd/api/v1/region/id/"
+)
+
+// pdHTTPRequest sends a request with the body to the HTTP API of the PD leader, and returns the body of the
+// response. A nil body sends no body.
+func (s *KVStore) pdHTTPRequest(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	addr := s.splitGCPDClient.GetLeaderAddr()
+	if !strings.Contains(addr, "://") {
+		addr = config.InternalHTTPSchema() + "://" + addr
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, addr+path, reader)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := config.InternalHTTPClient().Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("request %s %s to pd failed: %s, %s", method, path, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+// getRegionApproximateSize returns the approximate size(in MiB) of the region reported by PD.
+func (s *KVStore) getRegionApproximateSize(ctx context.Context, regionID uint64) (uint64, error) {
+	body, err := s.pdHTTPRequest(ctx, http.MethodGet, fmt.Sprintf("%s%d", pdRegionByIDAPI, regionID), nil)
+	if err != nil {
+		return 0, err
+	}
+	var region struct {
+		ApproximateSize int64 `json:"approximate_size"`
+	}
+	if err = json.Unmarshal(body, &region); err != nil {
+		return 0, errors.Trace(err)
+	}
+	if region.ApproximateSize < 0 {
+		return 0, nil
+	}
+	return uint64(region.ApproximateSize), nil
+}
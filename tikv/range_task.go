@@ -39,6 +39,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pingcap/errors"
 	"github.com/tikv/client-go/v2/kv"
 	"github.com/tikv/client-go/v2/logutil"
@@ -65,15 +66,24 @@ type RangeTaskRunner struct {
 	handler         RangeTaskHandler
 	statLogInterval time.Duration
 	regionsPerTask  int
+	// lastRunID holds the ID of the latest run as a string, it's stored by each RunOnRange.
+	lastRunID atomic.Value
 
 	completedRegions int32
 	failedRegions    int32
+	pessimisticLocks int32
 }
 
 // RangeTaskStat is used to count Regions that completed or failed to do the task.
 type RangeTaskStat struct {
 	CompletedRegions int
 	FailedRegions    int
+	// PessimisticLocks is the number of pessimistic locks encountered, it's only counted by resolving locks in GC.
+	PessimisticLocks int
+	// RunnerName and RunID identify the run of the RangeTaskRunner, which are attached to its logs as "name" and
+	// "runID". They're set in the stats of a whole run rather than of a task.
+	RunnerName string
+	RunID      string
 }
 
 // RangeTaskHandler is the type of functions that processes a task of a key range.
@@ -115,20 +125,32 @@ const locateRegionMaxBackoff = 20000
 
 // RunOnRange runs the task on the given range.
 // Empty startKey or endKey means unbounded.
+// Each run gets a unique ID, which is attached to the logs of the run as "runID" along with the runner name as
+// "name", including the logs of the handler written by the logger in its context, so the logs of concurrent runs
+// can be told apart.
 func (s *RangeTaskRunner) RunOnRange(ctx context.Context, startKey, endKey []byte) error {
 	s.completedRegions = 0
+	s.pessimisticLocks = 0
+	runID := uuid.New().String()
+	s.lastRunID.Store(runID)
+	ctx = context.WithValue(ctx, logutil.CtxLogKey, logutil.Logger(ctx).With(
+		zap.String("name", s.name),
+		zap.String("runID", runID)))
 	metrics.TiKVRangeTaskStats.WithLabelValues(s.name, lblCompletedRegions).Set(0)
 
-	if len(endKey) != 0 && bytes.Compare(startKey, endKey) >= 0 {
+	if len(endKey) != 0 && bytes.Compare(startKey, endKey) > 0 {
+		// An inverted range is most likely a bug of the caller, running nothing on it silently may hide the bug.
+		return errors.Errorf("range task %s on an inverted range, startKey: %s, endKey: %s",
+			s.name, kv.StrKey(startKey), kv.StrKey(endKey))
+	}
+	if len(endKey) != 0 && bytes.Equal(startKey, endKey) {
 		logutil.Logger(ctx).Info("empty range task executed. ignored",
-			zap.String("name", s.name),
 			zap.String("startKey", kv.StrKey(startKey)),
 			zap.String("endKey", kv.StrKey(endKey)))
 		return nil
 	}
 
 	logutil.Logger(ctx).Info("range task started",
-		zap.String("name", s.name),
 		zap.String("startKey", kv.StrKey(startKey)),
 		zap.String("endKey", kv.StrKey(endKey)),
 		zap.Int("concurrency", s.concurrency))
@@ -170,7 +192,6 @@ Loop:
 		select {
 		case <-statLogTicker.C:
 			logutil.Logger(ctx).Info("range task in progress",
-				zap.String("name", s.name),
 				zap.String("startKey", kv.StrKey(startKey)),
 				zap.String("endKey", kv.StrKey(endKey)),
 				zap.Int("concurrency", s.concurrency),
@@ -184,7 +205,6 @@ Loop:
 		rangeEndKey, err := s.store.GetRegionCache().BatchLoadRegionsFromKey(bo, key, s.regionsPerTask)
 		if err != nil {
 			logutil.Logger(ctx).Info("range task failed",
-				zap.String("name", s.name),
 				zap.String("startKey", kv.StrKey(startKey)),
 				zap.String("endKey", kv.StrKey(endKey)),
 				zap.Duration("cost time", time.Since(startTime)),
@@ -224,7 +244,6 @@ Loop:
 	for _, w := range workers {
 		if w.err != nil {
 			logutil.Logger(ctx).Info("range task failed",
-				zap.String("name", s.name),
 				zap.String("startKey", kv.StrKey(startKey)),
 				zap.String("endKey", kv.StrKey(endKey)),
 				zap.Duration("cost time", time.Since(startTime)),
@@ -234,7 +253,6 @@ Loop:
 	}
 
 	logutil.Logger(ctx).Info("range task finished",
-		zap.String("name", s.name),
 		zap.String("startKey", kv.StrKey(startKey)),
 		zap.String("endKey", kv.StrKey(endKey)),
 		zap.Duration("cost time", time.Since(startTime)),
@@ -254,6 +272,30 @@ func (s *RangeTaskRunner) createWorker(taskCh chan *kv.KeyRange, wg *sync.WaitGr
 
 		completedRegions: &s.completedRegions,
 		failedRegions:    &s.failedRegions,
+		pessimisticLocks: &s.pessimisticLocks,
+	}
+}
+
+// Name returns the name of the runner, which labels its metrics.
+func (s *RangeTaskRunner) Name() string {
+	return s.name
+}
+
+// RunID returns the ID of the latest run, it's empty before the first run. It's set once the run starts, so it
+// can be read while the run is in progress.
+func (s *RangeTaskRunner) RunID() string {
+	runID, _ := s.lastRunID.Load().(string)
+	return runID
+}
+
+// Stat returns the stats of the runner, along with its name and the ID of the latest run.
+func (s *RangeTaskRunner) Stat() RangeTaskStat {
+	return RangeTaskStat{
+		CompletedRegions: s.CompletedRegions(),
+		FailedRegions:    s.FailedRegions(),
+		PessimisticLocks: s.PessimisticLocks(),
+		RunnerName:       s.name,
+		RunID:            s.RunID(),
 	}
 }
 
@@ -267,6 +309,11 @@ func (s *RangeTaskRunner) FailedRegions() int {
 	return int(atomic.LoadInt32(&s.failedRegions))
 }
 
+// PessimisticLocks returns how many pessimistic locks has been encountered by the task.
+func (s *RangeTaskRunner) PessimisticLocks() int {
+	return int(atomic.LoadInt32(&s.pessimisticLocks))
+}
+
 // rangeTaskWorker is used by RangeTaskRunner to process tasks concurrently.
 type rangeTaskWorker struct {
 	name    string
@@ -279,6 +326,7 @@ type rangeTaskWorker struct {
 
 	completedRegions *int32
 	failedRegions    *int32
+	pessimisticLocks *int32
 }
 
 // run starts the worker. It collects all objects from `w.taskCh` and process them one by one.
@@ -296,12 +344,12 @@ func (w *rangeTaskWorker) run(ctx context.Context, cancel context.CancelFunc) {
 
 		atomic.AddInt32(w.completedRegions, int32(stat.CompletedRegions))
 		atomic.AddInt32(w.failedRegions, int32(stat.FailedRegions))
+		atomic.AddInt32(w.pessimisticLocks, int32(stat.PessimisticLocks))
 		metrics.TiKVRangeTaskStats.WithLabelValues(w.name, lblCompletedRegions).Add(float64(stat.CompletedRegions))
 		metrics.TiKVRangeTaskStats.WithLabelValues(w.name, lblFailedRegions).Add(float64(stat.FailedRegions))
 
 		if err != nil {
 			logutil.Logger(ctx).Info("canceling range task because of error",
-				zap.String("name", w.name),
 				zap.String("startKey", kv.StrKey(r.StartKey)),
 				zap.String("endKey", kv.StrKey(r.EndKey)),
 				zap.Error(err))
@@ -588,23 +588,50 @@ func (c *RawKVClient) sendBatchPut(bo *Backoffer, keys, values [][]byte) error {
 	return errors.Trace(err)
 }
 
-func appendKeyBatches(batches []batch, regionID locate.RegionVerID, groupKeys [][]byte, limit int) []batch {
-	var keys [][]byte
-	for start, count := 0, 0; start < len(groupKeys); start++ {
-		if count > limit {
-			batches = append(batches, batch{regionID: regionID, keys: keys})
-			keys = make([][]byte, 0, limit)
-			count = 0
-		}
-		keys = append(keys, groupKeys[start])
-		count++
+// KeyBatch is a batch of keys that belong to the same region.
+type KeyBatch struct {
+	RegionID RegionVerID
+	Keys     [][]byte
+}
+
+// ChunkKeysByRegion splits the keys of each region into batches, keeping the keys of a batch in their original
+// order. It batches the keys exactly like SplitRegions and the raw batch requests do: a batch is cut only after
+// more than limit keys are collected, so a batch holds at most limit+1 keys, and a negative limit puts all keys of
+// a region into a single batch. Regions without keys produce no batch. The groups are usually the result of
+// GroupKeysByRegion. The batches of a region are adjacent and ordered, but the order among regions follows the
+// map iteration order.
+func ChunkKeysByRegion(groups map[RegionVerID][][]byte, limit int) []KeyBatch {
+	var batches []KeyBatch
+	for regionID, groupKeys := range groups {
+		chunkKeys(groupKeys, limit+1, func(keys [][]byte) {
+			batches = append(batches, KeyBatch{RegionID: regionID, Keys: keys})
+		})
 	}
-	if len(keys) != 0 {
+	return batches
+}
+
+func appendKeyBatches(batches []batch, regionID locate.RegionVerID, groupKeys [][]byte, limit int) []batch {
+	// A batch is cut only after more than limit keys are collected, so it holds limit+1 keys.
+	chunkKeys(groupKeys, limit+1, func(keys [][]byte) {
 		batches = append(batches, batch{regionID: regionID, keys: keys})
-	}
+	})
 	return batches
 }
 
+// chunkKeys calls fn with consecutive chunks of at most limit keys.
+func chunkKeys(keys [][]byte, limit int, fn func(keys [][]byte)) {
+	if limit <= 0 {
+		limit = len(keys)
+	}
+	for start := 0; start < len(keys); start += limit {
+		end := start + limit
+		if end > len(keys) {
+			end = len(keys)
+		}
+		fn(keys[start:end:end])
+	}
+}
+
 func appendBatches(batches []batch, regionID locate.RegionVerID, groupKeys [][]byte, keyToValue map[string][]byte, limit int) []batch {
 	var start, size int
 	var keys, values [][]byte
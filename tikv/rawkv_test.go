@@ -35,18 +35,91 @@ package tikv
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/client-go/v2/kv"
 	"github.com/tikv/client-go/v2/mockstore/mocktikv"
 	"github.com/tikv/client-go/v2/retry"
+	"github.com/tikv/client-go/v2/tikvrpc"
 )
 
 func TestRawKV(t *testing.T) {
 	suite.Run(t, new(testRawkvSuite))
 }
 
+func TestChunkKeysByRegion(t *testing.T) {
+	keys := func(n int) [][]byte {
+		ks := make([][]byte, 0, n)
+		for i := 0; i < n; i++ {
+			ks = append(ks, []byte(fmt.Sprintf("k%02d", i)))
+		}
+		return ks
+	}
+	sizes := func(batches []KeyBatch) []int {
+		var res []int
+		for _, b := range batches {
+			res = append(res, len(b.Keys))
+		}
+		return res
+	}
+	region := RegionVerID{}
+
+	// Fewer keys than the limit.
+	batches := ChunkKeysByRegion(map[RegionVerID][][]byte{region: keys(3)}, 4)
+	require.Equal(t, []int{3}, sizes(batches))
+	require.Equal(t, keys(3), batches[0].Keys)
+
+	// A batch is cut only after more than limit keys are collected, so it holds limit+1 keys.
+	batches = ChunkKeysByRegion(map[RegionVerID][][]byte{region: keys(5)}, 4)
+	require.Equal(t, []int{5}, sizes(batches))
+
+	// Exact multiples of limit+1.
+	batches = ChunkKeysByRegion(map[RegionVerID][][]byte{region: keys(10)}, 4)
+	require.Equal(t, []int{5, 5}, sizes(batches))
+	require.Equal(t, keys(10)[:5], batches[0].Keys)
+	require.Equal(t, keys(10)[5:], batches[1].Keys)
+
+	// A remainder goes into the last batch.
+	batches = ChunkKeysByRegion(map[RegionVerID][][]byte{region: keys(11)}, 4)
+	require.Equal(t, []int{5, 5, 1}, sizes(batches))
+
+	// Exact multiples of the limit aren't batch boundaries.
+	batches = ChunkKeysByRegion(map[RegionVerID][][]byte{region: keys(8)}, 4)
+	require.Equal(t, []int{5, 3}, sizes(batches))
+
+	// A zero limit puts each key into its own batch.
+	batches = ChunkKeysByRegion(map[RegionVerID][][]byte{region: keys(3)}, 0)
+	require.Equal(t, []int{1, 1, 1}, sizes(batches))
+
+	// Empty groups produce no batch.
+	require.Empty(t, ChunkKeysByRegion(nil, 4))
+	require.Empty(t, ChunkKeysByRegion(map[RegionVerID][][]byte{region: nil}, 4))
+
+	// No limit.
+	batches = ChunkKeysByRegion(map[RegionVerID][][]byte{region: keys(9)}, -1)
+	require.Equal(t, []int{9}, sizes(batches))
+
+	// Batches don't share the backing array, appending to one doesn't overwrite the next one.
+	batches = ChunkKeysByRegion(map[RegionVerID][][]byte{region: keys(10)}, 4)
+	_ = append(batches[0].Keys, []byte("x"))
+	require.Equal(t, keys(10)[5:], batches[1].Keys)
+
+	// Several regions.
+	other := NewRegionVerID(2, 1, 1)
+	batches = ChunkKeysByRegion(map[RegionVerID][][]byte{region: keys(6), other: keys(2)}, 4)
+	counts := make(map[RegionVerID]int)
+	for _, b := range batches {
+		counts[b.RegionID] += len(b.Keys)
+	}
+	require.Len(t, batches, 3)
+	require.Equal(t, map[RegionVerID]int{region: 6, other: 2}, counts)
+}
+
 type testRawkvSuite struct {
 	suite.Suite
 	mvccStore mocktikv.MVCCStore
@@ -79,6 +152,47 @@ func (s *testRawkvSuite) storeAddr(id uint64) string {
 	return fmt.Sprintf("store%d", id)
 }
 
+// batchKeysRecordClient records the number of keys of each raw batch get request sent through it.
+type batchKeysRecordClient struct {
+	Client
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (c *batchKeysRecordClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdRawBatchGet {
+		c.mu.Lock()
+		c.sizes = append(c.sizes, len(req.RawBatchGet().GetKeys()))
+		c.mu.Unlock()
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func (s *testRawkvSuite) TestBatchGetBatchSize() {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+
+	rpcClient := &batchKeysRecordClient{Client: mocktikv.NewRPCClient(s.cluster, mvccStore, nil)}
+	client := &RawKVClient{
+		clusterID:   0,
+		regionCache: NewRegionCache(mocktikv.NewPDClient(s.cluster)),
+		rpcClient:   rpcClient,
+	}
+	defer client.Close()
+
+	keys := make([][]byte, 0, 2*rawBatchPairCount+2)
+	for i := 0; i < cap(keys); i++ {
+		keys = append(keys, []byte(fmt.Sprintf("k%04d", i)))
+	}
+	_, err := client.BatchGet(keys)
+	s.Nil(err)
+	rpcClient.mu.Lock()
+	defer rpcClient.mu.Unlock()
+	sort.Ints(rpcClient.sizes)
+	// Like the split batches, a batch holds rawBatchPairCount+1 keys.
+	s.Equal([]int{rawBatchPairCount + 1, rawBatchPairCount + 1}, rpcClient.sizes)
+}
+
 func (s *testRawkvSuite) TestReplaceAddrWithNewStore() {
 	mvccStore := mocktikv.MustNewMVCCStore()
 	defer mvccStore.Close()
@@ -37,8 +37,14 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
@@ -48,6 +54,7 @@ import (
 	"github.com/tikv/client-go/v2/internal/locate"
 	"github.com/tikv/client-go/v2/kv"
 	"github.com/tikv/client-go/v2/logutil"
+	"github.com/tikv/client-go/v2/metrics"
 	"github.com/tikv/client-go/v2/retry"
 	"github.com/tikv/client-go/v2/tikvrpc"
 	"github.com/tikv/client-go/v2/util"
@@ -55,81 +62,685 @@ import (
 	"go.uber.org/zap"
 )
 
-const splitBatchRegionLimit = 16
+// The limits of splitting and scattering regions. They're accessed atomically and can be tuned at runtime by
+// the setters below, the backoff values are in milliseconds.
+var (
+	splitBatchRegionLimit          int64 = 16
+	splitRegionBackoff             int64 = 20000
+	maxSplitRegionsBackoff         int64 = 120000
+	waitScatterRegionFinishBackoff int64 = 120000
+)
+
+// SetSplitBatchRegionLimit sets the batch limit of the split keys sent to a region, a split request carries at
+// most limit+1 keys as ChunkKeysByRegion batches them. The default is 16.
+func SetSplitBatchRegionLimit(limit int) error {
+	if limit <= 0 {
+		return errors.Errorf("split batch region limit should be positive, got %v", limit)
+	}
+	atomic.StoreInt64(&splitBatchRegionLimit, int64(limit))
+	return nil
+}
+
+// GetSplitBatchRegionLimit returns the batch limit of the split keys sent to a region.
+func GetSplitBatchRegionLimit() int {
+	return int(atomic.LoadInt64(&splitBatchRegionLimit))
+}
+
+// SetSplitRegionBackoff sets the backoff budget of SplitRegions for each split key, the total budget is capped
+// by the max split regions backoff. The default is 20 seconds.
+func SetSplitRegionBackoff(backoff time.Duration) error {
+	return setBackoffVar(&splitRegionBackoff, "split region backoff", backoff)
+}
+
+// GetSplitRegionBackoff returns the backoff budget of SplitRegions for each split key.
+func GetSplitRegionBackoff() time.Duration {
+	return time.Duration(atomic.LoadInt64(&splitRegionBackoff)) * time.Millisecond
+}
+
+// SetMaxSplitRegionsBackoff sets the max backoff budget of a SplitRegions call. The default is 120 seconds.
+func SetMaxSplitRegionsBackoff(backoff time.Duration) error {
+	return setBackoffVar(&maxSplitRegionsBackoff, "max split regions backoff", backoff)
+}
+
+// GetMaxSplitRegionsBackoff returns the max backoff budget of a SplitRegions call.
+func GetMaxSplitRegionsBackoff() time.Duration {
+	return time.Duration(atomic.LoadInt64(&maxSplitRegionsBackoff)) * time.Millisecond
+}
+
+// SetWaitScatterRegionFinishBackoff sets the default backoff budget of WaitScatterRegionFinish, it's used
+// if the caller doesn't specify one. The default is 120 seconds.
+func SetWaitScatterRegionFinishBackoff(backoff time.Duration) error {
+	return setBackoffVar(&waitScatterRegionFinishBackoff, "wait scatter region finish backoff", backoff)
+}
+
+// GetWaitScatterRegionFinishBackoff returns the default backoff budget of WaitScatterRegionFinish.
+func GetWaitScatterRegionFinishBackoff() time.Duration {
+	return time.Duration(atomic.LoadInt64(&waitScatterRegionFinishBackoff)) * time.Millisecond
+}
+
+func setBackoffVar(v *int64, name string, backoff time.Duration) error {
+	ms := int64(backoff / time.Millisecond)
+	if ms <= 0 || ms > math.MaxInt32 {
+		return errors.Errorf("%s should be in [1ms, %v], got %v", name, time.Duration(math.MaxInt32)*time.Millisecond, backoff)
+	}
+	atomic.StoreInt64(v, ms)
+	return nil
+}
+
+// SplitOption configures the behavior of SplitRegionsWithOptions.
+type SplitOption func(*splitOptions)
+
+type splitOptions struct {
+	// scatterBackoff is the backoff config used to retry scatter requests sent to PD.
+	scatterBackoff *retry.Config
+	// reqTimeout is the timeout of each split region request sent to TiKV.
+	reqTimeout time.Duration
+	// concurrency is the max number of batches sent concurrently.
+	concurrency int
+	// multiError indicates whether to return the errors of all failed batches.
+	multiError bool
+	// scatter is the options passed to PD when scattering the new regions.
+	scatter ScatterOptions
+	// panicStack indicates whether to capture the stack trace in the error if a batch panics.
+	panicStack bool
+	// keyNormalizer rounds the split keys to valid boundaries before grouping them, nil means identity.
+	keyNormalizer func([]byte) []byte
+	// backoffStats receives the backoff statistics of the batches if it's not nil.
+	backoffStats *SplitBackoffStats
+	// backoffRecorder aggregates the backoff of the batches for backoffStats, it's nil if backoffStats is nil.
+	backoffRecorder *splitBackoffRecorder
+	// minHealthyStores is the min number of healthy stores required to scatter the new regions, 0 means no check.
+	minHealthyStores int
+	// alreadySplitKeys receives the split keys equal to region start keys if it's not nil.
+	alreadySplitKeys *[][]byte
+	// alreadySplitRecorder collects the keys for alreadySplitKeys, it's nil if alreadySplitKeys is nil.
+	alreadySplitRecorder *splitKeyRecorder
+	// existingRegionIDs receives the IDs of the regions starting at the split keys if it's not nil, the region
+	// layout is refreshed from PD before splitting to find them out.
+	existingRegionIDs *[]uint64
+	// leaderWaitBackoff is the max time(in ms) SplitRegionsWithLeaders waits for the new regions to be scattered
+	// before resolving their leaders, 0 means not waiting.
+	leaderWaitBackoff int
+	// preSplitRegionCount is the number of regions PreSplitByKeys splits the range of the sampled keys into.
+	preSplitRegionCount int
+	// shouldScatter decides whether to scatter each new region, nil means scattering all of them.
+	shouldScatter func(*metapb.Region) bool
+	// reqCtx is the fields set on the context of the split region requests.
+	reqCtx SplitRequestContext
+	// preGrouped is the split keys grouped by region, which are split instead of grouping the split keys.
+	preGrouped map[RegionVerID][][]byte
+	// unsplitKeys receives the split keys which aren't region boundaries after splitting if it's not nil.
+	unsplitKeys *[][]byte
+	// resultSink receives the result of each split batch as it completes if it's not nil, and the new regions
+	// aren't accumulated then.
+	resultSink func(ctx context.Context, result SplitResult)
+}
+
+// SplitResult is the result of a split batch delivered by SplitRegionsStream.
+type SplitResult struct {
+	// BatchRegionID is the ID of the region split by the batch.
+	BatchRegionID uint64
+	// Regions is the new regions split by the batch, excluding the last one, which keeps the ID of the batch
+	// region, like the regions returned by SplitRegions.
+	Regions []*metapb.Region
+	// Err is the error of scattering the new regions, the regions are split even if it's not nil.
+	Err error
+}
+
+// SplitRequestContext is the fields set on the context of the split region requests sent to TiKV, so the splits
+// can be accounted and observed on the TiKV side like the other requests.
+type SplitRequestContext struct {
+	// Priority is the priority of the requests, the default is PriorityNormal.
+	Priority Priority
+	// ResourceGroupTag is the resource group tag of the requests, nil means no tag.
+	ResourceGroupTag []byte
+}
+
+// toPB returns the context of the split region requests.
+func (c SplitRequestContext) toPB() kvrpcpb.Context {
+	return kvrpcpb.Context{
+		Priority:         c.Priority.ToPB(),
+		ResourceGroupTag: c.ResourceGroupTag,
+	}
+}
+
+// splitKeyRecorder collects the split keys concurrently.
+type splitKeyRecorder struct {
+	mu   sync.Mutex
+	keys [][]byte
+}
+
+func (r *splitKeyRecorder) record(key []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, key)
+}
+
+func (r *splitKeyRecorder) get() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.keys
+}
+
+// SplitBackoffStats is the backoff statistics of the split batches sent by SplitRegions. The backoff of a batch
+// includes retrying the split and scattering the new regions, the batches resent due to region errors are counted
+// separately.
+type SplitBackoffStats struct {
+	// Batches is the number of the batches sent.
+	Batches int
+	// TotalBackoff is the total backoff time of all batches.
+	TotalBackoff time.Duration
+	// MaxBatchBackoff is the max backoff time of a batch.
+	MaxBatchBackoff time.Duration
+}
+
+// splitBackoffRecorder aggregates the backoff of the batches sent concurrently.
+type splitBackoffRecorder struct {
+	mu    sync.Mutex
+	stats SplitBackoffStats
+}
+
+func (r *splitBackoffRecorder) record(sleepMs int) {
+	backoff := time.Duration(sleepMs) * time.Millisecond
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Batches++
+	r.stats.TotalBackoff += backoff
+	if backoff > r.stats.MaxBatchBackoff {
+		r.stats.MaxBatchBackoff = backoff
+	}
+}
+
+func (r *splitBackoffRecorder) get() SplitBackoffStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// ScatterOptions configures how PD scatters the new regions.
+type ScatterOptions struct {
+	// Group is the scatter group of the regions, PD scatters the regions in the same group evenly.
+	// If it's empty, the table ID passed to SplitRegions is used as the group.
+	Group string
+	// RetryLimit is the max number of times PD retries scattering a region. Zero means PD's default.
+	RetryLimit uint64
+}
+
+func (o *ScatterOptions) toRegionsOptions(tableID *int64) []pd.RegionsOption {
+	opts := make([]pd.RegionsOption, 0, 2)
+	if o.Group != "" {
+		opts = append(opts, pd.WithGroup(o.Group))
+	} else if tableID != nil {
+		opts = append(opts, pd.WithGroup(fmt.Sprintf("%v", *tableID)))
+	}
+	if o.RetryLimit > 0 {
+		opts = append(opts, pd.WithRetry(o.RetryLimit))
+	}
+	return opts
+}
+
+func newSplitOptions(opts []SplitOption) *splitOptions {
+	o := &splitOptions{
+		scatterBackoff:      retry.BoPDRPC,
+		reqTimeout:          client.ReadTimeoutShort,
+		concurrency:         runtime.GOMAXPROCS(0) * 2,
+		preSplitRegionCount: defaultPreSplitRegionCount,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.backoffStats != nil {
+		o.backoffRecorder = &splitBackoffRecorder{}
+	}
+	if o.alreadySplitKeys != nil {
+		o.alreadySplitRecorder = &splitKeyRecorder{}
+	}
+	return o
+}
+
+func (o *splitOptions) validate() error {
+	if o.minHealthyStores < 0 {
+		return errors.Errorf("min healthy stores should not be negative, got %v", o.minHealthyStores)
+	}
+	if o.reqTimeout <= 0 {
+		return errors.Errorf("split region request timeout should be positive, got %v", o.reqTimeout)
+	}
+	if o.concurrency <= 0 {
+		return errors.Errorf("split region concurrency should be positive, got %d", o.concurrency)
+	}
+	if o.leaderWaitBackoff < 0 {
+		return errors.Errorf("split leader wait should not be negative, got %dms", o.leaderWaitBackoff)
+	}
+	if o.preSplitRegionCount <= 0 {
+		return errors.Errorf("pre-split region count should be positive, got %v", o.preSplitRegionCount)
+	}
+	if o.preGrouped != nil && o.existingRegionIDs != nil {
+		return errors.New("pre-grouped split keys can't be used with existing regions")
+	}
+	return nil
+}
+
+// WithScatterJitter sets the jitter mode (retry.NoJitter, retry.FullJitter, retry.EqualJitter or
+// retry.DecorrJitter) of the backoff used when scatter requests are retried. When a large split fans out,
+// jitter prevents the batches from retrying against PD in lockstep.
+func WithScatterJitter(jitter int) SplitOption {
+	return func(o *splitOptions) {
+		o.scatterBackoff = retry.BoPDRPC.WithJitter(jitter)
+	}
+}
+
+// WithSplitRequestTimeout sets the timeout of each split region request sent to TiKV. The default is
+// ReadTimeoutShort, consider ReadTimeoutMedium when splitting large regions on a loaded cluster.
+func WithSplitRequestTimeout(timeout time.Duration) SplitOption {
+	return func(o *splitOptions) {
+		o.reqTimeout = timeout
+	}
+}
+
+// WithSplitConcurrency sets the max number of split batches sent concurrently. The default is GOMAXPROCS*2.
+func WithSplitConcurrency(concurrency int) SplitOption {
+	return func(o *splitOptions) {
+		o.concurrency = concurrency
+	}
+}
+
+// WithSplitMultiError makes SplitRegions return a *tikverr.ErrSplitRegionBatches containing the errors of
+// all failed batches when more than one batch fails. By default only the most actionable error is returned,
+// non-retryable errors such as PD timeout or context cancellation are preferred over retryable ones.
+func WithSplitMultiError() SplitOption {
+	return func(o *splitOptions) {
+		o.multiError = true
+	}
+}
+
+// WithSplitKeyNormalizer sets a function to round each split key to a valid boundary, e.g. the row key prefix
+// of a TiDB encoded key, before the keys are grouped by region. The keys normalized to the same or an empty
+// key are split only once or skipped. By default the keys are split as they are.
+func WithSplitKeyNormalizer(normalizer func([]byte) []byte) SplitOption {
+	return func(o *splitOptions) {
+		o.keyNormalizer = normalizer
+	}
+}
+
+// normalizeSplitKeys normalizes the split keys, and removes the empty and duplicated keys.
+func normalizeSplitKeys(keys [][]byte, normalizer func([]byte) []byte) [][]byte {
+	normalized := make([][]byte, 0, len(keys))
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		key = normalizer(key)
+		if len(key) == 0 {
+			continue
+		}
+		if _, ok := seen[string(key)]; ok {
+			continue
+		}
+		seen[string(key)] = struct{}{}
+		normalized = append(normalized, key)
+	}
+	return normalized
+}
+
+// WithSplitPanicStack captures the stack trace into the returned error if sending a split batch panics,
+// which helps to debug the crashes. It's disabled by default to keep the error message short.
+func WithSplitPanicStack() SplitOption {
+	return func(o *splitOptions) {
+		o.panicStack = true
+	}
+}
+
+// WithScatterMinHealthyStores makes SplitRegions check the number of healthy TiKV stores (up in PD) before
+// scattering the new regions. If there are fewer than minStores healthy stores, the regions are split but not
+// scattered, and ErrInsufficientHealthyStores is returned along with the new region IDs. It avoids the scatter
+// schedules that never converge and the long pointless waits for them during partial outages. The default is 0,
+// which means no check.
+func WithScatterMinHealthyStores(minStores int) SplitOption {
+	return func(o *splitOptions) {
+		o.minHealthyStores = minStores
+	}
+}
+
+// WithSplitAlreadySplitKeys makes SplitRegions report the split keys which are equal to the start keys of
+// existing regions into keys. Such keys are skipped since the regions are already split there, reporting them
+// lets idempotent workflows, e.g. re-running a pre-split, confirm that the desired layout exists: every split
+// key is either split by this call or already split. keys is filled even if SplitRegions fails.
+func WithSplitAlreadySplitKeys(keys *[][]byte) SplitOption {
+	return func(o *splitOptions) {
+		o.alreadySplitKeys = keys
+	}
+}
+
+// WithSplitExistingRegions makes SplitRegions load the regions spanned by the split keys from PD before splitting,
+// skip the keys which are already region boundaries, and report the IDs of the regions starting at them into
+// regionIDs. The returned region IDs are the newly split ones only. Unlike relying on the region cache, which
+// may be stale, it makes a retried pre-split cheap and accurate, at the cost of scanning the regions between the
+// min and max split key from PD, which is one PD request per 128 regions.
+func WithSplitExistingRegions(regionIDs *[]uint64) SplitOption {
+	return func(o *splitOptions) {
+		o.existingRegionIDs = regionIDs
+	}
+}
+
+// WithSplitBackoffStats makes SplitRegions fill the backoff statistics of the split batches into stats, which
+// helps to find out whether some batches are slow. stats is filled even if SplitRegions fails.
+func WithSplitBackoffStats(stats *SplitBackoffStats) SplitOption {
+	return func(o *splitOptions) {
+		o.backoffStats = stats
+	}
+}
+
+// WithSplitLeaderWait makes SplitRegionsWithLeaders wait for the new regions to be scattered, at most backoff,
+// before resolving their leaders, since scattering moves the leaders. The leaders are resolved anyway after the
+// wait runs out.
+func WithSplitLeaderWait(backoff time.Duration) SplitOption {
+	return func(o *splitOptions) {
+		o.leaderWaitBackoff = int(backoff.Milliseconds())
+	}
+}
+
+// WithPreSplitRegionCount sets the number of regions PreSplitByKeys splits the range of the sampled keys into. The
+// default is 16.
+func WithPreSplitRegionCount(count int) SplitOption {
+	return func(o *splitOptions) {
+		o.preSplitRegionCount = count
+	}
+}
 
-func equalRegionStartKey(key, regionStartKey []byte) bool {
+// WithScatterFilter makes SplitRegions scatter only the new regions that shouldScatter returns true for, e.g. to
+// avoid scattering the tiny regions which don't need redistribution. The last region split from each region is
+// never scattered, regardless of the filter.
+func WithScatterFilter(shouldScatter func(*metapb.Region) bool) SplitOption {
+	return func(o *splitOptions) {
+		o.shouldScatter = shouldScatter
+	}
+}
+
+// WithSplitRequestContext sets the fields on the context of the split region requests sent to TiKV. By default
+// only the priority is set, to PriorityNormal.
+func WithSplitRequestContext(reqCtx SplitRequestContext) SplitOption {
+	return func(o *splitOptions) {
+		o.reqCtx = reqCtx
+	}
+}
+
+// WithPreGroupedKeys makes SplitRegions split the keys already grouped by region, e.g. generated from a region
+// scan, instead of grouping the split keys by the region cache, which saves the work for large splits. The keys of
+// each group should be sorted and inside the region, and the split keys passed to SplitRegions should be empty.
+// The groups aren't checked before sending, the keys of a group are regrouped if the region is stale, e.g. it's
+// split or merged since grouped. Unlike the split keys, the keys equal to region start keys aren't skipped.
+func WithPreGroupedKeys(groups map[RegionVerID][][]byte) SplitOption {
+	return func(o *splitOptions) {
+		o.preGrouped = groups
+	}
+}
+
+// WithSplitVerify makes SplitRegions verify that every split key is a region start key after splitting, and report
+// the keys which are not into unsplitKeys, which catches the splits failed silently, e.g. TiKV skips or adjusts
+// some split keys. Each key is located by the region cache, and reloaded from PD if the cached region doesn't start
+// at it, so the verification costs region cache lookups, and possibly PD requests, proportional to the number of
+// split keys. unsplitKeys is filled only if SplitRegions succeeds.
+func WithSplitVerify(unsplitKeys *[][]byte) SplitOption {
+	return func(o *splitOptions) {
+		o.unsplitKeys = unsplitKeys
+	}
+}
+
+// WithScatterOptions sets the options passed to PD when scattering the new regions.
+func WithScatterOptions(scatterOpts ScatterOptions) SplitOption {
+	return func(o *splitOptions) {
+		o.scatter = scatterOpts
+	}
+}
+
+// FilterKeysAtRegionStart is a filter of RegionCache.GroupKeysByRegion, it drops the keys which are equal to the
+// start keys of their regions. For example, such split keys are skipped since the regions are already split there.
+func FilterKeysAtRegionStart(key, regionStartKey []byte) bool {
 	return bytes.Equal(key, regionStartKey)
 }
 
-func (s *KVStore) splitBatchRegionsReq(bo *Backoffer, keys [][]byte, scatter bool, tableID *int64) (*tikvrpc.Response, error) {
-	// equalRegionStartKey is used to filter split keys.
+// startBackofferSpan starts a child span of the span in the backoffer's context, and sets it to the backoffer.
+// The returned function finishes the span and restores the context. The span is nil and nothing is done if
+// there's no span in the context, so it costs nothing when tracing is disabled.
+func startBackofferSpan(bo *Backoffer, operationName string) (opentracing.Span, func()) {
+	span := opentracing.SpanFromContext(bo.GetCtx())
+	if span == nil || span.Tracer() == nil {
+		return nil, func() {}
+	}
+	ctx := bo.GetCtx()
+	span1 := span.Tracer().StartSpan(operationName, opentracing.ChildOf(span.Context()))
+	bo.SetCtx(opentracing.ContextWithSpan(ctx, span1))
+	return span1, func() {
+		span1.Finish()
+		bo.SetCtx(ctx)
+	}
+}
+
+func (s *KVStore) splitBatchRegionsReq(bo *Backoffer, keys [][]byte, scatter bool, tableID *int64, opts *splitOptions) (*tikvrpc.Response, error) {
+	span, finishSpan := startBackofferSpan(bo, "tikvStore.splitBatchRegionsReq")
+	defer finishSpan()
+	// FilterKeysAtRegionStart is used to filter split keys.
 	// If the split key is equal to the start key of the region, then the key has been split, we need to skip the split key.
-	groups, _, err := s.regionCache.GroupKeysByRegion(bo, keys, equalRegionStartKey)
+	filter := FilterKeysAtRegionStart
+	if opts.alreadySplitRecorder != nil {
+		filter = func(key, regionStartKey []byte) bool {
+			if FilterKeysAtRegionStart(key, regionStartKey) {
+				opts.alreadySplitRecorder.record(key)
+				return true
+			}
+			return false
+		}
+	}
+	groups, _, err := s.regionCache.GroupKeysByRegion(bo, keys, filter)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
+	batches := groupsToSplitBatches(groups)
+	if span != nil {
+		span.SetTag("keys", len(keys))
+		span.SetTag("batches", len(batches))
+	}
+	return s.sendSplitBatches(bo, batches, len(keys), scatter, tableID, opts)
+}
+
+// splitPreGroupedReq splits the keys grouped by region without grouping them again.
+func (s *KVStore) splitPreGroupedReq(bo *Backoffer, groups map[RegionVerID][][]byte, scatter bool, tableID *int64, opts *splitOptions) (*tikvrpc.Response, error) {
+	span, finishSpan := startBackofferSpan(bo, "tikvStore.splitPreGroupedReq")
+	defer finishSpan()
+	batches := groupsToSplitBatches(groups)
+	keyCount := countGroupedKeys(groups)
+	if span != nil {
+		span.SetTag("keys", keyCount)
+		span.SetTag("batches", len(batches))
+	}
+	return s.sendSplitBatches(bo, batches, keyCount, scatter, tableID, opts)
+}
+
+// SplitRegionInLocation splits the region of loc by the keys without locating them, for the callers splitting a
+// known region in a tight loop, e.g. a region just located by LocateKeys. The keys are used as is, so they must be
+// encoded like loc, i.e. in the key space of the region cache, including the keyspace prefix if the store works in
+// a keyspace. All the keys should be in loc, the keys at the start of the region are skipped and the duplicated
+// keys are split once. If the region turns out to be stale, the keys are located and split like SplitRegions. It
+// returns the response of the split, which is nil if there is nothing to split.
+func (s *KVStore) SplitRegionInLocation(bo *Backoffer, loc *KeyLocation, keys [][]byte, scatter bool, tableID *int64) (resp *tikvrpc.Response, err error) {
+	origCtx := bo.GetCtx()
+	ctx, done := s.startOp(origCtx)
+	defer done()
+	bo.SetCtx(s.withOperationID(ctx))
+	defer bo.SetCtx(origCtx)
+	defer func() {
+		if err != nil {
+			smallest, largest := keyBounds(keys)
+			err = tikverr.WithOperation(err, "SplitRegionInLocation", smallest, largest)
+		}
+	}()
+	splitKeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if !loc.Contains(key) {
+			return nil, errors.Errorf("split key %s is out of region %d [%s, %s)",
+				kv.StrKey(key), loc.Region.GetID(), kv.StrKey(loc.StartKey), kv.StrKey(loc.EndKey))
+		}
+		if !FilterKeysAtRegionStart(key, loc.StartKey) {
+			splitKeys = append(splitKeys, key)
+		}
+	}
+	// TiKV requires the split keys in order and distinct.
+	sort.Slice(splitKeys, func(i, j int) bool {
+		return bytes.Compare(splitKeys[i], splitKeys[j]) < 0
+	})
+	distinct := splitKeys[:0]
+	for i, key := range splitKeys {
+		if i == 0 || !bytes.Equal(key, splitKeys[i-1]) {
+			distinct = append(distinct, key)
+		}
+	}
+	splitKeys = distinct
+	batches := appendKeyBatches(nil, loc.Region, splitKeys, GetSplitBatchRegionLimit())
+	return s.sendSplitBatches(bo, batches, len(splitKeys), scatter, tableID, newSplitOptions(nil))
+}
+
+// groupsToSplitBatches divides the split keys grouped by region into batches.
+func groupsToSplitBatches(groups map[RegionVerID][][]byte) []batch {
 	var batches []batch
 	for regionID, groupKeys := range groups {
-		batches = appendKeyBatches(batches, regionID, groupKeys, splitBatchRegionLimit)
+		if len(groupKeys) > 0 {
+			batches = appendKeyBatches(batches, regionID, groupKeys, GetSplitBatchRegionLimit())
+		}
+	}
+	return batches
+}
+
+// countGroupedKeys returns the number of the split keys grouped by region.
+func countGroupedKeys(groups map[RegionVerID][][]byte) int {
+	count := 0
+	for _, keys := range groups {
+		count += len(keys)
 	}
+	return count
+}
 
+// sendSplitBatches sends the split batches of keyCount keys in total, and merges the new regions of them.
+func (s *KVStore) sendSplitBatches(bo *Backoffer, batches []batch, keyCount int, scatter bool, tableID *int64, opts *splitOptions) (*tikvrpc.Response, error) {
 	if len(batches) == 0 {
 		return nil, nil
 	}
 	// The first time it enters this function.
 	if bo.GetTotalSleep() == 0 {
-		logutil.BgLogger().Info("split batch regions request",
-			zap.Int("split key count", len(keys)),
+		s.ctxLogger(bo.GetCtx()).Info("split batch regions request",
+			zap.Int("split key count", keyCount),
 			zap.Int("batch count", len(batches)),
 			zap.Uint64("first batch, region ID", batches[0].regionID.GetID()),
 			zap.String("first split key", kv.StrKey(batches[0].keys[0])))
 	}
 	if len(batches) == 1 {
-		resp := s.batchSendSingleRegion(bo, batches[0], scatter, tableID)
+		resp := s.batchSendSingleRegion(bo, batches[0], scatter, tableID, opts)
 		return resp.resp, errors.Trace(resp.err)
 	}
+	// Send the batches with a bounded number of workers, so a huge split doesn't spawn a goroutine
+	// (and a forked backoffer) for every batch at once.
+	concurrency := opts.concurrency
+	if concurrency > len(batches) {
+		concurrency = len(batches)
+	}
+	batchCh := make(chan batch, len(batches))
+	for _, b := range batches {
+		batchCh <- b
+	}
+	close(batchCh)
+	// ch holds the responses of all batches, so the workers never block on it, and it's closed after all the
+	// workers return, so the responses are always drained and no worker outlives this function.
 	ch := make(chan singleBatchResp, len(batches))
-	for _, batch1 := range batches {
-		go func(b batch) {
-			backoffer, cancel := bo.Fork()
-			defer cancel()
-
-			util.WithRecovery(func() {
-				select {
-				case ch <- s.batchSendSingleRegion(backoffer, b, scatter, tableID):
-				case <-bo.GetCtx().Done():
-					ch <- singleBatchResp{err: bo.GetCtx().Err()}
-				}
-			}, func(r interface{}) {
-				if r != nil {
-					ch <- singleBatchResp{err: errors.Errorf("%v", r)}
-				}
-			})
-		}(batch1)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batchCh {
+				s.sendSplitBatch(bo, b, scatter, tableID, opts, ch)
+			}
+		}()
 	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
 
-	srResp := &kvrpcpb.SplitRegionResponse{Regions: make([]*metapb.Region, 0, len(keys)*2)}
-	for i := 0; i < len(batches); i++ {
-		batchResp := <-ch
+	srResp := &kvrpcpb.SplitRegionResponse{Regions: make([]*metapb.Region, 0, keyCount*2)}
+	var (
+		errs []error
+		err  error
+	)
+	for batchResp := range ch {
 		if batchResp.err != nil {
-			logutil.BgLogger().Info("batch split regions failed", zap.Error(batchResp.err))
-			if err == nil {
-				err = batchResp.err
+			s.ctxLogger(bo.GetCtx()).Info("batch split regions failed", zap.Error(batchResp.err))
+			// Flatten the errors returned by the retried batches.
+			batchErrs := []error{batchResp.err}
+			if multiErr, ok := errors.Cause(batchResp.err).(*tikverr.ErrSplitRegionBatches); ok {
+				batchErrs = multiErr.Errors
+			}
+			for _, batchErr := range batchErrs {
+				errs = append(errs, batchErr)
+				// Prefer the non-retryable errors since they are more actionable.
+				if err == nil || (!isNonRetryableSplitErr(err) && isNonRetryableSplitErr(batchErr)) {
+					err = batchErr
+				}
 			}
 		}
 
 		// If the split succeeds and the scatter fails, we also need to add the region IDs.
-		if batchResp.resp != nil {
+		if batchResp.resp != nil && opts.resultSink == nil {
 			spResp := batchResp.resp.Resp.(*kvrpcpb.SplitRegionResponse)
 			regions := spResp.GetRegions()
 			srResp.Regions = append(srResp.Regions, regions...)
 		}
 	}
+	if opts.multiError && len(errs) > 1 {
+		err = &tikverr.ErrSplitRegionBatches{Errors: errs}
+	}
 	return &tikvrpc.Response{Resp: srResp}, errors.Trace(err)
 }
 
-func (s *KVStore) batchSendSingleRegion(bo *Backoffer, batch batch, scatter bool, tableID *int64) singleBatchResp {
+// isNonRetryableSplitErr checks whether the error is caused by PD timeout or the context being done,
+// in which case retrying the split doesn't help.
+func isNonRetryableSplitErr(err error) bool {
+	if tikverr.IsPDServerTimeout(err) {
+		return true
+	}
+	cause := errors.Cause(err)
+	return cause == context.Canceled || cause == context.DeadlineExceeded
+}
+
+// sendSplitBatch sends a split batch with a forked backoffer and puts exactly one response into ch.
+func (s *KVStore) sendSplitBatch(bo *Backoffer, b batch, scatter bool, tableID *int64, opts *splitOptions, ch chan<- singleBatchResp) {
+	backoffer, cancel := bo.Fork()
+	defer cancel()
+
+	util.WithRecovery(func() {
+		// Don't bother sending the request if the context is already done.
+		if err := bo.GetCtx().Err(); err != nil {
+			ch <- singleBatchResp{err: errors.Trace(err)}
+			return
+		}
+		select {
+		case ch <- s.batchSendSingleRegion(backoffer, b, scatter, tableID, opts):
+		case <-bo.GetCtx().Done():
+			ch <- singleBatchResp{err: errors.Trace(bo.GetCtx().Err())}
+		}
+	}, func(r interface{}) {
+		if r == nil {
+			return
+		}
+		if opts.panicStack {
+			ch <- singleBatchResp{err: errors.Errorf("%v\n%s", r, debug.Stack())}
+		} else {
+			ch <- singleBatchResp{err: errors.Errorf("%v", r)}
+		}
+	})
+}
+
+func (s *KVStore) batchSendSingleRegion(bo *Backoffer, batch batch, scatter bool, tableID *int64, opts *splitOptions) singleBatchResp {
+	if _, err := util.EvalFailpoint("mockSplitRegionPanic"); err == nil {
+		panic("mock split region panic")
+	}
 	if val, err := util.EvalFailpoint("mockSplitRegionTimeout"); err == nil {
 		if val.(bool) {
 			if _, ok := bo.GetCtx().Deadline(); ok {
@@ -137,15 +748,35 @@ func (s *KVStore) batchSendSingleRegion(bo *Backoffer, batch batch, scatter bool
 			}
 		}
 	}
+	if s.hooks.beforeSplitSend != nil {
+		s.hooks.beforeSplitSend(bo.GetCtx())
+	}
+	// Fail fast instead of issuing a doomed RPC if the deadline has already been exceeded,
+	// e.g. it may be burned on grouping keys by region.
+	if err := bo.GetCtx().Err(); err != nil {
+		return singleBatchResp{err: errors.Trace(err)}
+	}
+	span, finishSpan := startBackofferSpan(bo, "tikvStore.batchSendSingleRegion")
+	defer finishSpan()
+	// Record the backoff of this batch, excluding the batches resent by the nested splitBatchRegionsReq,
+	// which are recorded by themselves.
+	startSleep, nestedSleep := bo.GetTotalSleep(), 0
+	if opts.backoffRecorder != nil {
+		defer func() {
+			opts.backoffRecorder.record(bo.GetTotalSleep() - startSleep - nestedSleep)
+		}()
+	}
+	if span != nil {
+		span.SetTag("region_id", batch.regionID.GetID())
+		span.SetTag("keys", len(batch.keys))
+	}
 
 	req := tikvrpc.NewRequest(tikvrpc.CmdSplitRegion, &kvrpcpb.SplitRegionRequest{
 		SplitKeys: batch.keys,
-	}, kvrpcpb.Context{
-		Priority: kvrpcpb.CommandPri_Normal,
-	})
+	}, opts.reqCtx.toPB())
 
 	sender := locate.NewRegionRequestSender(s.regionCache, s.GetTiKVClient())
-	resp, err := sender.SendReq(bo, req, batch.regionID, client.ReadTimeoutShort)
+	resp, err := sender.SendReq(bo, req, batch.regionID, opts.reqTimeout)
 
 	batchResp := singleBatchResp{resp: resp}
 	if err != nil {
@@ -163,7 +794,9 @@ func (s *KVStore) batchSendSingleRegion(bo *Backoffer, batch batch, scatter bool
 			batchResp.err = errors.Trace(err)
 			return batchResp
 		}
-		resp, err = s.splitBatchRegionsReq(bo, batch.keys, scatter, tableID)
+		nestedStartSleep := bo.GetTotalSleep()
+		resp, err = s.splitBatchRegionsReq(bo, batch.keys, scatter, tableID, opts)
+		nestedSleep = bo.GetTotalSleep() - nestedStartSleep
 		batchResp.resp = resp
 		batchResp.err = err
 		return batchResp
@@ -175,95 +808,979 @@ func (s *KVStore) batchSendSingleRegion(bo *Backoffer, batch batch, scatter bool
 		// Divide a region into n, one of them may not need to be scattered,
 		// so n-1 needs to be scattered to other stores.
 		spResp.Regions = regions[:len(regions)-1]
+		metrics.TiKVScatterSkippedRegionCounter.Inc()
+		s.ctxLogger(bo.GetCtx()).Debug("batch split regions, exclude the last region from scattering",
+			zap.Uint64("batch region ID", batch.regionID.GetID()),
+			zap.Uint64("excluded region ID", regions[len(regions)-1].GetId()))
 	}
 	var newRegionLeft string
 	if len(spResp.Regions) > 0 {
 		newRegionLeft = logutil.Hex(spResp.Regions[0]).String()
 	}
-	logutil.BgLogger().Info("batch split regions complete",
+	s.ctxLogger(bo.GetCtx()).Info("batch split regions complete",
 		zap.Uint64("batch region ID", batch.regionID.GetID()),
 		zap.String("first at", kv.StrKey(batch.keys[0])),
 		zap.String("first new region left", newRegionLeft),
 		zap.Int("new region count", len(spResp.Regions)))
+	if s.eventSink != nil {
+		s.eventSink.OnSplitComplete(batch.regionID.GetID(), len(spResp.Regions))
+	}
+	if span != nil {
+		span.SetTag("new_regions", len(spResp.Regions))
+	}
+	if opts.resultSink != nil {
+		defer func() {
+			opts.resultSink(bo.GetCtx(), SplitResult{BatchRegionID: batch.regionID.GetID(), Regions: spResp.Regions, Err: batchResp.err})
+		}()
+	}
 
 	if !scatter {
 		return batchResp
 	}
 
+	var (
+		toScatter []*metapb.Region
+		// scatterKeys are the split keys of the regions to scatter, i.e. their start keys.
+		scatterKeys [][]byte
+	)
 	for i, r := range spResp.Regions {
-		if err = s.scatterRegion(bo, r.Id, tableID); err == nil {
-			logutil.BgLogger().Info("batch split regions, scatter region complete",
+		if opts.shouldScatter != nil && !opts.shouldScatter(r) {
+			metrics.TiKVScatterSkippedRegionCounter.Inc()
+			s.ctxLogger(bo.GetCtx()).Debug("batch split regions, region excluded from scattering by the filter",
 				zap.Uint64("batch region ID", batch.regionID.GetID()),
-				zap.String("at", kv.StrKey(batch.keys[i])),
 				zap.Stringer("new region left", logutil.Hex(r)))
 			continue
 		}
+		toScatter = append(toScatter, r)
+		scatterKeys = append(scatterKeys, batch.keys[i])
+	}
+	if len(toScatter) == 0 {
+		return batchResp
+	}
 
-		logutil.BgLogger().Info("batch split regions, scatter region failed",
+	// Scatter the new regions in one PD request. PD of this version doesn't report which regions fail, so if some
+	// of them aren't scattered, e.g. one of them is merged, scatter them one by one to find out.
+	regionIDs := make([]uint64, 0, len(toScatter))
+	for _, r := range toScatter {
+		regionIDs = append(regionIDs, r.Id)
+	}
+	scatterResp, err := s.sendScatterRegions(bo, regionIDs, tableID, opts)
+	if err == nil && !isScatterPartial(scatterResp) {
+		s.ctxLogger(bo.GetCtx()).Info("batch split regions, scatter regions complete",
+			zap.Uint64("batch region ID", batch.regionID.GetID()),
+			zap.String("first at", kv.StrKey(scatterKeys[0])),
+			zap.Int("region count", len(regionIDs)))
+		return batchResp
+	}
+	if err != nil && !isFatalScatterErr(err) {
+		// PD keeps failing or ctx is done, scattering the regions one by one can't succeed either.
+		s.ctxLogger(bo.GetCtx()).Info("batch split regions, scatter regions failed",
 			zap.Uint64("batch region ID", batch.regionID.GetID()),
-			zap.String("at", kv.StrKey(batch.keys[i])),
+			zap.String("first at", kv.StrKey(scatterKeys[0])),
+			zap.Int("region count", len(regionIDs)),
+			zap.Error(err))
+		if s.eventSink != nil {
+			for _, regionID := range regionIDs {
+				s.eventSink.OnScatterFailed(regionID, err)
+			}
+		}
+		batchResp.err = err
+		return batchResp
+	}
+	s.ctxLogger(bo.GetCtx()).Info("batch split regions, scatter regions partially failed, scatter them one by one",
+		zap.Uint64("batch region ID", batch.regionID.GetID()),
+		zap.Int("region count", len(regionIDs)),
+		zap.Uint64("finished percentage", scatterResp.GetFinishedPercentage()),
+		zap.Error(err))
+	for i, r := range toScatter {
+		if err = s.scatterRegion(bo, r.Id, tableID, opts); err == nil {
+			s.ctxLogger(bo.GetCtx()).Info("batch split regions, scatter region complete",
+				zap.Uint64("batch region ID", batch.regionID.GetID()),
+				zap.String("at", kv.StrKey(scatterKeys[i])),
+				zap.Stringer("new region left", logutil.Hex(r)))
+			continue
+		}
+
+		s.ctxLogger(bo.GetCtx()).Info("batch split regions, scatter region failed",
+			zap.Uint64("batch region ID", batch.regionID.GetID()),
+			zap.String("at", kv.StrKey(scatterKeys[i])),
 			zap.Stringer("new region left", logutil.Hex(r)),
 			zap.Error(err))
+		if s.eventSink != nil {
+			s.eventSink.OnScatterFailed(r.Id, err)
+		}
 		if batchResp.err == nil {
 			batchResp.err = err
 		}
-		if _, ok := err.(*tikverr.ErrPDServerTimeout); ok {
+		if tikverr.IsPDServerTimeout(err) {
 			break
 		}
 	}
 	return batchResp
 }
 
-const (
-	splitRegionBackoff     = 20000
-	maxSplitRegionsBackoff = 120000
-)
-
-// SplitRegions splits regions by splitKeys.
+// SplitRegions implements SplittableStore interface.
+// It splits regions by splitKeys.
+// If the store works in a keyspace, the split keys are prefixed with the keyspace prefix.
+// The split keys are sent in batches, if some batches fail, the IDs of the regions created by the other
+// batches are still returned along with the error, so the caller can scatter or clean up them.
 func (s *KVStore) SplitRegions(ctx context.Context, splitKeys [][]byte, scatter bool, tableID *int64) (regionIDs []uint64, err error) {
-	bo := retry.NewBackofferWithVars(ctx, int(math.Min(float64(len(splitKeys))*splitRegionBackoff, maxSplitRegionsBackoff)), nil)
-	resp, err := s.splitBatchRegionsReq(bo, splitKeys, scatter, tableID)
-	regionIDs = make([]uint64, 0, len(splitKeys))
+	return s.SplitRegionsWithOptions(ctx, splitKeys, scatter, tableID)
+}
+
+// SplitRegionsWithOptions splits regions by splitKeys like SplitRegions, with the split options applied.
+func (s *KVStore) SplitRegionsWithOptions(ctx context.Context, splitKeys [][]byte, scatter bool, tableID *int64, opts ...SplitOption) (regionIDs []uint64, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	regionIDs, err = s.splitRegionsWithOptions(ctx, splitKeys, scatter, tableID, opts)
+	if err != nil {
+		smallest, largest := keyBounds(splitKeys)
+		err = tikverr.WithOperation(err, "SplitRegions", smallest, largest)
+	}
+	return regionIDs, err
+}
+
+// splitRegionsWithOptions is SplitRegionsWithOptions without registering the operation or attaching it to the
+// error, so the operations built on it attach only their own.
+func (s *KVStore) splitRegionsWithOptions(ctx context.Context, splitKeys [][]byte, scatter bool, tableID *int64, opts []SplitOption) (regionIDs []uint64, err error) {
+	splitOpts := newSplitOptions(opts)
+	if err = splitOpts.validate(); err != nil {
+		return nil, err
+	}
+	ctx = s.withOperationID(ctx)
+	if splitOpts.keyNormalizer != nil {
+		splitKeys = normalizeSplitKeys(splitKeys, splitOpts.keyNormalizer)
+	}
+	if len(s.keyspacePrefix) > 0 {
+		splitKeys = s.encodeSplitKeys(splitKeys)
+	}
+	if splitOpts.preGrouped != nil {
+		if len(splitKeys) > 0 {
+			return nil, errors.New("split keys should be empty with pre-grouped keys")
+		}
+		splitOpts.preGrouped = s.encodePreGroupedKeys(splitOpts.preGrouped, splitOpts.keyNormalizer)
+	}
+	regionIDs, err = s.splitRegions(ctx, splitKeys, scatter, tableID, splitOpts)
+	if splitOpts.alreadySplitRecorder != nil {
+		recorded := splitOpts.alreadySplitRecorder.get()
+		keys := make([][]byte, 0, len(recorded))
+		for _, key := range recorded {
+			keys = append(keys, key[len(s.keyspacePrefix):])
+		}
+		*splitOpts.alreadySplitKeys = keys
+	}
+	if err == nil && splitOpts.unsplitKeys != nil {
+		verifyKeys := splitKeys
+		if splitOpts.preGrouped != nil {
+			verifyKeys = make([][]byte, 0, countGroupedKeys(splitOpts.preGrouped))
+			for _, keys := range splitOpts.preGrouped {
+				verifyKeys = append(verifyKeys, keys...)
+			}
+		}
+		bo := retry.NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+		*splitOpts.unsplitKeys, err = s.verifySplitKeys(bo, verifyKeys)
+	}
+	return regionIDs, err
+}
+
+// verifySplitKeys returns the split keys which aren't region start keys, without the keyspace prefix. The keys are
+// located by the region cache, and reloaded from PD if the cached region doesn't start at them, since the cached
+// region may be loaded before splitting.
+func (s *KVStore) verifySplitKeys(bo *Backoffer, keys [][]byte) ([][]byte, error) {
+	var unsplit [][]byte
+	for _, key := range keys {
+		loc, err := s.regionCache.LocateKey(bo, key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if !bytes.Equal(loc.StartKey, key) {
+			s.regionCache.InvalidateCachedRegion(loc.Region)
+			if loc, err = s.regionCache.LocateKey(bo, key); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		if !bytes.Equal(loc.StartKey, key) {
+			unsplit = append(unsplit, key[len(s.keyspacePrefix):])
+		}
+	}
+	return unsplit, nil
+}
+
+// SplitRegionsStream splits the regions by splitKeys like SplitRegions, but delivers the result of each split batch
+// as it completes rather than accumulating the new regions of all batches, so the callers of large splits can
+// process the regions incrementally, e.g. wait for them to be scattered, without holding all of them in memory.
+// The batches are sent as concurrently as SplitRegions. The results channel is closed after all batches complete
+// or ctx is done, the results not received by then are dropped. The error channel then delivers the error of the
+// split, which is nil on success, and is closed. It's buffered, so it never blocks the split. A caller abandoning
+// the stream before the results channel is closed must cancel ctx, otherwise the split blocks on delivering the
+// next result until the store is closed.
+func (s *KVStore) SplitRegionsStream(ctx context.Context, splitKeys [][]byte, scatter bool, tableID *int64, opts ...SplitOption) (<-chan SplitResult, <-chan error) {
+	resultCh := make(chan SplitResult)
+	errCh := make(chan error, 1)
+	sink := func(ctx context.Context, result SplitResult) {
+		select {
+		case resultCh <- result:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		ctx, done := s.startOp(ctx)
+		_, err := s.splitRegionsWithOptions(ctx, splitKeys, scatter, tableID, append(opts[:len(opts):len(opts)], withSplitResultSink(sink)))
+		done()
+		close(resultCh)
+		smallest, largest := keyBounds(splitKeys)
+		errCh <- tikverr.WithOperation(err, "SplitRegionsStream", smallest, largest)
+		close(errCh)
+	}()
+	return resultCh, errCh
+}
+
+// withSplitResultSink makes SplitRegions deliver the result of each split batch to sink.
+func withSplitResultSink(sink func(ctx context.Context, result SplitResult)) SplitOption {
+	return func(o *splitOptions) {
+		o.resultSink = sink
+	}
+}
+
+// encodeSplitKeys prefixes the split keys with the keyspace prefix.
+func (s *KVStore) encodeSplitKeys(splitKeys [][]byte) [][]byte {
+	encodedKeys := make([][]byte, 0, len(splitKeys))
+	for _, key := range splitKeys {
+		encodedKeys = append(encodedKeys, s.encodeKeyspaceKey(key))
+	}
+	return encodedKeys
+}
+
+// encodePreGroupedKeys normalizes the pre-grouped split keys and prefixes them with the keyspace prefix, without
+// modifying the groups of the caller.
+func (s *KVStore) encodePreGroupedKeys(groups map[RegionVerID][][]byte, normalizer func([]byte) []byte) map[RegionVerID][][]byte {
+	if normalizer == nil && len(s.keyspacePrefix) == 0 {
+		return groups
+	}
+	encoded := make(map[RegionVerID][][]byte, len(groups))
+	for regionID, keys := range groups {
+		if normalizer != nil {
+			keys = normalizeSplitKeys(keys, normalizer)
+		}
+		if len(s.keyspacePrefix) > 0 {
+			keys = s.encodeSplitKeys(keys)
+		}
+		encoded[regionID] = keys
+	}
+	return encoded
+}
+
+// SplitAndScatterWait splits the regions by splitKeys like SplitRegions, scatters the new regions, and waits until
+// they are scattered. The regions are waited concurrently, at most as many as the split concurrency at a time,
+// and waitBackoff bounds the total time(in ms) of the wait, if it's <= 0, the default wait scatter back off time
+// is used. The IDs of the new regions are always returned, along with the first error of splitting or waiting,
+// e.g. the wait runs out of time, in which case the regions are still scattered by PD in the background.
+func (s *KVStore) SplitAndScatterWait(ctx context.Context, splitKeys [][]byte, tableID *int64, waitBackoff int, opts ...SplitOption) (regionIDs []uint64, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() {
+		if err != nil {
+			smallest, largest := keyBounds(splitKeys)
+			err = tikverr.WithOperation(err, "SplitAndScatterWait", smallest, largest)
+		}
+	}()
+	ctx = s.withOperationID(ctx)
+	regionIDs, err = s.splitRegionsWithOptions(ctx, splitKeys, true, tableID, opts)
+	if err != nil || len(regionIDs) == 0 {
+		return regionIDs, err
+	}
+	if waitBackoff <= 0 {
+		waitBackoff = int(atomic.LoadInt64(&waitScatterRegionFinishBackoff))
+	}
+	return regionIDs, s.waitScatterRegions(ctx, regionIDs, waitBackoff, newSplitOptions(opts).concurrency)
+}
+
+// waitScatterRegions waits until the regions are scattered, at most concurrency regions at a time and
+// waitBackoff(in ms) in total. It returns the first error of the waits.
+func (s *KVStore) waitScatterRegions(ctx context.Context, regionIDs []uint64, waitBackoff int, concurrency int) error {
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(waitBackoff)*time.Millisecond)
+	defer cancel()
+
+	if concurrency > len(regionIDs) {
+		concurrency = len(regionIDs)
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	idCh := make(chan uint64)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for regionID := range idCh {
+				err := s.waitScatterRegionFinish(waitCtx, regionID, waitBackoff, nil)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, regionID := range regionIDs {
+		idCh <- regionID
+	}
+	close(idCh)
+	wg.Wait()
+	return firstErr
+}
+
+// RegionLeaderInfo is the leader of a region reported by PD.
+type RegionLeaderInfo struct {
+	RegionID uint64
+	// StartKey and EndKey are the range of the region. If the store works in a keyspace, they're without the
+	// keyspace prefix, and the bounds outside the keyspace are empty.
+	StartKey []byte
+	EndKey   []byte
+	// LeaderPeerID and LeaderStoreID are the leader peer of the region and the store it's on.
+	LeaderPeerID  uint64
+	LeaderStoreID uint64
+}
+
+// SplitRegionsWithLeaders splits the regions by splitKeys like SplitRegions, and resolves the leaders of the new
+// regions from PD, in the order of the returned region IDs of SplitRegions, so the follow-up writes can be routed
+// without another round of lookups. The leaders may move while the regions are being scattered, use
+// WithSplitLeaderWait to wait for the scatter first. The leaders of the regions split successfully are returned
+// along with the error if some batches fail, like SplitRegions.
+func (s *KVStore) SplitRegionsWithLeaders(ctx context.Context, splitKeys [][]byte, scatter bool, tableID *int64, opts ...SplitOption) (leaders []RegionLeaderInfo, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() {
+		if err != nil {
+			smallest, largest := keyBounds(splitKeys)
+			err = tikverr.WithOperation(err, "SplitRegionsWithLeaders", smallest, largest)
+		}
+	}()
+	ctx = s.withOperationID(ctx)
+	splitOpts := newSplitOptions(opts)
+	regionIDs, splitErr := s.splitRegionsWithOptions(ctx, splitKeys, scatter, tableID, opts)
+	if len(regionIDs) == 0 {
+		return nil, splitErr
+	}
+	if scatter && splitOpts.leaderWaitBackoff > 0 {
+		if err := s.waitScatterRegions(ctx, regionIDs, splitOpts.leaderWaitBackoff, splitOpts.concurrency); err != nil {
+			s.ctxLogger(ctx).Warn("wait scatter region failed before resolving the leaders",
+				zap.Int("regions", len(regionIDs)),
+				zap.Error(err))
+		}
+	}
+	leaders = make([]RegionLeaderInfo, 0, len(regionIDs))
+	for _, regionID := range regionIDs {
+		leader, err := s.loadRegionLeader(ctx, regionID)
+		if err != nil {
+			if splitErr != nil {
+				// The split error is what the caller needs to handle, the leader error is only logged.
+				s.ctxLogger(ctx).Warn("load region leader failed after the split failed",
+					zap.Uint64("regionID", regionID),
+					zap.Error(err))
+				return leaders, splitErr
+			}
+			return leaders, err
+		}
+		leaders = append(leaders, leader)
+	}
+	return leaders, splitErr
+}
+
+// loadRegionLeader loads the leader of the region from PD, it retries until the region has a leader, which may be
+// missing for a while after the region is split or its leader is transferred.
+func (s *KVStore) loadRegionLeader(ctx context.Context, regionID uint64) (RegionLeaderInfo, error) {
+	bo := retry.NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+	for {
+		region, err := s.splitGCPDClient.GetRegionByID(ctx, regionID)
+		if err == nil {
+			if region == nil || region.Meta == nil {
+				return RegionLeaderInfo{}, errors.Errorf("region not found for regionID %d", regionID)
+			}
+			if region.Leader != nil && region.Leader.GetStoreId() != 0 {
+				startKey, endKey := s.decodeKeyspaceRange(region.Meta.GetStartKey(), region.Meta.GetEndKey())
+				return RegionLeaderInfo{
+					RegionID:      regionID,
+					StartKey:      startKey,
+					EndKey:        endKey,
+					LeaderPeerID:  region.Leader.GetId(),
+					LeaderStoreID: region.Leader.GetStoreId(),
+				}, nil
+			}
+			err = errors.Errorf("region %d has no leader", regionID)
+		}
+		if err = bo.Backoff(retry.BoPDRPC, errors.New(err.Error())); err != nil {
+			return RegionLeaderInfo{}, errors.Trace(err)
+		}
+	}
+}
+
+// defaultPreSplitRegionCount is the default number of regions PreSplitByKeys splits the range of the sampled keys
+// into.
+const defaultPreSplitRegionCount = 16
+
+// PreSplitByKeys splits the range covered by the sampled keys into regions holding about the same number of keys,
+// and scatters the new regions. The number of regions is set by WithPreSplitRegionCount. Unlike the size based
+// pre-split of 2PC, it's for the loaders which know the distribution of their data, e.g. by sampling, so the
+// regions are balanced even if the keys are skewed. The sampled keys don't need to be sorted or unique. The split
+// keys are chosen from the sampled keys, so fewer regions are created if there aren't enough distinct keys.
+func (s *KVStore) PreSplitByKeys(ctx context.Context, keys [][]byte, opts ...SplitOption) (regionIDs []uint64, err error) {
+	defer func() {
+		if err != nil {
+			smallest, largest := keyBounds(keys)
+			err = tikverr.WithOperation(err, "PreSplitByKeys", smallest, largest)
+		}
+	}()
+	splitOpts := newSplitOptions(opts)
+	if err = splitOpts.validate(); err != nil {
+		return nil, err
+	}
+	splitKeys := quantileSplitKeys(keys, splitOpts.preSplitRegionCount)
+	if len(splitKeys) == 0 {
+		return nil, nil
+	}
+	return s.splitRegionsWithOptions(ctx, splitKeys, true, nil, opts)
+}
+
+// quantileSplitKeys returns at most n-1 keys which divide the sorted distinct keys into n parts evenly.
+func quantileSplitKeys(keys [][]byte, n int) [][]byte {
+	sorted := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if len(key) > 0 {
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	distinct := sorted[:0]
+	for _, key := range sorted {
+		if len(distinct) == 0 || !bytes.Equal(distinct[len(distinct)-1], key) {
+			distinct = append(distinct, key)
+		}
+	}
+	if n > len(distinct) {
+		n = len(distinct)
+	}
+
+	splitKeys := make([][]byte, 0, n)
+	for i := 1; i < n; i++ {
+		splitKeys = append(splitKeys, distinct[i*len(distinct)/n])
+	}
+	return splitKeys
+}
+
+// splitRegions splits regions by the encoded splitKeys. The keys are grouped and compared with the
+// region start keys in the encoded space.
+func (s *KVStore) splitRegions(ctx context.Context, splitKeys [][]byte, scatter bool, tableID *int64, splitOpts *splitOptions) (regionIDs []uint64, err error) {
+	// Don't bother locating the keys if the context is already done.
+	if err = ctx.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var scatterErr error
+	if scatter && splitOpts.minHealthyStores > 0 {
+		stores, err := s.getUpTiKVStores(ctx)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(stores) < splitOpts.minHealthyStores {
+			scatter = false
+			scatterErr = &tikverr.ErrInsufficientHealthyStores{Healthy: len(stores), Required: splitOpts.minHealthyStores}
+			s.ctxLogger(ctx).Warn("split regions without scattering", zap.Error(scatterErr))
+		}
+	}
+	keyCount := len(splitKeys) + countGroupedKeys(splitOpts.preGrouped)
+	backoff := math.Min(float64(keyCount)*float64(atomic.LoadInt64(&splitRegionBackoff)), float64(atomic.LoadInt64(&maxSplitRegionsBackoff)))
+	bo := retry.NewBackofferWithVars(ctx, int(backoff), nil)
+	if splitOpts.existingRegionIDs != nil {
+		var existing []uint64
+		splitKeys, existing, err = s.skipExistingBoundaries(bo, splitKeys, splitOpts)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		*splitOpts.existingRegionIDs = existing
+		if len(splitKeys) == 0 {
+			return nil, scatterErr
+		}
+	}
+	var resp *tikvrpc.Response
+	if splitOpts.preGrouped != nil {
+		resp, err = s.splitPreGroupedReq(bo, splitOpts.preGrouped, scatter, tableID, splitOpts)
+	} else {
+		resp, err = s.splitBatchRegionsReq(bo, splitKeys, scatter, tableID, splitOpts)
+	}
+	if err == nil {
+		err = scatterErr
+	}
+	regionIDs = make([]uint64, 0, keyCount)
+	// The response contains the regions created by the successful batches even if err is not nil.
 	if resp != nil && resp.Resp != nil {
 		spResp := resp.Resp.(*kvrpcpb.SplitRegionResponse)
 		for _, r := range spResp.Regions {
 			regionIDs = append(regionIDs, r.Id)
 		}
-		logutil.BgLogger().Info("split regions complete", zap.Int("region count", len(regionIDs)), zap.Uint64s("region IDs", regionIDs))
+	}
+	if splitOpts.backoffRecorder != nil {
+		*splitOpts.backoffStats = splitOpts.backoffRecorder.get()
+	}
+	if err != nil {
+		s.ctxLogger(ctx).Warn("split regions partially complete", zap.Int("region count", len(regionIDs)), zap.Uint64s("region IDs", regionIDs), zap.Error(err))
+	} else if len(regionIDs) > 0 {
+		s.ctxLogger(ctx).Info("split regions complete", zap.Int("region count", len(regionIDs)), zap.Uint64s("region IDs", regionIDs))
 	}
 	return regionIDs, errors.Trace(err)
 }
 
-func (s *KVStore) scatterRegion(bo *Backoffer, regionID uint64, tableID *int64) error {
-	logutil.BgLogger().Info("start scatter region",
+// skipExistingBoundaries loads the regions spanned by the split keys from PD, and returns the keys which aren't
+// region boundaries yet, along with the IDs of the regions starting at the other keys.
+func (s *KVStore) skipExistingBoundaries(bo *Backoffer, splitKeys [][]byte, opts *splitOptions) (remain [][]byte, existing []uint64, err error) {
+	var minKey, maxKey []byte
+	for _, key := range splitKeys {
+		if minKey == nil || bytes.Compare(key, minKey) < 0 {
+			minKey = key
+		}
+		if maxKey == nil || bytes.Compare(key, maxKey) > 0 {
+			maxKey = key
+		}
+	}
+	if minKey == nil {
+		return splitKeys, nil, nil
+	}
+	regions, err := s.regionCache.LoadRegionsInKeyRange(bo, minKey, kv.NextKey(maxKey))
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	regionStarts := make(map[string]uint64, len(regions))
+	for _, r := range regions {
+		regionStarts[string(r.StartKey())] = r.GetID()
+	}
+	remain = make([][]byte, 0, len(splitKeys))
+	for _, key := range splitKeys {
+		if id, ok := regionStarts[string(key)]; ok {
+			existing = append(existing, id)
+			if opts.alreadySplitRecorder != nil {
+				opts.alreadySplitRecorder.record(key)
+			}
+			continue
+		}
+		remain = append(remain, key)
+	}
+	return remain, existing, nil
+}
+
+// EstimateRegionCount returns the number of regions that the key range [startKey, endKey) spans, which helps to
+// decide whether it's worthwhile to pre-split the range. An empty endKey means the end of the keyspace. The count
+// is based on the region cache, so it may be slightly stale while regions are being split or merged.
+func (s *KVStore) EstimateRegionCount(ctx context.Context, startKey, endKey []byte) (count int, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	// The keys are encoded below, attach the keys of the caller.
+	defer func(startKey, endKey []byte) {
+		err = tikverr.WithOperation(err, "EstimateRegionCount", startKey, endKey)
+	}(startKey, endKey)
+	if len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0 {
+		return 0, errors.Errorf("invalid key range [%s, %s)", kv.StrKey(startKey), kv.StrKey(endKey))
+	}
+	startKey = s.encodeKeyspaceKey(startKey)
+	if len(endKey) > 0 {
+		endKey = s.encodeKeyspaceKey(endKey)
+	} else {
+		_, endKey = s.keyspaceRange()
+	}
+
+	bo := retry.NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+	for {
+		loc, err := s.GetRegionCache().LocateKey(bo, startKey)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		count++
+		if len(loc.EndKey) == 0 || (len(endKey) > 0 && bytes.Compare(loc.EndKey, endKey) >= 0) {
+			return count, nil
+		}
+		startKey = loc.EndKey
+	}
+}
+
+// WarmupRegionCache loads the regions in [startKey, endKey) of the store's keyspace from PD into the region cache,
+// so the following bulk operation over the range, e.g. a large SplitRegions, doesn't locate the keys from PD one by
+// one and back off on the cache misses. The regions are scanned in pages of up to 128 regions, one PD request per
+// page. An empty endKey means the end of the keyspace. It returns the number of regions loaded.
+func (s *KVStore) WarmupRegionCache(ctx context.Context, startKey, endKey []byte) (count int, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	// The keys are encoded below, attach the keys of the caller.
+	defer func(startKey, endKey []byte) {
+		err = tikverr.WithOperation(err, "WarmupRegionCache", startKey, endKey)
+	}(startKey, endKey)
+	if len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0 {
+		return 0, errors.Errorf("invalid key range [%s, %s)", kv.StrKey(startKey), kv.StrKey(endKey))
+	}
+	startKey = s.encodeKeyspaceKey(startKey)
+	if len(endKey) > 0 {
+		endKey = s.encodeKeyspaceKey(endKey)
+	} else {
+		_, endKey = s.keyspaceRange()
+	}
+
+	bo := retry.NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+	err = s.loadRegionsInRange(bo, startKey, endKey, func(*locate.Region) { count++ })
+	return count, err
+}
+
+// SplitOversizedRegions splits each region in [startKey, endKey) of the store's keyspace whose approximate size
+// exceeds sizeThreshold(in MiB, the unit PD reports) into two at the midpoint of its key range, and returns the IDs
+// of the new regions. An empty endKey means the end of the keyspace. The sizes are queried from the HTTP API of the
+// PD leader region by region, since the gRPC API of PD of this version doesn't report them. The midpoint is computed
+// from the keys rather than the data, so the halves may differ in size, and the regions whose range is too narrow
+// to have a midpoint are skipped with a warning. The new regions aren't scattered.
+func (s *KVStore) SplitOversizedRegions(ctx context.Context, sizeThreshold uint64, startKey, endKey []byte) (regionIDs []uint64, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	// The keys are encoded below, attach the keys of the caller.
+	defer func(startKey, endKey []byte) {
+		err = tikverr.WithOperation(err, "SplitOversizedRegions", startKey, endKey)
+	}(startKey, endKey)
+	if len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0 {
+		return nil, errors.Errorf("invalid key range [%s, %s)", kv.StrKey(startKey), kv.StrKey(endKey))
+	}
+	startKey = s.encodeKeyspaceKey(startKey)
+	if len(endKey) > 0 {
+		endKey = s.encodeKeyspaceKey(endKey)
+	} else {
+		_, endKey = s.keyspaceRange()
+	}
+	ctx = s.withOperationID(ctx)
+
+	bo := retry.NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+	var regions []*locate.Region
+	if err := s.loadRegionsInRange(bo, startKey, endKey, func(r *locate.Region) { regions = append(regions, r) }); err != nil {
+		return nil, err
+	}
+	var splitKeys [][]byte
+	for _, r := range regions {
+		size, err := s.getRegionApproximateSize(ctx, r.GetID())
+		if err != nil {
+			return nil, err
+		}
+		if size <= sizeThreshold {
+			continue
+		}
+		// Split only the part of the region in the range.
+		regionStart, regionEnd := r.StartKey(), r.EndKey()
+		if bytes.Compare(regionStart, startKey) < 0 {
+			regionStart = startKey
+		}
+		if len(endKey) > 0 && (len(regionEnd) == 0 || bytes.Compare(regionEnd, endKey) > 0) {
+			regionEnd = endKey
+		}
+		mid := midpointKey(regionStart, regionEnd)
+		if mid == nil {
+			s.ctxLogger(ctx).Warn("skip splitting oversized region without a midpoint",
+				zap.Uint64("regionID", r.GetID()),
+				zap.Uint64("approximate size(MiB)", size),
+				zap.String("start key", kv.StrKey(regionStart)),
+				zap.String("end key", kv.StrKey(regionEnd)))
+			continue
+		}
+		splitKeys = append(splitKeys, mid)
+	}
+	if len(splitKeys) == 0 {
+		return nil, nil
+	}
+	return s.splitRegions(ctx, splitKeys, false, nil, newSplitOptions(nil))
+}
+
+// keyBounds returns the smallest and largest keys, both are nil if there is no key.
+func keyBounds(keys [][]byte) (smallest, largest []byte) {
+	for _, key := range keys {
+		if smallest == nil || bytes.Compare(key, smallest) < 0 {
+			smallest = key
+		}
+		if largest == nil || bytes.Compare(key, largest) > 0 {
+			largest = key
+		}
+	}
+	return smallest, largest
+}
+
+// midpointKey returns the key in the middle of (startKey, endKey) by treating the keys as fractions, or nil if
+// there isn't one. An empty endKey means unbounded.
+func midpointKey(startKey, endKey []byte) []byte {
+	n := len(startKey)
+	if len(endKey) > n {
+		n = len(endKey)
+	}
+	// One more byte keeps the lowest bit of the sum.
+	n++
+	a := make([]byte, n)
+	copy(a, startKey)
+	b := make([]byte, n)
+	if len(endKey) == 0 {
+		for i := range b {
+			b[i] = 0xff
+		}
+	} else {
+		copy(b, endKey)
+	}
+	// mid = (a + b) / 2, the carry of the sum is the highest bit.
+	sum := make([]byte, n)
+	carry := 0
+	for i := n - 1; i >= 0; i-- {
+		v := int(a[i]) + int(b[i]) + carry
+		sum[i] = byte(v)
+		carry = v >> 8
+	}
+	mid := make([]byte, n)
+	for i := 0; i < n; i++ {
+		v := carry<<8 | int(sum[i])
+		mid[i] = byte(v >> 1)
+		carry = v & 1
+	}
+	mid = bytes.TrimRight(mid, "\x00")
+	if bytes.Compare(mid, startKey) <= 0 || (len(endKey) > 0 && bytes.Compare(mid, endKey) >= 0) {
+		return nil
+	}
+	return mid
+}
+
+// loadRegionsInRange loads the regions in [startKey, endKey) from PD into the region cache page by page, and
+// calls fn with each of them in key order. An empty endKey means unbounded.
+func (s *KVStore) loadRegionsInRange(bo *Backoffer, startKey, endKey []byte, fn func(*locate.Region)) error {
+	key := startKey
+	for {
+		regions, err := s.GetRegionCache().BatchLoadRegionsWithKeyRange(bo, key, endKey, defaultRegionsPerTask)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, r := range regions {
+			fn(r)
+		}
+		key = regions[len(regions)-1].EndKey()
+		if len(key) == 0 || (len(endKey) > 0 && bytes.Compare(key, endKey) >= 0) {
+			return nil
+		}
+	}
+}
+
+func (s *KVStore) scatterRegion(bo *Backoffer, regionID uint64, tableID *int64, opts *splitOptions) error {
+	s.ctxLogger(bo.GetCtx()).Info("start scatter region",
 		zap.Uint64("regionID", regionID))
+	if _, err := s.sendScatterRegions(bo, []uint64{regionID}, tableID, opts); err != nil {
+		return err
+	}
+	s.ctxLogger(bo.GetCtx()).Debug("scatter region complete",
+		zap.Uint64("regionID", regionID))
+	return nil
+}
+
+// sendScatterRegions scatters the regions in one PD request, it retries until the request succeeds, the error is
+// fatal, or the backoff is used up.
+func (s *KVStore) sendScatterRegions(bo *Backoffer, regionIDs []uint64, tableID *int64, opts *splitOptions) (*pdpb.ScatterRegionResponse, error) {
+	pdOpts := opts.scatter.toRegionsOptions(tableID)
 	for {
-		opts := make([]pd.RegionsOption, 0, 1)
-		if tableID != nil {
-			opts = append(opts, pd.WithGroup(fmt.Sprintf("%v", *tableID)))
+		// The backoff doesn't fail until it sleeps up, check the context so that a canceled split stops
+		// scattering promptly.
+		select {
+		case <-bo.GetCtx().Done():
+			return nil, errors.Trace(bo.GetCtx().Err())
+		default:
+		}
+		resp, err := s.splitGCPDClient.ScatterRegions(bo.GetCtx(), regionIDs, pdOpts...)
+		if err == nil && resp.GetHeader().GetError() != nil {
+			err = &tikverr.PDError{Err: resp.GetHeader().GetError()}
 		}
-		_, err := s.pdClient.ScatterRegions(bo.GetCtx(), []uint64{regionID}, opts...)
 
 		if val, err2 := util.EvalFailpoint("mockScatterRegionTimeout"); err2 == nil {
 			if val.(bool) {
 				err = tikverr.NewErrPDServerTimeout("")
 			}
 		}
+		if s.hooks.scatterErr != nil {
+			for _, regionID := range regionIDs {
+				if err2 := s.hooks.scatterErr(regionID); err2 != nil {
+					err = err2
+					break
+				}
+			}
+		}
 
 		if err == nil {
-			break
+			return resp, nil
 		}
-		err = bo.Backoff(retry.BoPDRPC, errors.New(err.Error()))
+		if isFatalScatterErr(err) {
+			s.ctxLogger(bo.GetCtx()).Warn("scatter region failed with non-retryable error",
+				zap.Uint64s("regionIDs", regionIDs),
+				zap.Error(err))
+			return nil, errors.Trace(err)
+		}
+		err = bo.Backoff(opts.scatterBackoff, errors.New(err.Error()))
 		if err != nil {
-			return errors.Trace(err)
+			if ctxErr := bo.GetCtx().Err(); ctxErr != nil {
+				return nil, errors.Trace(ctxErr)
+			}
+			return nil, errors.Trace(err)
 		}
 	}
-	logutil.BgLogger().Debug("scatter region complete",
-		zap.Uint64("regionID", regionID))
-	return nil
+}
+
+// ScatterRegions scatters the regions in one PD request, and returns the result of each region, which is nil if the
+// region is scattered. PD of this version reports only the percentage of the scattered regions rather than which
+// regions fail, so if some regions aren't scattered, e.g. one of them is merged, the regions are scattered one by
+// one to find out the results. The returned error is not nil if PD keeps failing or ctx is done, in which case the
+// results hold only the regions whose results are known, which is none if the batch request fails. The results
+// are nil only if the options are invalid. The scatter options, e.g. WithScatterOptions, are applied, the others are ignored.
+func (s *KVStore) ScatterRegions(ctx context.Context, regionIDs []uint64, opts ...SplitOption) (regionErrs map[uint64]error, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() { err = tikverr.WithRegionOperation(err, "ScatterRegions", regionIDs...) }()
+	splitOpts := newSplitOptions(opts)
+	if err := splitOpts.validate(); err != nil {
+		return nil, err
+	}
+	ctx = s.withOperationID(ctx)
+	results := make(map[uint64]error, len(regionIDs))
+	if len(regionIDs) == 0 {
+		return results, nil
+	}
+	bo := retry.NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+	resp, err := s.sendScatterRegions(bo, regionIDs, nil, splitOpts)
+	if err == nil && !isScatterPartial(resp) {
+		for _, regionID := range regionIDs {
+			results[regionID] = nil
+		}
+		return results, nil
+	}
+	if err != nil && !isFatalScatterErr(err) {
+		// No region is known to be scattered.
+		return results, err
+	}
+	s.ctxLogger(ctx).Info("scatter regions partially failed, scatter them one by one",
+		zap.Int("region count", len(regionIDs)),
+		zap.Uint64("finished percentage", resp.GetFinishedPercentage()),
+		zap.Error(err))
+	for _, regionID := range regionIDs {
+		if err := ctx.Err(); err != nil {
+			return results, errors.Trace(err)
+		}
+		results[regionID] = s.scatterRegion(bo, regionID, nil, splitOpts)
+	}
+	return results, nil
+}
+
+// ScatterRegionsWithStatus scatters each region by its own PD request, at most as many as the split concurrency at a
+// time, and returns the result of each region, which is nil if the region is scattered, so the callers can retry
+// only the failed ones. Unlike ScatterRegions, it costs a PD request per region, but the results are accurate.
+// If ctx is done, the regions not scattered yet are given up with the error of ctx, which is returned as well.
+// The scatter options, e.g. WithScatterOptions, are applied, the others are ignored.
+func (s *KVStore) ScatterRegionsWithStatus(ctx context.Context, regionIDs []uint64, tableID *int64, opts ...SplitOption) (regionErrs map[uint64]error, err error) {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	defer func() { err = tikverr.WithRegionOperation(err, "ScatterRegionsWithStatus", regionIDs...) }()
+	splitOpts := newSplitOptions(opts)
+	if err := splitOpts.validate(); err != nil {
+		return nil, err
+	}
+	ctx = s.withOperationID(ctx)
+
+	results := make(map[uint64]error, len(regionIDs))
+	concurrency := splitOpts.concurrency
+	if concurrency > len(regionIDs) {
+		concurrency = len(regionIDs)
+	}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	idCh := make(chan uint64)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for regionID := range idCh {
+				bo := retry.NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+				err := s.scatterRegion(bo, regionID, tableID, splitOpts)
+				if err != nil && s.eventSink != nil {
+					s.eventSink.OnScatterFailed(regionID, err)
+				}
+				mu.Lock()
+				results[regionID] = err
+				mu.Unlock()
+			}
+		}()
+	}
+Loop:
+	for _, regionID := range regionIDs {
+		select {
+		case idCh <- regionID:
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+	close(idCh)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		for _, regionID := range regionIDs {
+			if _, ok := results[regionID]; !ok {
+				results[regionID] = errors.Trace(err)
+			}
+		}
+		return results, errors.Trace(err)
+	}
+	return results, nil
+}
+
+// isScatterPartial checks whether the ScatterRegions response reports that some regions aren't scattered. The
+// responses without header, e.g. of the mock PD clients, are considered complete.
+func isScatterPartial(resp *pdpb.ScatterRegionResponse) bool {
+	return resp.GetHeader() != nil && resp.GetFinishedPercentage() < 100
+}
+
+// isFatalScatterErr checks whether retrying the scatter request can't succeed, e.g. the region is gone after a
+// merge, so the scatter should fail fast instead of using up the backoff. Other PD errors, including the timeout,
+// are retried.
+func isFatalScatterErr(err error) bool {
+	return tikverr.IsPDRegionNotFound(err)
+}
+
+// SetPreSplitWaitScatter sets whether 2PC waits for the regions to be scattered after pre-splitting a region
+// with a large amount of mutations. It waits by default, so the prewrite is spread across the stores, but the
+// commit is blocked until the scatter finishes. If it's disabled, the new regions are still scattered by PD in
+// the background, and the commit goes on at once, at the cost of writing to the temporarily unbalanced regions,
+// which are probably on the same store as the original region.
+func (s *KVStore) SetPreSplitWaitScatter(wait bool) {
+	if wait {
+		atomic.StoreUint32(&s.preSplitNoWaitScatter, 0)
+	} else {
+		atomic.StoreUint32(&s.preSplitNoWaitScatter, 1)
+	}
+}
+
+// defaultPreSplitScatterWaitTimeout is much shorter than waitScatterRegionFinishBackoff, since the commit is
+// blocked by the wait and a region not scattered yet is still usable.
+const defaultPreSplitScatterWaitTimeout = 10000
+
+// SetPreSplitScatterWaitTimeout sets the max total time 2PC waits for the regions to be scattered after
+// pre-splitting a region, so a slow scatter can't stall the transaction for long. The default is 10 seconds.
+func (s *KVStore) SetPreSplitScatterWaitTimeout(timeout time.Duration) error {
+	return setBackoffVar(&s.preSplitScatterWaitTimeout, "pre-split scatter wait timeout", timeout)
+}
+
+func (s *KVStore) getPreSplitScatterWaitTimeout() time.Duration {
+	timeout := atomic.LoadInt64(&s.preSplitScatterWaitTimeout)
+	if timeout == 0 {
+		timeout = defaultPreSplitScatterWaitTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
 }
 
 func (s *KVStore) preSplitRegion(ctx context.Context, group groupedMutations) bool {
+	ctx = s.withOperationID(ctx)
 	splitKeys := make([][]byte, 0, 4)
 
 	preSplitSizeThresholdVal := atomic.LoadUint32(&preSplitSizeThreshold)
@@ -282,17 +1799,37 @@ func (s *KVStore) preSplitRegion(ctx context.Context, group groupedMutations) bo
 		return false
 	}
 
-	regionIDs, err := s.SplitRegions(ctx, splitKeys, true, nil)
+	// The mutation keys are already encoded, don't prefix them again.
+	regionIDs, err := s.splitRegions(ctx, splitKeys, true, nil, newSplitOptions(nil))
 	if err != nil {
-		logutil.BgLogger().Warn("2PC split regions failed", zap.Uint64("regionID", group.region.GetID()),
+		s.ctxLogger(ctx).Warn("2PC split regions failed", zap.Uint64("regionID", group.region.GetID()),
 			zap.Int("keys count", keysLength), zap.Error(err))
 		return false
 	}
 
+	if atomic.LoadUint32(&s.preSplitNoWaitScatter) == 1 {
+		s.ctxLogger(ctx).Info("2PC pre-split regions without waiting for scatter",
+			zap.Uint64("regionID", group.region.GetID()),
+			zap.Int("region count", len(regionIDs)))
+		regionIDs = nil
+	}
+	// The timeout bounds the wait of all regions, so a slow scatter can't block the pre-split for long.
+	waitTimeout := s.getPreSplitScatterWaitTimeout()
+	waitCtx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
 	for _, regionID := range regionIDs {
-		err := s.WaitScatterRegionFinish(ctx, regionID, 0)
+		err := s.WaitScatterRegionFinish(waitCtx, regionID, int(waitTimeout/time.Millisecond))
 		if err != nil {
-			logutil.BgLogger().Warn("2PC wait scatter region failed", zap.Uint64("regionID", regionID), zap.Error(err))
+			s.ctxLogger(ctx).Warn("2PC wait scatter region failed", zap.Uint64("regionID", regionID), zap.Error(err))
+		}
+		// The 2PC is giving up or the wait times out, don't poll the remaining regions.
+		if waitCtx.Err() != nil {
+			if ctx.Err() == nil {
+				s.ctxLogger(ctx).Warn("2PC wait scatter regions timeout, skip the remaining regions",
+					zap.Uint64("regionID", group.region.GetID()),
+					zap.Duration("timeout", waitTimeout))
+			}
+			break
 		}
 	}
 	// Invalidate the old region cache information.
@@ -300,38 +1837,168 @@ func (s *KVStore) preSplitRegion(ctx context.Context, group groupedMutations) bo
 	return true
 }
 
-const waitScatterRegionFinishBackoff = 120000
+// WaitScatterOption configures the behavior of WaitScatterRegionFinishWithOptions.
+type WaitScatterOption func(*waitScatterOptions)
+
+type waitScatterOptions struct {
+	// maxInterval is the max sleep time between two polls, a negative value means no limit.
+	maxInterval time.Duration
+	// minInterval is the min time between the starts of two polls, 0 means no limit.
+	minInterval time.Duration
+	// cancelCh aborts the wait once it's closed, nil means the wait can only be canceled by the context.
+	cancelCh <-chan struct{}
+}
+
+func newWaitScatterOptions(opts []WaitScatterOption) *waitScatterOptions {
+	o := &waitScatterOptions{
+		maxInterval: -1,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *waitScatterOptions) validate() error {
+	if o.maxInterval >= 0 && o.maxInterval < time.Millisecond {
+		return errors.Errorf("scatter wait max interval should be at least 1ms, got %v", o.maxInterval)
+	}
+	return nil
+}
+
+// maxSleepMs returns the max sleep time(in ms) between two polls, -1 means no limit.
+func (o *waitScatterOptions) maxSleepMs() int {
+	if o.maxInterval < 0 {
+		return -1
+	}
+	return int(o.maxInterval / time.Millisecond)
+}
+
+// canceled checks whether the cancel channel is closed.
+func (o *waitScatterOptions) canceled() bool {
+	select {
+	case <-o.cancelCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// WithScatterWaitMaxInterval caps the interval between two polls of the scatter operator, so the wait
+// stays responsive late in a long wait instead of letting the exponential backoff grow the interval.
+// A negative interval means no cap, otherwise it should be at least 1ms, or the wait fails without polling.
+func WithScatterWaitMaxInterval(interval time.Duration) WaitScatterOption {
+	return func(o *waitScatterOptions) {
+		o.maxInterval = interval
+	}
+}
+
+// WithScatterWaitMinInterval makes the wait poll the scatter operator at most once per interval, by sleeping out
+// the rest of the interval if the backoff is shorter, which reduces the load of PD when waiting for many regions.
+// The extra sleep isn't counted into the backoff time of the wait, so the wait may take longer than it, but not
+// longer than the deadline of the context. It takes precedence over WithScatterWaitMaxInterval.
+func WithScatterWaitMinInterval(interval time.Duration) WaitScatterOption {
+	return func(o *waitScatterOptions) {
+		o.minInterval = interval
+	}
+}
+
+// WithScatterWaitCancel makes the wait abort with context.Canceled once cancelCh is closed. The channel can be
+// shared by the waits of many regions, so the caller can stop all of them at once by closing it, e.g. when the
+// enclosing operation has given up, without canceling the context shared with other work.
+func WithScatterWaitCancel(cancelCh <-chan struct{}) WaitScatterOption {
+	return func(o *waitScatterOptions) {
+		o.cancelCh = cancelCh
+	}
+}
 
 // WaitScatterRegionFinish implements SplittableStore interface.
 // backOff is the back off time of the wait scatter region.(Milliseconds)
 // if backOff <= 0, the default wait scatter back off time will be used.
+// The error PD reports for the operator is returned as a *tikverr.PDError, use tikverr.PDErrorHeader,
+// tikverr.IsPDRegionNotFound or tikverr.IsPDLeaderChange to inspect it.
+// If the wait runs out of time, a *tikverr.ErrScatterWaitTimeout is returned if the region is still being
+// scattered, which the caller may ignore since PD keeps scattering it, or the last error of querying PD if PD
+// keeps failing.
 func (s *KVStore) WaitScatterRegionFinish(ctx context.Context, regionID uint64, backOff int) error {
+	return s.WaitScatterRegionFinishWithOptions(ctx, regionID, backOff)
+}
+
+// WaitScatterRegionFinishWithOptions waits until the scatter operator of the region finishes like
+// WaitScatterRegionFinish, with the wait options applied.
+func (s *KVStore) WaitScatterRegionFinishWithOptions(ctx context.Context, regionID uint64, backOff int, opts ...WaitScatterOption) error {
+	ctx, done := s.startOp(ctx)
+	defer done()
+	err := s.waitScatterRegionFinish(ctx, regionID, backOff, opts)
+	return tikverr.WithRegionOperation(err, "WaitScatterRegionFinish", regionID)
+}
+
+// waitScatterRegionFinish is WaitScatterRegionFinishWithOptions without registering the operation or attaching it
+// to the error, so the operations built on it attach only their own.
+func (s *KVStore) waitScatterRegionFinish(ctx context.Context, regionID uint64, backOff int, opts []WaitScatterOption) (err error) {
 	if backOff <= 0 {
-		backOff = waitScatterRegionFinishBackoff
+		backOff = int(atomic.LoadInt64(&waitScatterRegionFinishBackoff))
+	}
+	waitOpts := newWaitScatterOptions(opts)
+	if err = waitOpts.validate(); err != nil {
+		return err
 	}
-	logutil.BgLogger().Info("wait scatter region",
+	ctx = s.withOperationID(ctx)
+	s.ctxLogger(ctx).Info("wait scatter region",
 		zap.Uint64("regionID", regionID), zap.Int("backoff(ms)", backOff))
 
+	if waitOpts.cancelCh != nil {
+		// Cancel the context once the cancel channel is closed, so the backoff sleep is interrupted too.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-waitOpts.cancelCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
 	bo := retry.NewBackofferWithVars(ctx, backOff, nil)
 	logFreq := 0
+	// lastPDErr is the error of the last query to PD, it's nil if the last query succeeds.
+	var lastPDErr error
 	for {
-		resp, err := s.pdClient.GetOperator(ctx, regionID)
-		if err == nil && resp != nil {
-			if !bytes.Equal(resp.Desc, []byte("scatter-region")) || resp.Status != pdpb.OperatorStatus_RUNNING {
-				logutil.BgLogger().Info("wait scatter region finished",
+		if waitOpts.canceled() {
+			s.ctxLogger(ctx).Info("wait scatter region canceled",
+				zap.Uint64("regionID", regionID))
+			metrics.WaitScatterRegionCounterCanceled.Inc()
+			return errors.Trace(context.Canceled)
+		}
+		pollStart := time.Now()
+		resp, err := s.splitGCPDClient.GetOperator(ctx, regionID)
+		lastPDErr = err
+		if err == nil && isOperatorNotFound(resp) {
+			// The scatter operator has finished and been removed, or it never existed.
+			s.ctxLogger(ctx).Info("wait scatter region finished, no operator found",
+				zap.Uint64("regionID", regionID))
+			metrics.WaitScatterRegionCounterFinished.Inc()
+			return nil
+		}
+		if err == nil {
+			if !isScatterRunning(resp) {
+				s.ctxLogger(ctx).Info("wait scatter region finished",
 					zap.Uint64("regionID", regionID))
+				metrics.WaitScatterRegionCounterFinished.Inc()
 				return nil
 			}
 			if resp.GetHeader().GetError() != nil {
 				err = errors.AddStack(&tikverr.PDError{
 					Err: resp.Header.Error,
 				})
-				logutil.BgLogger().Warn("wait scatter region error",
+				s.ctxLogger(ctx).Warn("wait scatter region error",
 					zap.Uint64("regionID", regionID), zap.Error(err))
+				metrics.WaitScatterRegionCounterError.Inc()
 				return err
 			}
 			if logFreq%10 == 0 {
-				logutil.BgLogger().Info("wait scatter region",
+				s.ctxLogger(ctx).Info("wait scatter region",
 					zap.Uint64("regionID", regionID),
 					zap.String("reverse", string(resp.Desc)),
 					zap.String("status", pdpb.OperatorStatus_name[int32(resp.Status)]))
@@ -339,33 +2006,123 @@ func (s *KVStore) WaitScatterRegionFinish(ctx context.Context, regionID uint64,
 			logFreq++
 		}
 		if err != nil {
-			err = bo.Backoff(retry.BoRegionMiss, errors.New(err.Error()))
+			err = bo.BackoffWithCfgAndMaxSleep(retry.BoRegionMiss, waitOpts.maxSleepMs(), errors.New(err.Error()))
 		} else {
-			err = bo.Backoff(retry.BoRegionMiss, errors.New("wait scatter region timeout"))
+			err = bo.BackoffWithCfgAndMaxSleep(retry.BoRegionMiss, waitOpts.maxSleepMs(), errors.New("wait scatter region timeout"))
 		}
 		if err != nil {
-			return errors.Trace(err)
+			if waitOpts.canceled() {
+				metrics.WaitScatterRegionCounterCanceled.Inc()
+				return errors.Trace(context.Canceled)
+			}
+			if ctx.Err() == context.Canceled {
+				metrics.WaitScatterRegionCounterCanceled.Inc()
+				return errors.Trace(err)
+			}
+			// The backoff runs out, or the deadline of the context is exceeded.
+			metrics.WaitScatterRegionCounterTimeout.Inc()
+			// The query fails with the context if the deadline is exceeded during it, which is a timeout as well.
+			if lastPDErr != nil && ctx.Err() == nil {
+				return errors.Trace(lastPDErr)
+			}
+			return errors.Trace(&tikverr.ErrScatterWaitTimeout{RegionID: regionID, Backoff: backOff})
+		}
+		if wait := waitOpts.minInterval - time.Since(pollStart); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				// Don't poll again with the done context.
+				if ctx.Err() == context.Canceled {
+					metrics.WaitScatterRegionCounterCanceled.Inc()
+					return errors.Trace(ctx.Err())
+				}
+				metrics.WaitScatterRegionCounterTimeout.Inc()
+				return errors.Trace(&tikverr.ErrScatterWaitTimeout{RegionID: regionID, Backoff: backOff})
+			}
 		}
 	}
 }
 
-// CheckRegionInScattering uses to check whether scatter region finished.
+// isOperatorNotFound checks whether the GetOperator response means there is no operator on the region.
+// PD removes the operator after it finishes, so the region has no operator or an operator without desc.
+func isOperatorNotFound(resp *pdpb.GetOperatorResponse) bool {
+	return resp == nil || len(resp.GetDesc()) == 0
+}
+
+// CheckRegionInScattering implements SplittableStore interface.
+// It uses to check whether scatter region finished.
+// It's CheckRegionInScatteringWithTimeout with the default timeout of locating a region.
 func (s *KVStore) CheckRegionInScattering(regionID uint64) (bool, error) {
-	bo := retry.NewBackofferWithVars(context.Background(), locateRegionMaxBackoff, nil)
+	return s.CheckRegionInScatteringWithTimeout(regionID, time.Duration(locateRegionMaxBackoff)*time.Millisecond)
+}
+
+// CheckRegionInScatteringWithTimeout checks whether the region is still being scattered like
+// CheckRegionInScattering, but gives up retrying PD after timeout, which suits the health checks that need to
+// return quickly. It returns true and a *tikverr.ErrCheckScatterTimeout if PD doesn't answer in time.
+func (s *KVStore) CheckRegionInScatteringWithTimeout(regionID uint64, timeout time.Duration) (inScattering bool, err error) {
+	defer func() { err = tikverr.WithRegionOperation(err, "CheckRegionInScattering", regionID) }()
+	if timeout <= 0 {
+		return true, errors.Errorf("check scatter timeout should be positive, got %v", timeout)
+	}
+	ctx, done := s.startOp(context.Background())
+	defer done()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var status *ScatterStatus
+	status, err = s.getScatterStatus(ctx, regionID, int(timeout.Milliseconds()))
+	if err != nil {
+		return true, errors.Trace(&tikverr.ErrCheckScatterTimeout{RegionID: regionID, Timeout: timeout, Err: err})
+	}
+	return status.Scattering, nil
+}
+
+// isScatterRunning checks whether the GetOperator response is a running scatter operator.
+func isScatterRunning(resp *pdpb.GetOperatorResponse) bool {
+	return !isOperatorNotFound(resp) && bytes.Equal(resp.Desc, []byte("scatter-region")) && resp.Status == pdpb.OperatorStatus_RUNNING
+}
+
+// ScatterStatus is the status of the operator on a region reported by PD.
+type ScatterStatus struct {
+	// HasOperator indicates whether the region has an operator, Desc and Status are meaningless if it's false.
+	HasOperator bool
+	// Desc is the description of the operator, it's "scatter-region" for the scatter operator.
+	Desc string
+	// Status is the status of the operator.
+	Status pdpb.OperatorStatus
+	// Scattering indicates whether the region is still being scattered, i.e. it has a running scatter operator.
+	Scattering bool
+}
+
+// GetScatterStatus returns the status of the operator on the region, so the callers don't need to query and
+// parse the operator from PD themselves. PD doesn't report the progress of the operator.
+// It retries on PD errors like CheckRegionInScattering.
+func (s *KVStore) GetScatterStatus(regionID uint64) (*ScatterStatus, error) {
+	ctx, done := s.startOp(context.Background())
+	defer done()
+	status, err := s.getScatterStatus(ctx, regionID, locateRegionMaxBackoff)
+	return status, tikverr.WithRegionOperation(err, "GetScatterStatus", regionID)
+}
+
+// getScatterStatus returns the status of the operator on the region, it retries on PD errors until ctx is done or
+// the total backoff exceeds maxBackoff(in ms).
+func (s *KVStore) getScatterStatus(ctx context.Context, regionID uint64, maxBackoff int) (*ScatterStatus, error) {
+	bo := retry.NewBackofferWithVars(ctx, maxBackoff, nil)
 	for {
-		resp, err := s.pdClient.GetOperator(context.Background(), regionID)
-		if err == nil && resp != nil {
-			if !bytes.Equal(resp.Desc, []byte("scatter-region")) || resp.Status != pdpb.OperatorStatus_RUNNING {
-				return false, nil
+		resp, err := s.splitGCPDClient.GetOperator(ctx, regionID)
+		if err == nil {
+			if isOperatorNotFound(resp) {
+				return &ScatterStatus{}, nil
 			}
+			return &ScatterStatus{
+				HasOperator: true,
+				Desc:        string(resp.GetDesc()),
+				Status:      resp.GetStatus(),
+				Scattering:  isScatterRunning(resp),
+			}, nil
 		}
+		err = bo.Backoff(retry.BoRegionMiss, errors.New(err.Error()))
 		if err != nil {
-			err = bo.Backoff(retry.BoRegionMiss, errors.New(err.Error()))
-		} else {
-			return true, nil
-		}
-		if err != nil {
-			return true, errors.Trace(err)
+			return nil, errors.Trace(err)
 		}
 	}
 }
@@ -0,0 +1,1700 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/retry"
+	"github.com/tikv/client-go/v2/tikv/testutil"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/goleak"
+)
+
+// mockScatterPDClient wraps a pd.Client and overrides the scatter related methods.
+type mockScatterPDClient struct {
+	pd.Client
+
+	mu sync.Mutex
+	// getOperator is used to mock GetOperator if it's not nil.
+	getOperator func(regionID uint64) (*pdpb.GetOperatorResponse, error)
+	// getOperatorTimes records the time of each GetOperator call.
+	getOperatorTimes []time.Time
+	// scatterRegions is used to mock ScatterRegions if it's not nil.
+	scatterRegions func(regionIDs []uint64) (*pdpb.ScatterRegionResponse, error)
+	// scatterTimes is the number of ScatterRegions calls.
+	scatterTimes int
+}
+
+func (c *mockScatterPDClient) ScatterRegions(ctx context.Context, regionIDs []uint64, opts ...pd.RegionsOption) (*pdpb.ScatterRegionResponse, error) {
+	c.mu.Lock()
+	c.scatterTimes++
+	c.mu.Unlock()
+	if c.scatterRegions != nil {
+		return c.scatterRegions(regionIDs)
+	}
+	return c.Client.ScatterRegions(ctx, regionIDs, opts...)
+}
+
+func (c *mockScatterPDClient) GetOperator(ctx context.Context, regionID uint64) (*pdpb.GetOperatorResponse, error) {
+	c.mu.Lock()
+	c.getOperatorTimes = append(c.getOperatorTimes, time.Now())
+	c.mu.Unlock()
+	if c.getOperator != nil {
+		return c.getOperator(regionID)
+	}
+	return c.Client.GetOperator(ctx, regionID)
+}
+
+func runningScatterOperator(uint64) (*pdpb.GetOperatorResponse, error) {
+	return &pdpb.GetOperatorResponse{
+		Header: &pdpb.ResponseHeader{},
+		Desc:   []byte("scatter-region"),
+		Status: pdpb.OperatorStatus_RUNNING,
+	}, nil
+}
+
+func TestSplitOptions(t *testing.T) {
+	opts := newSplitOptions(nil)
+	assert.Nil(t, opts.validate())
+	assert.Equal(t, ReadTimeoutShort, opts.reqTimeout)
+	assert.Equal(t, retry.BoPDRPC, opts.scatterBackoff)
+
+	opts = newSplitOptions([]SplitOption{WithSplitRequestTimeout(ReadTimeoutMedium)})
+	assert.Nil(t, opts.validate())
+	assert.Equal(t, ReadTimeoutMedium, opts.reqTimeout)
+
+	opts = newSplitOptions([]SplitOption{WithSplitRequestTimeout(-1)})
+	assert.NotNil(t, opts.validate())
+
+	opts = newSplitOptions([]SplitOption{WithSplitConcurrency(4)})
+	assert.Nil(t, opts.validate())
+	assert.Equal(t, 4, opts.concurrency)
+
+	opts = newSplitOptions([]SplitOption{WithSplitConcurrency(0)})
+	assert.NotNil(t, opts.validate())
+
+	opts = newSplitOptions([]SplitOption{WithScatterMinHealthyStores(-1)})
+	assert.NotNil(t, opts.validate())
+}
+
+func TestWaitScatterRegionFinishMaxInterval(t *testing.T) {
+	mockPD := &mockScatterPDClient{getOperator: runningScatterOperator}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	maxInterval := 10 * time.Millisecond
+	err := store.WaitScatterRegionFinishWithOptions(context.Background(), 1, 300, WithScatterWaitMaxInterval(maxInterval))
+	assert.NotNil(t, err)
+
+	mockPD.mu.Lock()
+	defer mockPD.mu.Unlock()
+	assert.Greater(t, len(mockPD.getOperatorTimes), 10)
+	for i := 1; i < len(mockPD.getOperatorTimes); i++ {
+		// Leave some room for the scheduling delay.
+		assert.Less(t, int64(mockPD.getOperatorTimes[i].Sub(mockPD.getOperatorTimes[i-1])), int64(maxInterval+40*time.Millisecond))
+	}
+}
+
+func TestWaitScatterRegionFinishInvalidMaxInterval(t *testing.T) {
+	mockPD := &mockScatterPDClient{getOperator: runningScatterOperator}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	for _, interval := range []time.Duration{0, time.Microsecond} {
+		err := store.WaitScatterRegionFinishWithOptions(context.Background(), 1, 300, WithScatterWaitMaxInterval(interval))
+		assert.NotNil(t, err)
+	}
+	mockPD.mu.Lock()
+	assert.Empty(t, mockPD.getOperatorTimes)
+	mockPD.mu.Unlock()
+
+	assert.Equal(t, -1, newWaitScatterOptions(nil).maxSleepMs())
+	assert.Nil(t, newWaitScatterOptions(nil).validate())
+	assert.Equal(t, 10, newWaitScatterOptions([]WaitScatterOption{WithScatterWaitMaxInterval(10 * time.Millisecond)}).maxSleepMs())
+}
+
+func TestIsNonRetryableSplitErr(t *testing.T) {
+	assert.True(t, isNonRetryableSplitErr(errors.Trace(tikverr.NewErrPDServerTimeout(""))))
+	assert.True(t, isNonRetryableSplitErr(errors.Trace(context.Canceled)))
+	assert.True(t, isNonRetryableSplitErr(context.DeadlineExceeded))
+	assert.False(t, isNonRetryableSplitErr(errors.Trace(tikverr.ErrRegionUnavailable)))
+}
+
+func TestScatterOptions(t *testing.T) {
+	tableID := int64(42)
+	opts := ScatterOptions{}
+	assert.Len(t, opts.toRegionsOptions(nil), 0)
+	assert.Len(t, opts.toRegionsOptions(&tableID), 1)
+
+	opts = ScatterOptions{Group: "g", RetryLimit: 3}
+	assert.Len(t, opts.toRegionsOptions(&tableID), 2)
+}
+
+func TestScatterSkippedRegionCounter(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+
+	readSkipped := func() float64 {
+		pb := &dto.Metric{}
+		assert.Nil(t, metrics.TiKVScatterSkippedRegionCounter.Write(pb))
+		return pb.GetCounter().GetValue()
+	}
+
+	before := readSkipped()
+	regionIDs, err := store.SplitRegions(context.Background(), [][]byte{[]byte("b"), []byte("c"), []byte("d")}, true, nil)
+	assert.Nil(t, err)
+	// The keys are split in one batch, which produces 4 regions and scatters 3 of them.
+	assert.Len(t, regionIDs, 3)
+	assert.Equal(t, before+1, readSkipped())
+}
+
+// getRegionCountPDClient counts the GetRegion calls, which are the region cache misses of locating keys.
+type getRegionCountPDClient struct {
+	pd.Client
+	getRegions int32
+}
+
+func (c *getRegionCountPDClient) GetRegion(ctx context.Context, key []byte) (*pd.Region, error) {
+	atomic.AddInt32(&c.getRegions, 1)
+	return c.Client.GetRegion(ctx, key)
+}
+
+func TestWarmupRegionCache(t *testing.T) {
+	var pdCli *getRegionCountPDClient
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		pdCli = &getRegionCountPDClient{Client: c}
+		return pdCli
+	}, []byte("b"), []byte("d"), []byte("f"))
+	defer store.Close()
+
+	count, err := store.WarmupRegionCache(context.Background(), []byte("a"), []byte("e"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	// The keys in the range are located without asking PD.
+	atomic.StoreInt32(&pdCli.getRegions, 0)
+	_, err = store.LocateKeys(NewBulkOpBackoffer(context.Background(), 0), [][]byte{[]byte("a"), []byte("c"), []byte("d1")})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&pdCli.getRegions))
+
+	count, err = store.WarmupRegionCache(context.Background(), nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+	_, err = store.WarmupRegionCache(context.Background(), []byte("e"), []byte("a"))
+	assert.NotNil(t, err)
+}
+
+func TestEstimateRegionCount(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("b"), []byte("c"))
+	defer store.Close()
+
+	ctx := context.Background()
+	for _, c := range []struct {
+		startKey, endKey string
+		count            int
+	}{
+		{"", "", 3},
+		{"a", "z", 3},
+		{"a", "b", 1},
+		{"a", "b\x00", 2},
+		{"b", "c", 1},
+		{"c", "", 1},
+	} {
+		count, err := store.EstimateRegionCount(ctx, []byte(c.startKey), []byte(c.endKey))
+		assert.Nil(t, err)
+		assert.Equal(t, c.count, count, "range [%q, %q)", c.startKey, c.endKey)
+	}
+
+	_, err := store.EstimateRegionCount(ctx, []byte("c"), []byte("b"))
+	opErr, ok := tikverr.OperationOf(err)
+	require.True(t, ok)
+	assert.Equal(t, &tikverr.ErrOperation{Op: "EstimateRegionCount", StartKey: []byte("c"), EndKey: []byte("b"), Err: opErr.Err}, opErr)
+	_, err = store.EstimateRegionCount(ctx, []byte("b"), []byte("b"))
+	assert.NotNil(t, err)
+}
+
+func TestWaitScatterRegionFinishNoOperator(t *testing.T) {
+	for _, getOperator := range []func(uint64) (*pdpb.GetOperatorResponse, error){
+		func(uint64) (*pdpb.GetOperatorResponse, error) { return nil, nil },
+		func(uint64) (*pdpb.GetOperatorResponse, error) { return &pdpb.GetOperatorResponse{}, nil },
+	} {
+		mockPD := &mockScatterPDClient{getOperator: getOperator}
+		store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+			mockPD.Client = c
+			return mockPD
+		})
+
+		start := time.Now()
+		assert.Nil(t, store.WaitScatterRegionFinish(context.Background(), 1, 0))
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
+		assert.Len(t, mockPD.getOperatorTimes, 1)
+
+		inScattering, err := store.CheckRegionInScattering(1)
+		assert.Nil(t, err)
+		assert.False(t, inScattering)
+		store.Close()
+	}
+}
+
+func TestWaitScatterRegionFinishCancel(t *testing.T) {
+	mockPD := &mockScatterPDClient{getOperator: runningScatterOperator}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	cancelCh := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = store.WaitScatterRegionFinishWithOptions(context.Background(), uint64(i+1), 0, WithScatterWaitCancel(cancelCh))
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	close(cancelCh)
+	wg.Wait()
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	for _, err := range errs {
+		assert.Equal(t, context.Canceled, errors.Cause(err))
+	}
+}
+
+func TestWaitScatterRegionCounter(t *testing.T) {
+	mockPD := &mockScatterPDClient{}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	read := func(c prometheus.Counter) float64 {
+		pb := &dto.Metric{}
+		assert.Nil(t, c.Write(pb))
+		return pb.GetCounter().GetValue()
+	}
+	check := func(c prometheus.Counter, expectErr bool, wait func() error) {
+		before := read(c)
+		assert.Equal(t, expectErr, wait() != nil)
+		assert.Equal(t, before+1, read(c))
+	}
+
+	mockPD.getOperator = func(uint64) (*pdpb.GetOperatorResponse, error) { return nil, nil }
+	check(metrics.WaitScatterRegionCounterFinished, false, func() error {
+		return store.WaitScatterRegionFinish(context.Background(), 1, 0)
+	})
+
+	mockPD.getOperator = func(uint64) (*pdpb.GetOperatorResponse, error) {
+		return &pdpb.GetOperatorResponse{
+			Header: &pdpb.ResponseHeader{Error: &pdpb.Error{Type: pdpb.ErrorType_UNKNOWN}},
+			Desc:   []byte("scatter-region"),
+			Status: pdpb.OperatorStatus_RUNNING,
+		}, nil
+	}
+	check(metrics.WaitScatterRegionCounterError, true, func() error {
+		return store.WaitScatterRegionFinish(context.Background(), 1, 0)
+	})
+
+	mockPD.getOperator = runningScatterOperator
+	check(metrics.WaitScatterRegionCounterTimeout, true, func() error {
+		return store.WaitScatterRegionFinish(context.Background(), 1, 10)
+	})
+
+	cancelCh := make(chan struct{})
+	close(cancelCh)
+	check(metrics.WaitScatterRegionCounterCanceled, true, func() error {
+		return store.WaitScatterRegionFinishWithOptions(context.Background(), 1, 0, WithScatterWaitCancel(cancelCh))
+	})
+}
+
+func TestCheckRegionInScatteringWithTimeout(t *testing.T) {
+	mockPD := &mockScatterPDClient{getOperator: func(uint64) (*pdpb.GetOperatorResponse, error) {
+		return nil, errors.New("mock pd error")
+	}}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	start := time.Now()
+	inScattering, err := store.CheckRegionInScatteringWithTimeout(1, 200*time.Millisecond)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.True(t, inScattering)
+	timeoutErr, ok := errors.Cause(err).(*tikverr.ErrCheckScatterTimeout)
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), timeoutErr.RegionID)
+	assert.Equal(t, 200*time.Millisecond, timeoutErr.Timeout)
+
+	_, err = store.CheckRegionInScatteringWithTimeout(1, 0)
+	assert.NotNil(t, err)
+
+	mockPD.getOperator = runningScatterOperator
+	inScattering, err = store.CheckRegionInScatteringWithTimeout(1, time.Second)
+	assert.Nil(t, err)
+	assert.True(t, inScattering)
+}
+
+func TestGetScatterStatus(t *testing.T) {
+	mockPD := &mockScatterPDClient{getOperator: runningScatterOperator}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	status, err := store.GetScatterStatus(1)
+	assert.Nil(t, err)
+	assert.Equal(t, &ScatterStatus{HasOperator: true, Desc: "scatter-region", Status: pdpb.OperatorStatus_RUNNING, Scattering: true}, status)
+	inScattering, err := store.CheckRegionInScattering(1)
+	assert.Nil(t, err)
+	assert.True(t, inScattering)
+
+	mockPD.getOperator = func(uint64) (*pdpb.GetOperatorResponse, error) {
+		return &pdpb.GetOperatorResponse{Desc: []byte("scatter-region"), Status: pdpb.OperatorStatus_SUCCESS}, nil
+	}
+	status, err = store.GetScatterStatus(1)
+	assert.Nil(t, err)
+	assert.Equal(t, &ScatterStatus{HasOperator: true, Desc: "scatter-region", Status: pdpb.OperatorStatus_SUCCESS}, status)
+
+	mockPD.getOperator = func(uint64) (*pdpb.GetOperatorResponse, error) { return nil, nil }
+	status, err = store.GetScatterStatus(1)
+	assert.Nil(t, err)
+	assert.False(t, status.HasOperator)
+	assert.False(t, status.Scattering)
+}
+
+func TestSplitKeyNormalizer(t *testing.T) {
+	// Keep the first byte only.
+	normalizer := func(key []byte) []byte {
+		if len(key) > 1 {
+			return key[:1]
+		}
+		return key
+	}
+	keys := normalizeSplitKeys([][]byte{[]byte("b1"), []byte("b2"), []byte(""), []byte("c"), []byte("c3")}, normalizer)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, keys)
+
+	store, cluster := newTestKVStore(t, nil)
+	defer store.Close()
+	_, err := store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("b1"), []byte("b2")}, false, nil, WithSplitKeyNormalizer(normalizer))
+	assert.Nil(t, err)
+	region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte("b1")))
+	assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte("b"))), region.GetStartKey())
+	region, _ = cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte("a")))
+	assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte("b"))), region.GetEndKey())
+}
+
+// failSplitClient fails the split region requests containing failKey.
+type failSplitClient struct {
+	Client
+	failKey []byte
+}
+
+func (c *failSplitClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdSplitRegion {
+		for _, key := range req.SplitRegion().GetSplitKeys() {
+			if bytes.Equal(key, c.failKey) {
+				// The canceled error is not retried by the region request sender.
+				return nil, context.Canceled
+			}
+		}
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestSplitRegionsPartialFailure(t *testing.T) {
+	client, cluster, pdClient, err := mocktikv.NewTiKVAndPDClient("", nil)
+	assert.Nil(t, err)
+	mocktikv.BootstrapWithMultiRegions(cluster, []byte("c"))
+	store, err := NewTestTiKVStore(client, pdClient, func(c Client) Client {
+		return &failSplitClient{Client: c, failKey: []byte("d")}
+	}, nil, 0)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	// "b" and "d" are in different regions, so they're split in different batches.
+	regionIDs, err := store.SplitRegions(context.Background(), [][]byte{[]byte("b"), []byte("d")}, false, nil)
+	assert.NotNil(t, err)
+	// The region created by the successful batch is still returned.
+	assert.Len(t, regionIDs, 1)
+	region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte("b")))
+	assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte("b"))), region.GetStartKey())
+	region, _ = cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte("d")))
+	assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte("c"))), region.GetStartKey())
+}
+
+func TestSplitRegionsTracing(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+
+	// No span is created if there's no span in the context.
+	bo := retry.NewBackofferWithVars(context.Background(), 1000, nil)
+	span, finishSpan := startBackofferSpan(bo, "test")
+	assert.Nil(t, span)
+	finishSpan()
+
+	tracer := mocktracer.New()
+	root := tracer.StartSpan("root")
+	ctx := opentracing.ContextWithSpan(context.Background(), root)
+	_, err := store.SplitRegions(ctx, [][]byte{[]byte("b"), []byte("x")}, false, nil)
+	assert.Nil(t, err)
+	root.Finish()
+
+	var batchSpans int
+	for _, span := range tracer.FinishedSpans() {
+		switch span.OperationName {
+		case "tikvStore.splitBatchRegionsReq":
+			assert.Equal(t, 2, span.Tag("keys"))
+			assert.Equal(t, 2, span.Tag("batches"))
+		case "tikvStore.batchSendSingleRegion":
+			batchSpans++
+			assert.NotNil(t, span.Tag("region_id"))
+			assert.Equal(t, 1, span.Tag("keys"))
+		}
+	}
+	assert.Equal(t, 2, batchSpans)
+}
+
+func TestSplitRegionsDeadlineExceeded(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	client := &reqCtxRecordClient{Client: store.GetTiKVClient(), ctxs: make(map[tikvrpc.CmdType][]kvrpcpb.Context)}
+	store.SetTiKVClient(client)
+
+	// The deadline has been exceeded before the call.
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	_, err := store.SplitRegions(ctx, [][]byte{[]byte("b")}, false, nil)
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	// The deadline is exceeded after the keys are grouped by region.
+	StoreProbe{store}.SetBeforeSplitSendHook(func(ctx context.Context) {
+		<-ctx.Done()
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = store.SplitRegions(ctx, [][]byte{[]byte("c")}, false, nil)
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.Empty(t, client.ctxs[tikvrpc.CmdSplitRegion])
+}
+
+func TestSplitTestHooks(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	probe := StoreProbe{store}
+
+	// Simulate the split timeout.
+	probe.SetBeforeSplitSendHook(func(ctx context.Context) {
+		<-ctx.Done()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	_, err := store.SplitRegions(ctx, [][]byte{[]byte("b")}, false, nil)
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	probe.SetBeforeSplitSendHook(nil)
+
+	// Simulate a PD timeout on the first scatter request of each region.
+	scatterCalls := make(map[uint64]int)
+	probe.SetScatterErrHook(func(regionID uint64) error {
+		scatterCalls[regionID]++
+		if scatterCalls[regionID] == 1 {
+			return tikverr.NewErrPDServerTimeout("")
+		}
+		return nil
+	})
+	regionIDs, err := store.SplitRegions(context.Background(), [][]byte{[]byte("c")}, true, nil)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, regionIDs)
+	assert.NotEmpty(t, scatterCalls)
+	for _, n := range scatterCalls {
+		assert.Equal(t, 2, n)
+	}
+}
+
+func TestScatterRegionCanceled(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	StoreProbe{store}.SetScatterErrHook(func(uint64) error {
+		return tikverr.NewErrPDServerTimeout("")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	bo := retry.NewBackofferWithVars(ctx, int(GetSplitRegionBackoff().Milliseconds()), nil)
+	start := time.Now()
+	err := store.scatterRegion(bo, 1, nil, newSplitOptions(nil))
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// It doesn't send any request if the context is already canceled.
+	calls := 0
+	StoreProbe{store}.SetScatterErrHook(func(uint64) error {
+		calls++
+		return nil
+	})
+	err = store.scatterRegion(retry.NewNoopBackoff(ctx), 1, nil, newSplitOptions(nil))
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Equal(t, 0, calls)
+}
+
+func TestScatterRegionErrClassification(t *testing.T) {
+	regionNotFound := &pdpb.Error{Type: pdpb.ErrorType_REGION_NOT_FOUND, Message: "region 1 not found"}
+	assert.True(t, isFatalScatterErr(&tikverr.PDError{Err: regionNotFound}))
+	assert.True(t, isFatalScatterErr(errors.Errorf("scatter regions [1] failed: %s", regionNotFound.String())))
+	assert.False(t, isFatalScatterErr(&tikverr.PDError{Err: &pdpb.Error{Type: pdpb.ErrorType_UNKNOWN}}))
+	assert.False(t, isFatalScatterErr(tikverr.NewErrPDServerTimeout("")))
+
+	var pdCli *mockScatterPDClient
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		pdCli = &mockScatterPDClient{Client: c}
+		return pdCli
+	})
+	defer store.Close()
+	bo := retry.NewBackofferWithVars(context.Background(), int(GetSplitRegionBackoff().Milliseconds()), nil)
+	opts := newSplitOptions(nil)
+	opts.scatterBackoff = retry.NewConfig("scatterRegion", nil, retry.NewBackoffFnCfg(1, 1, retry.NoJitter), errors.New("scatter"))
+
+	// The region is gone, fail at once.
+	pdCli.scatterRegions = func([]uint64) (*pdpb.ScatterRegionResponse, error) {
+		return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{Error: regionNotFound}}, nil
+	}
+	err := store.scatterRegion(bo, 1, nil, opts)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, pdCli.scatterTimes)
+
+	// The others are retried.
+	pdCli.scatterTimes = 0
+	pdCli.scatterRegions = func([]uint64) (*pdpb.ScatterRegionResponse, error) {
+		if pdCli.scatterTimes < 3 {
+			return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{Error: &pdpb.Error{Type: pdpb.ErrorType_UNKNOWN}}}, nil
+		}
+		return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}}, nil
+	}
+	assert.Nil(t, store.scatterRegion(bo, 1, nil, opts))
+	assert.Equal(t, 3, pdCli.scatterTimes)
+}
+
+func TestWaitScatterRegionFinishMinInterval(t *testing.T) {
+	mockPD := &mockScatterPDClient{getOperator: runningScatterOperator}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	minInterval := 100 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err := store.WaitScatterRegionFinishWithOptions(ctx, 1, 10000, WithScatterWaitMinInterval(minInterval))
+	assert.NotNil(t, err)
+
+	mockPD.mu.Lock()
+	defer mockPD.mu.Unlock()
+	assert.LessOrEqual(t, len(mockPD.getOperatorTimes), 6)
+	for i := 1; i < len(mockPD.getOperatorTimes); i++ {
+		assert.GreaterOrEqual(t, int64(mockPD.getOperatorTimes[i].Sub(mockPD.getOperatorTimes[i-1])), int64(minInterval))
+	}
+}
+
+func TestWaitScatterRegionFinishTimeout(t *testing.T) {
+	mockPD := &mockScatterPDClient{getOperator: runningScatterOperator}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+
+	// The region is still being scattered.
+	err := store.WaitScatterRegionFinish(context.Background(), 1, 50)
+	timeoutErr, ok := errors.Cause(err).(*tikverr.ErrScatterWaitTimeout)
+	require.True(t, ok)
+	assert.Equal(t, &tikverr.ErrScatterWaitTimeout{RegionID: 1, Backoff: 50}, timeoutErr)
+	assert.Contains(t, err.Error(), "wait scatter region timeout")
+
+	// PD keeps failing.
+	pdErr := errors.New("mock pd error")
+	mockPD.getOperator = func(uint64) (*pdpb.GetOperatorResponse, error) {
+		return nil, pdErr
+	}
+	err = store.WaitScatterRegionFinish(context.Background(), 1, 50)
+	assert.Equal(t, pdErr, errors.Cause(err))
+
+	// The deadline of the context is exceeded while the region is being scattered.
+	mockPD.getOperator = runningScatterOperator
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = store.WaitScatterRegionFinish(ctx, 1, 10000)
+	_, ok = errors.Cause(err).(*tikverr.ErrScatterWaitTimeout)
+	assert.True(t, ok)
+}
+
+func TestScatterWithFakePD(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	fakePD := testutil.NewPDClient(&metapb.Store{Id: 1, State: metapb.StoreState_Up})
+	StoreProbe{store}.SetSplitGCPDClient(fakePD)
+
+	regionIDs, err := store.SplitRegions(context.Background(), [][]byte{[]byte("b")}, true, nil)
+	assert.Nil(t, err)
+	assert.Len(t, regionIDs, 1)
+	assert.Equal(t, 1, fakePD.ScatterCount(regionIDs[0]))
+
+	// The scatter is kept running until it's finished explicitly.
+	err = store.WaitScatterRegionFinish(context.Background(), regionIDs[0], 50)
+	assert.NotNil(t, err)
+	fakePD.FinishScatter(regionIDs[0])
+	assert.Nil(t, store.WaitScatterRegionFinish(context.Background(), regionIDs[0], 50))
+}
+
+func TestKVStoreAsSplittableStore(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	fakePD := testutil.NewPDClient(&metapb.Store{Id: 1, State: metapb.StoreState_Up})
+	StoreProbe{store}.SetSplitGCPDClient(fakePD)
+
+	// The callers only depending on the interface see the same behavior as the methods of KVStore.
+	var s SplittableStore = store
+	regionIDs, err := s.SplitRegions(context.Background(), [][]byte{[]byte("b")}, true, nil)
+	assert.Nil(t, err)
+	assert.Len(t, regionIDs, 1)
+	scattering, err := s.CheckRegionInScattering(regionIDs[0])
+	assert.Nil(t, err)
+	assert.True(t, scattering)
+	fakePD.FinishScatter(regionIDs[0])
+	assert.Nil(t, s.WaitScatterRegionFinish(context.Background(), regionIDs[0], 50))
+	scattering, err = s.CheckRegionInScattering(regionIDs[0])
+	assert.Nil(t, err)
+	assert.False(t, scattering)
+}
+
+func TestSplitTunables(t *testing.T) {
+	assert.Equal(t, 16, GetSplitBatchRegionLimit())
+	assert.Equal(t, 20*time.Second, GetSplitRegionBackoff())
+	assert.Equal(t, 2*time.Minute, GetMaxSplitRegionsBackoff())
+	assert.Equal(t, 2*time.Minute, GetWaitScatterRegionFinishBackoff())
+	defer func() {
+		assert.Nil(t, SetSplitBatchRegionLimit(16))
+		assert.Nil(t, SetSplitRegionBackoff(20*time.Second))
+		assert.Nil(t, SetMaxSplitRegionsBackoff(2*time.Minute))
+		assert.Nil(t, SetWaitScatterRegionFinishBackoff(2*time.Minute))
+	}()
+
+	assert.NotNil(t, SetSplitBatchRegionLimit(0))
+	assert.Nil(t, SetSplitBatchRegionLimit(4))
+	assert.Equal(t, 4, GetSplitBatchRegionLimit())
+
+	assert.NotNil(t, SetSplitRegionBackoff(0))
+	assert.NotNil(t, SetSplitRegionBackoff(time.Microsecond))
+	assert.Nil(t, SetSplitRegionBackoff(time.Second))
+	assert.Equal(t, time.Second, GetSplitRegionBackoff())
+	assert.NotNil(t, SetMaxSplitRegionsBackoff(-time.Second))
+	assert.Nil(t, SetMaxSplitRegionsBackoff(time.Minute))
+	assert.Equal(t, time.Minute, GetMaxSplitRegionsBackoff())
+	assert.NotNil(t, SetWaitScatterRegionFinishBackoff(0))
+	assert.Nil(t, SetWaitScatterRegionFinishBackoff(time.Minute))
+	assert.Equal(t, time.Minute, GetWaitScatterRegionFinishBackoff())
+}
+
+func TestQuantileSplitKeys(t *testing.T) {
+	keys := [][]byte{[]byte("h"), []byte("a"), []byte("c"), []byte("a"), []byte(""), []byte("e"), []byte("g"), []byte("b"), []byte("d"), []byte("f")}
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("e"), []byte("g")}, quantileSplitKeys(keys, 4))
+	assert.Equal(t, [][]byte{[]byte("e")}, quantileSplitKeys(keys, 2))
+	assert.Empty(t, quantileSplitKeys(keys, 1))
+	// There are only 8 distinct keys.
+	assert.Len(t, quantileSplitKeys(keys, 100), 7)
+	assert.Empty(t, quantileSplitKeys(nil, 4))
+}
+
+func TestPreSplitByKeys(t *testing.T) {
+	store, cluster := newTestKVStore(t, nil)
+	defer store.Close()
+
+	_, err := store.PreSplitByKeys(context.Background(), nil, WithPreSplitRegionCount(0))
+	assert.NotNil(t, err)
+
+	// Most keys are skewed to the "b" prefix.
+	var keys [][]byte
+	for i := 0; i < 90; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("b%02d", i)))
+	}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("x%02d", i)))
+	}
+	_, err = store.PreSplitByKeys(context.Background(), keys, WithPreSplitRegionCount(2))
+	assert.Nil(t, err)
+	region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte("b49")))
+	assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte("b50"))), region.GetEndKey())
+
+	// The range is split into 16 regions by default.
+	for i := range keys {
+		keys[i][0] = 'm'
+	}
+	regionIDs, err := store.PreSplitByKeys(context.Background(), keys)
+	assert.Nil(t, err)
+	assert.Len(t, regionIDs, defaultPreSplitRegionCount-1)
+}
+
+func TestSplitAndScatterWait(t *testing.T) {
+	var pdCli *mockScatterPDClient
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		pdCli = &mockScatterPDClient{Client: c}
+		return pdCli
+	})
+	defer store.Close()
+
+	keys := [][]byte{[]byte("b"), []byte("c"), []byte("d")}
+	regionIDs, err := store.SplitAndScatterWait(context.Background(), keys, nil, 0, WithSplitConcurrency(2))
+	assert.Nil(t, err)
+	assert.Len(t, regionIDs, 3)
+	assert.Len(t, pdCli.getOperatorTimes, 3)
+
+	// The regions are returned along with the error if the wait runs out of time.
+	pdCli.getOperator = runningScatterOperator
+	start := time.Now()
+	regionIDs, err = store.SplitAndScatterWait(context.Background(), [][]byte{[]byte("x"), []byte("y")}, nil, 100)
+	assert.NotNil(t, err)
+	assert.Len(t, regionIDs, 2)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+func TestSplitRegionsWithLeaders(t *testing.T) {
+	var pdCli *mockScatterPDClient
+	store, cluster := newTestKVStore(t, func(c pd.Client) pd.Client {
+		pdCli = &mockScatterPDClient{Client: c}
+		return pdCli
+	})
+	defer store.Close()
+
+	keys := [][]byte{[]byte("b"), []byte("c")}
+	leaders, err := store.SplitRegionsWithLeaders(context.Background(), keys, true, nil, WithSplitLeaderWait(time.Second))
+	assert.Nil(t, err)
+	require.Len(t, leaders, 2)
+	assert.Len(t, pdCli.getOperatorTimes, 2)
+	for i, leader := range leaders {
+		region, peer := cluster.GetRegionByID(leader.RegionID)
+		require.NotNil(t, region)
+		// The new region is on either side of its split key, depending on the store.
+		assert.True(t, bytes.Equal(keys[i], leader.StartKey) || bytes.Equal(keys[i], leader.EndKey))
+		assert.Equal(t, region.GetStartKey(), []byte(mocktikv.NewMvccKey(leader.StartKey)))
+		assert.Equal(t, region.GetEndKey(), []byte(mocktikv.NewMvccKey(leader.EndKey)))
+		assert.Equal(t, peer.GetId(), leader.LeaderPeerID)
+		assert.Equal(t, peer.GetStoreId(), leader.LeaderStoreID)
+	}
+
+	// The leaders are resolved without waiting for the scatter by default.
+	pdCli.getOperatorTimes = nil
+	leaders, err = store.SplitRegionsWithLeaders(context.Background(), [][]byte{[]byte("x")}, true, nil)
+	assert.Nil(t, err)
+	assert.Len(t, leaders, 1)
+	assert.Empty(t, pdCli.getOperatorTimes)
+
+	_, err = store.SplitRegionsWithLeaders(context.Background(), keys, true, nil, WithSplitLeaderWait(-time.Second))
+	assert.NotNil(t, err)
+}
+
+func TestSplitRegionsWithLeadersKeyspace(t *testing.T) {
+	store, cluster := newTestKVStore(t, nil)
+	defer store.Close()
+	store.SetKeyspacePrefix([]byte("x"))
+
+	keys := [][]byte{[]byte("b"), []byte("c")}
+	leaders, err := store.SplitRegionsWithLeaders(context.Background(), keys, false, nil)
+	assert.Nil(t, err)
+	require.Len(t, leaders, 2)
+	for i, leader := range leaders {
+		region, _ := cluster.GetRegionByID(leader.RegionID)
+		require.NotNil(t, region)
+		// The range is without the keyspace prefix, and the bounds outside the keyspace are empty.
+		assert.True(t, bytes.Equal(keys[i], leader.StartKey) || bytes.Equal(keys[i], leader.EndKey))
+		if len(leader.StartKey) > 0 {
+			assert.Equal(t, region.GetStartKey(), []byte(mocktikv.NewMvccKey(append([]byte("x"), leader.StartKey...))))
+		} else {
+			assert.True(t, bytes.Compare(region.GetStartKey(), mocktikv.NewMvccKey([]byte("x"))) < 0)
+		}
+		if len(leader.EndKey) > 0 {
+			assert.Equal(t, region.GetEndKey(), []byte(mocktikv.NewMvccKey(append([]byte("x"), leader.EndKey...))))
+		} else {
+			assert.Empty(t, region.GetEndKey())
+		}
+	}
+}
+
+func TestSplitRegionsWithLeadersFakePD(t *testing.T) {
+	client, cluster, pdClient, err := mocktikv.NewTiKVAndPDClient("", nil)
+	require.Nil(t, err)
+	mocktikv.BootstrapWithMultiRegions(cluster, []byte("c"))
+	store, err := NewTestTiKVStore(client, pdClient, func(c Client) Client {
+		return &failSplitClient{Client: c, failKey: []byte("d")}
+	}, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	fakePD := testutil.NewPDClient(&metapb.Store{Id: 1, State: metapb.StoreState_Up})
+	StoreProbe{store}.SetSplitGCPDClient(fakePD)
+
+	// The leaders are loaded from the fake, which doesn't know the new region, but the split error is returned.
+	leaders, err := store.SplitRegionsWithLeaders(context.Background(), [][]byte{[]byte("b"), []byte("d")}, false, nil)
+	opErr, ok := tikverr.OperationOf(err)
+	require.True(t, ok)
+	assert.Equal(t, "SplitRegionsWithLeaders", opErr.Op)
+	// The operation is attached once, not again by the split.
+	_, ok = tikverr.OperationOf(opErr.Err)
+	assert.False(t, ok)
+	assert.Empty(t, leaders)
+
+	fakePD.SetRegion(&pd.Region{
+		Meta:   &metapb.Region{Id: 100, StartKey: []byte("x"), EndKey: []byte("y")},
+		Leader: &metapb.Peer{Id: 101, StoreId: 1},
+	})
+	leader, err := store.loadRegionLeader(context.Background(), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, RegionLeaderInfo{RegionID: 100, StartKey: []byte("x"), EndKey: []byte("y"), LeaderPeerID: 101, LeaderStoreID: 1}, leader)
+}
+
+func TestScatterFilter(t *testing.T) {
+	var pdCli *mockScatterPDClient
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		pdCli = &mockScatterPDClient{Client: c}
+		return pdCli
+	})
+	defer store.Close()
+
+	// Skip scattering the first new region.
+	var filtered []uint64
+	filter := func(r *metapb.Region) bool {
+		filtered = append(filtered, r.GetId())
+		return len(filtered) > 1
+	}
+	keys := [][]byte{[]byte("b"), []byte("c"), []byte("d")}
+	var scattered []uint64
+	pdCli.scatterRegions = func(regionIDs []uint64) (*pdpb.ScatterRegionResponse, error) {
+		scattered = append(scattered, regionIDs...)
+		return &pdpb.ScatterRegionResponse{}, nil
+	}
+	regionIDs, err := store.SplitRegionsWithOptions(context.Background(), keys, true, nil, WithScatterFilter(filter))
+	assert.Nil(t, err)
+	assert.Equal(t, regionIDs, filtered)
+	// The other regions are scattered in one request.
+	assert.Equal(t, 1, pdCli.scatterTimes)
+	assert.Equal(t, regionIDs[1:], scattered)
+}
+
+func TestScatterSplitRegionsInBatch(t *testing.T) {
+	var pdCli *mockScatterPDClient
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		pdCli = &mockScatterPDClient{Client: c}
+		return pdCli
+	})
+	defer store.Close()
+	sink := newRecordEventSink()
+	store.SetEventSink(sink)
+
+	// The new regions of a batch are scattered in one request.
+	var requests [][]uint64
+	pdCli.scatterRegions = func(regionIDs []uint64) (*pdpb.ScatterRegionResponse, error) {
+		requests = append(requests, regionIDs)
+		return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}, FinishedPercentage: 100}, nil
+	}
+	keys := [][]byte{[]byte("b"), []byte("c"), []byte("d")}
+	regionIDs, err := store.SplitRegions(context.Background(), keys, true, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]uint64{regionIDs}, requests)
+
+	// Some regions aren't scattered, so they are scattered one by one, the first one is gone.
+	requests = nil
+	var gone uint64
+	pdCli.scatterRegions = func(regionIDs []uint64) (*pdpb.ScatterRegionResponse, error) {
+		requests = append(requests, regionIDs)
+		if len(regionIDs) > 1 {
+			gone = regionIDs[0]
+			return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}, FinishedPercentage: 50}, nil
+		}
+		if regionIDs[0] == gone {
+			return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{
+				Error: &pdpb.Error{Type: pdpb.ErrorType_REGION_NOT_FOUND},
+			}}, nil
+		}
+		return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}, FinishedPercentage: 100}, nil
+	}
+	keys = [][]byte{[]byte("x"), []byte("y")}
+	regionIDs, err = store.SplitRegions(context.Background(), keys, true, nil)
+	assert.True(t, tikverr.IsPDRegionNotFound(err))
+	assert.Len(t, regionIDs, 2)
+	assert.Equal(t, [][]uint64{regionIDs, {regionIDs[0]}, {regionIDs[1]}}, requests)
+	assert.Len(t, sink.scatterErrs, 1)
+	assert.NotNil(t, sink.scatterErrs[gone])
+}
+
+func TestSplitPreGroupedKeys(t *testing.T) {
+	store, cluster := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+
+	bo := NewBackofferWithVars(context.Background(), 5000, nil)
+	locA, err := store.GetRegionCache().LocateKey(bo, []byte("a"))
+	require.Nil(t, err)
+	locM, err := store.GetRegionCache().LocateKey(bo, []byte("m"))
+	require.Nil(t, err)
+	// Split the second region, so its group is regrouped.
+	_, err = store.SplitRegions(context.Background(), [][]byte{[]byte("x")}, false, nil)
+	require.Nil(t, err)
+
+	groups := map[RegionVerID][][]byte{
+		locA.Region: {[]byte("b"), []byte("c")},
+		locM.Region: {[]byte("n"), []byte("y")},
+	}
+	_, err = store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("d")}, false, nil, WithPreGroupedKeys(groups))
+	assert.NotNil(t, err)
+	regionIDs, err := store.SplitRegionsWithOptions(context.Background(), nil, false, nil, WithPreGroupedKeys(groups))
+	assert.Nil(t, err)
+	assert.Len(t, regionIDs, 4)
+	for _, key := range []string{"b", "c", "n", "y"} {
+		region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte(key)))
+		assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte(key))), region.GetStartKey())
+	}
+}
+
+func TestCloseStoreMidSplit(t *testing.T) {
+	// The goroutine of the mock store's database is ignored, like in TestMain.
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent(), goleak.IgnoreTopFunction("github.com/pingcap/goleveldb/leveldb.(*DB).mpoolDrain"))
+	store, _ := newTestKVStore(t, nil)
+
+	// The split blocks until the store is closed.
+	entered := make(chan struct{})
+	var once sync.Once
+	StoreProbe{store}.SetBeforeSplitSendHook(func(ctx context.Context) {
+		once.Do(func() { close(entered) })
+		<-ctx.Done()
+	})
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := store.SplitRegions(context.Background(), [][]byte{[]byte("b"), []byte("c")}, true, nil)
+		errCh <- err
+	}()
+	<-entered
+	start := time.Now()
+	assert.Nil(t, store.Close())
+	assert.Less(t, time.Since(start), closeOpsWaitTimeout)
+	select {
+	case err := <-errCh:
+		assert.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "split doesn't return after the store is closed")
+	}
+
+	// The operations started after closing fail fast.
+	_, err := store.SplitRegions(context.Background(), [][]byte{[]byte("d")}, false, nil)
+	assert.NotNil(t, err)
+}
+
+func TestCloseStoreAbandonsStuckOps(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+
+	// The split ignores the cancellation of the store until it's released.
+	entered, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	StoreProbe{store}.SetBeforeSplitSendHook(func(ctx context.Context) {
+		once.Do(func() { close(entered) })
+		<-release
+	})
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := store.SplitRegions(context.Background(), [][]byte{[]byte("b")}, false, nil)
+		errCh <- err
+	}()
+	<-entered
+	assert.Equal(t, 1, store.waitOps(50*time.Millisecond))
+
+	close(release)
+	<-errCh
+	assert.Nil(t, store.Close())
+}
+
+func TestSplitBatchesCanceled(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("b"), []byte("d"), []byte("f"))
+	defer store.Close()
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	// The first batch cancels the split, and the others see the canceled context.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StoreProbe{store}.SetBeforeSplitSendHook(func(ctx context.Context) {
+		cancel()
+		<-ctx.Done()
+	})
+	keys := [][]byte{[]byte("a1"), []byte("c1"), []byte("e1"), []byte("g1")}
+	_, err := store.SplitRegionsWithOptions(ctx, keys, true, nil, WithSplitConcurrency(2))
+	assert.NotNil(t, err)
+	assert.True(t, isNonRetryableSplitErr(err))
+}
+
+func TestSplitRequestContext(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+	client := &reqCtxRecordClient{Client: store.GetTiKVClient(), ctxs: make(map[tikvrpc.CmdType][]kvrpcpb.Context)}
+	store.SetTiKVClient(client)
+
+	_, err := store.SplitRegions(context.Background(), [][]byte{[]byte("b")}, false, nil)
+	assert.Nil(t, err)
+	n := len(client.ctxs[tikvrpc.CmdSplitRegion])
+	require.Equal(t, 1, n)
+	// The region of "c" is stale in the cache after the first split, so it may be split with a retry.
+	reqCtx := SplitRequestContext{Priority: PriorityLow, ResourceGroupTag: []byte("split")}
+	_, err = store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("c"), []byte("x")}, false, nil, WithSplitRequestContext(reqCtx))
+	assert.Nil(t, err)
+
+	ctxs := client.ctxs[tikvrpc.CmdSplitRegion]
+	require.GreaterOrEqual(t, len(ctxs), n+2)
+	assert.Equal(t, kvrpcpb.CommandPri_Normal, ctxs[0].Priority)
+	assert.Nil(t, ctxs[0].ResourceGroupTag)
+	for _, c := range ctxs[n:] {
+		assert.Equal(t, kvrpcpb.CommandPri_Low, c.Priority)
+		assert.Equal(t, []byte("split"), c.ResourceGroupTag)
+	}
+}
+
+// busyOnceClient fails the first request of the type with a ServerIsBusy region error.
+type busyOnceClient struct {
+	Client
+
+	typ  tikvrpc.CmdType
+	busy int32
+}
+
+func (c *busyOnceClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == c.typ && atomic.CompareAndSwapInt32(&c.busy, 0, 1) {
+		return tikvrpc.GenRegionErrorResp(req, &errorpb.Error{ServerIsBusy: &errorpb.ServerIsBusy{}})
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestSendReqRetryCounter(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	store.SetTiKVClient(&busyOnceClient{Client: store.GetTiKVClient(), typ: tikvrpc.CmdSplitRegion})
+	readCounter := func() float64 {
+		pb := &dto.Metric{}
+		counter := metrics.TiKVSendReqRetryCounter.WithLabelValues(tikvrpc.CmdSplitRegion.String(), "region_err")
+		assert.Nil(t, counter.Write(pb))
+		return pb.GetCounter().GetValue()
+	}
+
+	before := readCounter()
+	_, err := store.SplitRegions(context.Background(), [][]byte{[]byte("b")}, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, before+1, readCounter())
+}
+
+func TestSplitBackoffStats(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+
+	var stats SplitBackoffStats
+	_, err := store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("b"), []byte("x")}, false, nil, WithSplitBackoffStats(&stats))
+	assert.Nil(t, err)
+	assert.Equal(t, SplitBackoffStats{Batches: 2}, stats)
+
+	// Inject a PD timeout on the first scatter request of each region. Use another store, as the regions cached by
+	// the first one are stale, and resending their batches would be counted as well.
+	store, _ = newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+	var failed sync.Map
+	StoreProbe{store}.SetScatterErrHook(func(regionID uint64) error {
+		if _, loaded := failed.LoadOrStore(regionID, struct{}{}); !loaded {
+			return tikverr.NewErrPDServerTimeout("")
+		}
+		return nil
+	})
+	stats = SplitBackoffStats{}
+	_, err = store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("c"), []byte("y")}, true, nil, WithSplitBackoffStats(&stats))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stats.Batches)
+	assert.Greater(t, int64(stats.MaxBatchBackoff), int64(0))
+	assert.GreaterOrEqual(t, int64(stats.TotalBackoff), int64(stats.MaxBatchBackoff))
+}
+
+func TestPreSplitWithoutWaitingScatter(t *testing.T) {
+	mockPD := &mockScatterPDClient{getOperator: runningScatterOperator}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+	config := ConfigProbe{}
+	old := config.LoadPreSplitSizeThreshold()
+	defer config.StorePreSplitSizeThreshold(old)
+	config.StorePreSplitSizeThreshold(10)
+
+	mutations := NewPlainMutations(10)
+	for i := 0; i < 10; i++ {
+		mutations.Push(kvrpcpb.Op_Put, []byte(fmt.Sprintf("k%02d", i)), []byte("value"), false)
+	}
+	bo := retry.NewBackofferWithVars(context.Background(), 1000, nil)
+	loc, err := store.GetRegionCache().LocateKey(bo, []byte("k00"))
+	assert.Nil(t, err)
+
+	// The scatter never finishes, but 2PC doesn't wait for it.
+	store.SetPreSplitWaitScatter(false)
+	start := time.Now()
+	assert.True(t, store.preSplitRegion(context.Background(), groupedMutations{region: loc.Region, mutations: &mutations}))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Empty(t, mockPD.getOperatorTimes)
+}
+
+func TestPreSplitScatterWaitTimeout(t *testing.T) {
+	mockPD := &mockScatterPDClient{getOperator: runningScatterOperator}
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		mockPD.Client = c
+		return mockPD
+	})
+	defer store.Close()
+	config := ConfigProbe{}
+	old := config.LoadPreSplitSizeThreshold()
+	defer config.StorePreSplitSizeThreshold(old)
+	config.StorePreSplitSizeThreshold(10)
+
+	assert.Equal(t, 10*time.Second, store.getPreSplitScatterWaitTimeout())
+	assert.NotNil(t, store.SetPreSplitScatterWaitTimeout(0))
+	assert.Nil(t, store.SetPreSplitScatterWaitTimeout(300*time.Millisecond))
+	assert.Equal(t, 300*time.Millisecond, store.getPreSplitScatterWaitTimeout())
+
+	mutations := NewPlainMutations(10)
+	for i := 0; i < 10; i++ {
+		mutations.Push(kvrpcpb.Op_Put, []byte(fmt.Sprintf("k%02d", i)), []byte("value"), false)
+	}
+	bo := retry.NewBackofferWithVars(context.Background(), 1000, nil)
+	loc, err := store.GetRegionCache().LocateKey(bo, []byte("k00"))
+	assert.Nil(t, err)
+
+	// The scatter never finishes, the wait of all regions gives up after the timeout.
+	start := time.Now()
+	assert.True(t, store.preSplitRegion(context.Background(), groupedMutations{region: loc.Region, mutations: &mutations}))
+	assert.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	assert.NotEmpty(t, mockPD.getOperatorTimes)
+}
+
+func TestSplitAlreadySplitKeys(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	store.SetKeyspacePrefix([]byte("x"))
+
+	var alreadySplit [][]byte
+	_, err := store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("b"), []byte("d")}, false, nil, WithSplitAlreadySplitKeys(&alreadySplit))
+	assert.Nil(t, err)
+	assert.Empty(t, alreadySplit)
+
+	// Re-run the split with a new key.
+	_, err = store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("b"), []byte("c"), []byte("d")}, false, nil, WithSplitAlreadySplitKeys(&alreadySplit))
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("b"), []byte("d")}, alreadySplit)
+}
+
+func TestSplitExistingRegions(t *testing.T) {
+	store, cluster := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+
+	// Split the region at "f" behind the region cache.
+	bo := retry.NewBackofferWithVars(context.Background(), 5000, nil)
+	loc, err := store.GetRegionCache().LocateKey(bo, []byte("a"))
+	assert.Nil(t, err)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(loc.Region.GetID(), ids[0], []byte("f"), []uint64{ids[1]}, ids[1])
+
+	var existing [][]byte
+	var existingIDs []uint64
+	regionIDs, err := store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("f"), []byte("m"), []byte("x")}, false, nil,
+		WithSplitExistingRegions(&existingIDs), WithSplitAlreadySplitKeys(&existing))
+	assert.Nil(t, err)
+	assert.Len(t, regionIDs, 1)
+	assert.Len(t, existingIDs, 2)
+	assert.Contains(t, existingIDs, ids[0])
+	assert.ElementsMatch(t, [][]byte{[]byte("f"), []byte("m")}, existing)
+
+	// Nothing is split if all keys are boundaries.
+	regionIDs, err = store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("f"), []byte("x")}, false, nil, WithSplitExistingRegions(&existingIDs))
+	assert.Nil(t, err)
+	assert.Empty(t, regionIDs)
+	assert.Len(t, existingIDs, 2)
+}
+
+func TestScatterMinHealthyStores(t *testing.T) {
+	store, _ := newTestKVStore(t, nil)
+	defer store.Close()
+	scattered := 0
+	StoreProbe{store}.SetScatterErrHook(func(uint64) error {
+		scattered++
+		return nil
+	})
+
+	// There is only 1 store in the cluster.
+	regionIDs, err := store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("b")}, true, nil, WithScatterMinHealthyStores(2))
+	assert.NotEmpty(t, regionIDs)
+	storesErr, ok := errors.Cause(err).(*tikverr.ErrInsufficientHealthyStores)
+	assert.True(t, ok)
+	assert.Equal(t, &tikverr.ErrInsufficientHealthyStores{Healthy: 1, Required: 2}, storesErr)
+	assert.Equal(t, 0, scattered)
+	opErr, ok := tikverr.OperationOf(err)
+	assert.True(t, ok)
+	assert.Equal(t, &tikverr.ErrOperation{Op: "SplitRegions", StartKey: []byte("b"), EndKey: []byte("b"), Err: opErr.Err}, opErr)
+
+	_, err = store.SplitRegionsWithOptions(context.Background(), [][]byte{[]byte("c")}, true, nil, WithScatterMinHealthyStores(1))
+	assert.Nil(t, err)
+	assert.Greater(t, scattered, 0)
+}
+
+func TestFilterKeysAtRegionStart(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("b"))
+	defer store.Close()
+
+	bo := retry.NewBackofferWithVars(context.Background(), 1000, nil)
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	groups, _, err := store.GetRegionCache().GroupKeysByRegion(bo, keys, FilterKeysAtRegionStart)
+	assert.Nil(t, err)
+	var grouped [][]byte
+	for _, g := range groups {
+		grouped = append(grouped, g...)
+	}
+	assert.ElementsMatch(t, [][]byte{[]byte("a"), []byte("c")}, grouped)
+}
+
+// dropSplitKeyClient drops the key from the split region requests, as if TiKV skipped it silently.
+type dropSplitKeyClient struct {
+	Client
+
+	key []byte
+}
+
+func (c *dropSplitKeyClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdSplitRegion {
+		splitReq := req.SplitRegion()
+		keys := make([][]byte, 0, len(splitReq.SplitKeys))
+		for _, key := range splitReq.SplitKeys {
+			if !bytes.Equal(key, c.key) {
+				keys = append(keys, key)
+			}
+		}
+		splitReq.SplitKeys = keys
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestSplitVerify(t *testing.T) {
+	store, _ := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+
+	var unsplitKeys [][]byte
+	keys := [][]byte{[]byte("b"), []byte("c"), []byte("m"), []byte("x")}
+	_, err := store.SplitRegionsWithOptions(context.Background(), keys, false, nil, WithSplitVerify(&unsplitKeys))
+	assert.Nil(t, err)
+	assert.Empty(t, unsplitKeys)
+
+	store.SetTiKVClient(&dropSplitKeyClient{Client: store.GetTiKVClient(), key: []byte("e")})
+	keys = [][]byte{[]byte("d"), []byte("e"), []byte("f")}
+	_, err = store.SplitRegionsWithOptions(context.Background(), keys, false, nil, WithSplitVerify(&unsplitKeys))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("e")}, unsplitKeys)
+}
+
+func TestScatterRegions(t *testing.T) {
+	var pdCli *mockScatterPDClient
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		pdCli = &mockScatterPDClient{Client: c}
+		return pdCli
+	})
+	defer store.Close()
+
+	pdCli.scatterRegions = func([]uint64) (*pdpb.ScatterRegionResponse, error) {
+		return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}, FinishedPercentage: 100}, nil
+	}
+	results, err := store.ScatterRegions(context.Background(), []uint64{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Equal(t, map[uint64]error{1: nil, 2: nil, 3: nil}, results)
+	assert.Equal(t, 1, pdCli.scatterTimes)
+
+	// Region 3 is gone, it fails the batch, and the regions are scattered one by one.
+	for _, finished := range []uint64{0, 50} {
+		pdCli.scatterTimes = 0
+		pdCli.scatterRegions = func(regionIDs []uint64) (*pdpb.ScatterRegionResponse, error) {
+			for _, id := range regionIDs {
+				if id == 3 && finished == 0 {
+					return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{
+						Error: &pdpb.Error{Type: pdpb.ErrorType_REGION_NOT_FOUND},
+					}}, nil
+				}
+			}
+			if len(regionIDs) > 1 {
+				return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}, FinishedPercentage: finished}, nil
+			}
+			return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}, FinishedPercentage: 100}, nil
+		}
+		results, err = store.ScatterRegions(context.Background(), []uint64{1, 2, 3})
+		assert.Nil(t, err)
+		assert.Len(t, results, 3)
+		assert.Nil(t, results[1])
+		assert.Nil(t, results[2])
+		if finished == 0 {
+			assert.True(t, isFatalScatterErr(results[3]))
+		} else {
+			assert.Nil(t, results[3])
+		}
+		assert.Equal(t, 4, pdCli.scatterTimes)
+	}
+
+	// The batch fails with a non-fatal error and gives up as ctx is done, no region is known to be scattered.
+	pdCli.scatterTimes = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	pdCli.scatterRegions = func([]uint64) (*pdpb.ScatterRegionResponse, error) {
+		cancel()
+		return nil, tikverr.NewErrPDServerTimeout("")
+	}
+	results, err = store.ScatterRegions(ctx, []uint64{1, 2, 3})
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.NotNil(t, results)
+	assert.Empty(t, results)
+	assert.Equal(t, 1, pdCli.scatterTimes)
+}
+
+func TestSplitRegionsStream(t *testing.T) {
+	// The goroutine of the mock store's database is ignored, like in TestMain.
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent(), goleak.IgnoreTopFunction("github.com/pingcap/goleveldb/leveldb.(*DB).mpoolDrain"))
+	store, cluster := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+
+	keys := [][]byte{[]byte("b"), []byte("c"), []byte("n"), []byte("x")}
+	resultCh, errCh := store.SplitRegionsStream(context.Background(), keys, false, nil)
+	var results []SplitResult
+	for result := range resultCh {
+		results = append(results, result)
+	}
+	assert.Nil(t, <-errCh)
+	assert.Len(t, results, 2)
+	regionCount := 0
+	for _, result := range results {
+		assert.Nil(t, result.Err)
+		regionCount += len(result.Regions)
+	}
+	assert.Equal(t, len(keys), regionCount)
+	for _, key := range keys {
+		region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey(key))
+		assert.Equal(t, []byte(mocktikv.NewMvccKey(key)), region.GetStartKey())
+	}
+
+	// The channels are closed if the split is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	StoreProbe{store}.SetBeforeSplitSendHook(func(ctx context.Context) {
+		cancel()
+		<-ctx.Done()
+	})
+	resultCh, errCh = store.SplitRegionsStream(ctx, [][]byte{[]byte("d"), []byte("y")}, false, nil)
+	for range resultCh {
+	}
+	opErr, ok := tikverr.OperationOf(<-errCh)
+	require.True(t, ok)
+	assert.Equal(t, &tikverr.ErrOperation{Op: "SplitRegionsStream", StartKey: []byte("d"), EndKey: []byte("y"), Err: opErr.Err}, opErr)
+	_, ok = tikverr.OperationOf(opErr.Err)
+	assert.False(t, ok)
+	_, ok = <-errCh
+	assert.False(t, ok)
+
+	// The stream is abandoned after the first result, canceling ctx lets the split return without the results
+	// being received.
+	StoreProbe{store}.SetBeforeSplitSendHook(nil)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	resultCh, errCh = store.SplitRegionsStream(ctx, [][]byte{[]byte("e"), []byte("z")}, false, nil)
+	<-resultCh
+	cancel()
+	select {
+	case <-errCh:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "split doesn't return after the stream is abandoned")
+	}
+}
+
+// leaderAddrPDClient reports addr as the address of the PD leader.
+type leaderAddrPDClient struct {
+	pd.Client
+
+	addr string
+}
+
+func (c *leaderAddrPDClient) GetLeaderAddr() string {
+	return c.addr
+}
+
+func TestMergeRegions(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		operators []map[string]interface{}
+		status    = http.StatusOK
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/pd/api/v1/operators", r.URL.Path)
+		var op map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&op))
+		mu.Lock()
+		defer mu.Unlock()
+		operators = append(operators, op)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	getOperators := func() []map[string]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return operators
+	}
+	store, cluster := newTestKVStore(t, func(c pd.Client) pd.Client {
+		return &leaderAddrPDClient{Client: c, addr: server.URL}
+	}, []byte("b"), []byte("d"), []byte("f"))
+	defer store.Close()
+
+	var regionIDs []uint64
+	for _, key := range []string{"a", "b", "d", "f"} {
+		region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte(key)))
+		regionIDs = append(regionIDs, region.GetId())
+	}
+	assert.Nil(t, store.MergeRegions(context.Background(), regionIDs))
+	assert.Equal(t, []map[string]interface{}{
+		{"name": "merge-region", "source_region_id": float64(regionIDs[1]), "target_region_id": float64(regionIDs[0])},
+		{"name": "merge-region", "source_region_id": float64(regionIDs[3]), "target_region_id": float64(regionIDs[2])},
+	}, getOperators())
+
+	// The regions should be adjacent.
+	mu.Lock()
+	operators = nil
+	mu.Unlock()
+	assert.NotNil(t, store.MergeRegions(context.Background(), []uint64{regionIDs[0], regionIDs[2]}))
+	assert.Empty(t, getOperators())
+
+	// The error of PD is returned.
+	mu.Lock()
+	status = http.StatusInternalServerError
+	mu.Unlock()
+	assert.NotNil(t, store.MergeRegions(context.Background(), regionIDs[:2]))
+}
+
+func TestMergeRegionsWithFakePD(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		operators []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var op map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&op))
+		mu.Lock()
+		defer mu.Unlock()
+		operators = append(operators, op)
+	}))
+	defer server.Close()
+	fakePD := testutil.NewPDClient()
+	fakePD.SetLeaderAddr(server.URL)
+	fakePD.SetRegion(&pd.Region{Meta: &metapb.Region{Id: 10, StartKey: []byte("a"), EndKey: []byte("b")}})
+	fakePD.SetRegion(&pd.Region{Meta: &metapb.Region{Id: 11, StartKey: []byte("b"), EndKey: []byte("c")}})
+
+	client, cluster, pdClient, err := mocktikv.NewTiKVAndPDClient("", nil)
+	require.Nil(t, err)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	store, err := NewKVStore("fake-pd-store", pdClient, NewMockSafePointKV(), client, WithSplitGCPDClient(fakePD))
+	require.Nil(t, err)
+	defer store.Close()
+
+	// The regions only exist in the fake, and the merge is requested to its leader.
+	assert.Nil(t, store.MergeRegions(context.Background(), []uint64{10, 11}))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []map[string]interface{}{
+		{"name": "merge-region", "source_region_id": float64(11), "target_region_id": float64(10)},
+	}, operators)
+}
+
+func TestSplitRegionInLocation(t *testing.T) {
+	store, cluster := newTestKVStore(t, nil, []byte("m"))
+	defer store.Close()
+	bo := retry.NewBackofferWithVars(context.Background(), int(GetSplitRegionBackoff().Milliseconds()), nil)
+	loc, err := store.GetRegionCache().LocateKey(bo, []byte("n"))
+	require.Nil(t, err)
+
+	// The key at the region start is skipped, the others are split in order.
+	resp, err := store.SplitRegionInLocation(bo, loc, [][]byte{[]byte("p"), []byte("m"), []byte("o")}, false, nil)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Resp.(*kvrpcpb.SplitRegionResponse).GetRegions(), 2)
+	for _, key := range []string{"o", "p"} {
+		region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte(key)))
+		assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte(key))), region.GetStartKey())
+	}
+
+	// The keys should be in the location.
+	resp, err = store.SplitRegionInLocation(bo, loc, [][]byte{[]byte("q"), []byte("b")}, false, nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, resp)
+	opErr, ok := tikverr.OperationOf(err)
+	require.True(t, ok)
+	assert.Equal(t, "SplitRegionInLocation", opErr.Op)
+	assert.Equal(t, []byte("b"), opErr.StartKey)
+	assert.Equal(t, []byte("q"), opErr.EndKey)
+	assert.Equal(t, context.Background(), bo.GetCtx())
+	region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte("q")))
+	assert.NotEqual(t, []byte(mocktikv.NewMvccKey([]byte("q"))), region.GetStartKey())
+
+	// The location is stale after the split, the keys are located again, and the duplicated keys are split once.
+	resp, err = store.SplitRegionInLocation(bo, loc, [][]byte{[]byte("q"), []byte("q")}, false, nil)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Resp.(*kvrpcpb.SplitRegionResponse).GetRegions(), 1)
+	region, _ = cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte("q")))
+	assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte("q"))), region.GetStartKey())
+
+	resp, err = store.SplitRegionInLocation(bo, loc, [][]byte{[]byte("m")}, false, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, resp)
+}
+
+func TestSplitOversizedRegions(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		sizes = make(map[string]int64)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.True(t, strings.HasPrefix(r.URL.Path, "/pd/api/v1/region/id/"))
+		mu.Lock()
+		size := sizes[strings.TrimPrefix(r.URL.Path, "/pd/api/v1/region/id/")]
+		mu.Unlock()
+		assert.Nil(t, json.NewEncoder(w).Encode(map[string]interface{}{"approximate_size": size}))
+	}))
+	defer server.Close()
+	store, cluster := newTestKVStore(t, func(c pd.Client) pd.Client {
+		return &leaderAddrPDClient{Client: c, addr: server.URL}
+	}, []byte("b"), []byte("d"), []byte("x"), []byte("x\x00"))
+	defer store.Close()
+
+	setSize := func(key string, size int64) {
+		region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte(key)))
+		mu.Lock()
+		defer mu.Unlock()
+		sizes[strconv.FormatUint(region.GetId(), 10)] = size
+	}
+	// [b, d) is split at its midpoint, [x, x\x00) has no midpoint.
+	setSize("a", 200)
+	setSize("b", 200)
+	setSize("d", 50)
+	setSize("x", 200)
+	setSize("y", 50)
+	regionIDs, err := store.SplitOversizedRegions(context.Background(), 100, []byte("b"), []byte("y"))
+	assert.Nil(t, err)
+	assert.Len(t, regionIDs, 1)
+	region, _ := cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte("c")))
+	assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte("c"))), region.GetStartKey())
+	assert.Equal(t, []byte(mocktikv.NewMvccKey([]byte("d"))), region.GetEndKey())
+	region, _ = cluster.GetRegionByKey(mocktikv.NewMvccKey([]byte("a")))
+	assert.Empty(t, region.GetStartKey())
+
+	// Nothing to split.
+	regionIDs, err = store.SplitOversizedRegions(context.Background(), 1000, nil, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, regionIDs)
+
+	_, err = store.SplitOversizedRegions(context.Background(), 100, []byte("d"), []byte("b"))
+	assert.NotNil(t, err)
+}
+
+func TestMidpointKey(t *testing.T) {
+	assert.Equal(t, []byte("c"), midpointKey([]byte("b"), []byte("d")))
+	assert.Equal(t, []byte{0x61, 0x80}, midpointKey([]byte("a"), []byte("b")))
+	assert.Equal(t, []byte{0x7f}, midpointKey(nil, nil))
+	assert.Nil(t, midpointKey([]byte("x"), []byte("x\x00")))
+	assert.Nil(t, midpointKey([]byte("b"), []byte("b")))
+}
+
+func TestScatterRegionsWithStatus(t *testing.T) {
+	var pdCli *mockScatterPDClient
+	store, _ := newTestKVStore(t, func(c pd.Client) pd.Client {
+		pdCli = &mockScatterPDClient{Client: c}
+		return pdCli
+	})
+	defer store.Close()
+
+	// The even regions are gone.
+	pdCli.scatterRegions = func(regionIDs []uint64) (*pdpb.ScatterRegionResponse, error) {
+		if regionIDs[0]%2 == 0 {
+			return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{
+				Error: &pdpb.Error{Type: pdpb.ErrorType_REGION_NOT_FOUND},
+			}}, nil
+		}
+		return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}, FinishedPercentage: 100}, nil
+	}
+	results, err := store.ScatterRegionsWithStatus(context.Background(), []uint64{1, 2, 3, 4}, nil)
+	assert.Nil(t, err)
+	assert.Len(t, results, 4)
+	assert.Nil(t, results[1])
+	assert.Nil(t, results[3])
+	assert.True(t, isFatalScatterErr(results[2]))
+	assert.True(t, isFatalScatterErr(results[4]))
+	assert.Equal(t, 4, pdCli.scatterTimes)
+
+	// The regions not scattered are given up once the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	pdCli.scatterRegions = func([]uint64) (*pdpb.ScatterRegionResponse, error) {
+		cancel()
+		return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}, FinishedPercentage: 100}, nil
+	}
+	results, err = store.ScatterRegionsWithStatus(ctx, []uint64{1, 2, 3, 4}, nil, WithSplitConcurrency(1))
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Len(t, results, 4)
+	assert.Nil(t, results[1])
+	for _, regionID := range []uint64{2, 3, 4} {
+		assert.Equal(t, context.Canceled, errors.Cause(results[regionID]))
+	}
+}
@@ -115,6 +115,42 @@ func (s StoreProbe) SetSafeTS(storeID, safeTS uint64) {
 	s.setSafeTS(storeID, safeTS)
 }
 
+// ScanLocks scans the locks whose version <= maxVersion in the region of startKey, starting from startKey.
+func (s StoreProbe) ScanLocks(ctx context.Context, startKey []byte, maxVersion uint64) ([]*Lock, error) {
+	opts := newGCOptions(nil)
+	bo := NewGcResolveLockMaxBackoffer(ctx)
+	locks, _, err := s.scanLocksInRegionWithStartKey(bo, startKey, maxVersion, uint32(s.gcScanLockLimit(opts)), opts)
+	return locks, err
+}
+
+// testHooks are the seams for tests to inject behaviors into the split and scatter paths. They're nil by default.
+type testHooks struct {
+	// beforeSplitSend is called before sending each split batch request.
+	beforeSplitSend func(ctx context.Context)
+	// scatterErr overrides the error of scattering the region if it returns a non-nil error.
+	scatterErr func(regionID uint64) error
+}
+
+// SetBeforeSplitSendHook sets a function called with the context of the request before sending each split
+// batch request. It's a seam to simulate slow or timed out splits without the failpoint toolchain, e.g. block
+// until the context is done. It should be set before using the store.
+func (s StoreProbe) SetBeforeSplitSendHook(fn func(ctx context.Context)) {
+	s.hooks.beforeSplitSend = fn
+}
+
+// SetScatterErrHook sets a function to override the result of scattering a region, the non-nil error it returns
+// is treated as the error of the scatter request, e.g. tikverr.NewErrPDServerTimeout simulates a PD timeout.
+// It should be set before using the store.
+func (s StoreProbe) SetScatterErrHook(fn func(regionID uint64) error) {
+	s.hooks.scatterErr = fn
+}
+
+// SetSplitGCPDClient replaces the PD client used by the split, scatter and GC paths, e.g. with a fake of
+// testutil.PDClient, so they can be tested deterministically. It should be set before using the store.
+func (s StoreProbe) SetSplitGCPDClient(client PDClient) {
+	s.splitGCPDClient = client
+}
+
 // TxnProbe wraps a txn and exports internal states for testing purpose.
 type TxnProbe struct {
 	*KVTxn
@@ -464,25 +500,25 @@ type LockResolverProbe struct {
 
 // ResolveLockAsync tries to resolve a lock using the txn states.
 func (l LockResolverProbe) ResolveLockAsync(bo *Backoffer, lock *Lock, status TxnStatus) error {
-	return l.resolveLockAsync(bo, lock, status)
+	return l.resolveLockAsync(bo, lock, status, kvrpcpb.Context{})
 }
 
 // ResolveLock resolves single lock.
 func (l LockResolverProbe) ResolveLock(ctx context.Context, lock *Lock) error {
 	bo := retry.NewBackofferWithVars(ctx, pessimisticLockMaxBackoff, nil)
-	return l.resolveLock(bo, lock, TxnStatus{}, false, make(map[locate.RegionVerID]struct{}))
+	return l.resolveLock(bo, lock, TxnStatus{}, false, make(map[locate.RegionVerID]struct{}), kvrpcpb.Context{})
 }
 
 // ResolvePessimisticLock resolves single pessimistic lock.
 func (l LockResolverProbe) ResolvePessimisticLock(ctx context.Context, lock *Lock) error {
 	bo := retry.NewBackofferWithVars(ctx, pessimisticLockMaxBackoff, nil)
-	return l.resolvePessimisticLock(bo, lock, make(map[locate.RegionVerID]struct{}))
+	return l.resolvePessimisticLock(bo, lock, make(map[locate.RegionVerID]struct{}), kvrpcpb.Context{})
 }
 
 // GetTxnStatus sends the CheckTxnStatus request to the TiKV server.
 func (l LockResolverProbe) GetTxnStatus(bo *Backoffer, txnID uint64, primary []byte,
 	callerStartTS, currentTS uint64, rollbackIfNotExist bool, forceSyncCommit bool, lockInfo *Lock) (TxnStatus, error) {
-	return l.getTxnStatus(bo, txnID, primary, callerStartTS, currentTS, rollbackIfNotExist, forceSyncCommit, lockInfo)
+	return l.getTxnStatus(bo, txnID, primary, callerStartTS, currentTS, rollbackIfNotExist, forceSyncCommit, lockInfo, kvrpcpb.Context{})
 }
 
 // GetTxnStatusFromLock queries tikv for a txn's status.
@@ -503,7 +539,7 @@ func (l LockResolverProbe) SetMeetLockCallback(f func([]*Lock)) {
 // CheckAllSecondaries checks the secondary locks of an async commit transaction to find out the final
 // status of the transaction.
 func (l LockResolverProbe) CheckAllSecondaries(bo *Backoffer, lock *Lock, status *TxnStatus) error {
-	_, err := l.checkAllSecondaries(bo, lock, status)
+	_, err := l.checkAllSecondaries(bo, lock, status, kvrpcpb.Context{})
 	return err
 }
 
@@ -0,0 +1,183 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testutil provides in-memory fakes for testing the split, scatter and GC paths of tikv.KVStore.
+package testutil
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	pd "github.com/tikv/pd/client"
+)
+
+// scatterOperatorDesc is the desc of the operators created by scattering regions.
+const scatterOperatorDesc = "scatter-region"
+
+// PDClient is an in-memory fake of tikv.PDClient. Scattering a region creates a running scatter operator, which
+// is kept running until FinishScatter is called, so the scatter waits can be tested deterministically. The GC
+// safepoint never moves backward, like PD does. It's safe for concurrent use.
+type PDClient struct {
+	mu struct {
+		sync.Mutex
+		leaderAddr  string
+		stores      []*metapb.Store
+		regions     map[uint64]*pd.Region
+		operators   map[uint64]*pdpb.GetOperatorResponse
+		scatterErrs map[uint64]error
+		scattered   map[uint64]int
+		safePoint   uint64
+		// serviceSafePoint holds back the GC safepoint if it's not 0.
+		serviceSafePoint uint64
+	}
+}
+
+// NewPDClient creates a PDClient with the given stores.
+func NewPDClient(stores ...*metapb.Store) *PDClient {
+	c := &PDClient{}
+	c.mu.stores = stores
+	c.mu.regions = make(map[uint64]*pd.Region)
+	c.mu.operators = make(map[uint64]*pdpb.GetOperatorResponse)
+	c.mu.scatterErrs = make(map[uint64]error)
+	c.mu.scattered = make(map[uint64]int)
+	return c
+}
+
+// ScatterRegions creates a running scatter operator for each region. It fails if an error is set for any of
+// the regions by SetScatterError, and no operator is created then.
+func (c *PDClient) ScatterRegions(ctx context.Context, regionsID []uint64, opts ...pd.RegionsOption) (*pdpb.ScatterRegionResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range regionsID {
+		c.mu.scattered[id]++
+		if err := c.mu.scatterErrs[id]; err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range regionsID {
+		c.mu.operators[id] = &pdpb.GetOperatorResponse{
+			Header:   &pdpb.ResponseHeader{},
+			RegionId: id,
+			Desc:     []byte(scatterOperatorDesc),
+			Status:   pdpb.OperatorStatus_RUNNING,
+		}
+	}
+	return &pdpb.ScatterRegionResponse{Header: &pdpb.ResponseHeader{}, FinishedPercentage: 100}, nil
+}
+
+// GetOperator returns the operator of the region, or a response without operator if there isn't one.
+func (c *PDClient) GetOperator(ctx context.Context, regionID uint64) (*pdpb.GetOperatorResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if op, ok := c.mu.operators[regionID]; ok {
+		return op, nil
+	}
+	return &pdpb.GetOperatorResponse{Header: &pdpb.ResponseHeader{}, RegionId: regionID}, nil
+}
+
+// UpdateGCSafePoint moves the GC safepoint forward and returns the new one. The safepoint is left unchanged if
+// the given one is smaller, and it doesn't exceed the service safepoint set by SetServiceSafePoint.
+func (c *PDClient) UpdateGCSafePoint(ctx context.Context, safePoint uint64) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mu.serviceSafePoint != 0 && safePoint > c.mu.serviceSafePoint {
+		safePoint = c.mu.serviceSafePoint
+	}
+	if safePoint > c.mu.safePoint {
+		c.mu.safePoint = safePoint
+	}
+	return c.mu.safePoint, nil
+}
+
+// GetAllStores returns the stores.
+func (c *PDClient) GetAllStores(ctx context.Context, opts ...pd.GetStoreOption) ([]*metapb.Store, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*metapb.Store(nil), c.mu.stores...), nil
+}
+
+// GetRegionByID returns the region set by SetRegion, or nil if there isn't one, like PD does for a missing region.
+func (c *PDClient) GetRegionByID(ctx context.Context, regionID uint64) (*pd.Region, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.regions[regionID], nil
+}
+
+// GetLeaderAddr returns the address set by SetLeaderAddr, e.g. of a httptest.Server faking the HTTP API of PD.
+func (c *PDClient) GetLeaderAddr() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.leaderAddr
+}
+
+// SetLeaderAddr sets the address returned by GetLeaderAddr.
+func (c *PDClient) SetLeaderAddr(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.leaderAddr = addr
+}
+
+// SetRegion adds or replaces the region returned by GetRegionByID, keyed by the ID of its meta.
+func (c *PDClient) SetRegion(region *pd.Region) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.regions[region.Meta.GetId()] = region
+}
+
+// FinishScatter marks the scatter operator of the region as finished successfully.
+func (c *PDClient) FinishScatter(regionID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if op, ok := c.mu.operators[regionID]; ok {
+		c.mu.operators[regionID] = &pdpb.GetOperatorResponse{
+			Header:   op.Header,
+			RegionId: regionID,
+			Desc:     op.Desc,
+			Status:   pdpb.OperatorStatus_SUCCESS,
+		}
+	}
+}
+
+// SetScatterError makes scattering the region fail with err, nil clears it.
+func (c *PDClient) SetScatterError(regionID uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.mu.scatterErrs, regionID)
+		return
+	}
+	c.mu.scatterErrs[regionID] = err
+}
+
+// ScatterCount returns the number of times the region is requested to scatter, including the failed ones.
+func (c *PDClient) ScatterCount(regionID uint64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.scattered[regionID]
+}
+
+// SetServiceSafePoint makes the GC safepoint held back by a service safepoint, 0 clears it.
+func (c *PDClient) SetServiceSafePoint(safePoint uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.serviceSafePoint = safePoint
+}
+
+// GCSafePoint returns the current GC safepoint.
+func (c *PDClient) GCSafePoint() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.safePoint
+}
@@ -96,6 +96,17 @@ func SetSessionID(ctx context.Context, sessionID uint64) context.Context {
 	return context.WithValue(ctx, SessionID, sessionID)
 }
 
+type operationIDCtxKey struct{}
+
+// OperationID is the context key type to carry the ID of an operation, e.g. a SplitRegions or GC call.
+// The logs of the operation carry the ID, so they can be correlated.
+var OperationID = operationIDCtxKey{}
+
+// SetOperationID sets operation id into context
+func SetOperationID(ctx context.Context, operationID string) context.Context {
+	return context.WithValue(ctx, OperationID, operationID)
+}
+
 const (
 	byteSizeGB = int64(1 << 30)
 	byteSizeMB = int64(1 << 20)